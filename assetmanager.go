@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// AssetManager loads each asset once and shares it between users through
+// reference counted handles. When the last handle is released the GPU
+// objects are freed straight away if AutoUnload is set, otherwise on the
+// next Collect, so assets shared by consecutive levels are not reloaded.
+type AssetManager struct {
+	FS         fs.FS
+	AutoUnload bool
+
+	// Programs holds every loaded program by asset key, it can be handed to
+	// a ShaderWatcher so loaded programs are hot reloaded.
+	Programs map[string]*ShaderProgram
+
+	// Loader, when set, decodes textures in the background
+	Loader *TextureLoader
+
+	entries map[string]*assetEntry
+}
+
+type assetEntry struct {
+	key      string
+	refs     int
+	value    interface{}
+	free     func()
+	unloaded bool
+}
+
+// AssetHandle is implemented by every typed handle
+type AssetHandle interface {
+	Key() string
+	Release()
+}
+
+func NewAssetManager(fsys fs.FS) *AssetManager {
+	return &AssetManager{
+		FS:       fsys,
+		Programs: map[string]*ShaderProgram{},
+		entries:  map[string]*assetEntry{},
+	}
+}
+
+// acquire returns the cached entry for key with one more reference, loading
+// it with load the first time.
+func (m *AssetManager) acquire(key string, load func() (interface{}, func(), error)) (*assetEntry, error) {
+	if e, ok := m.entries[key]; ok {
+		e.refs++
+		return e, nil
+	}
+	value, free, err := load()
+	if err != nil {
+		return nil, err
+	}
+	e := &assetEntry{key: key, refs: 1, value: value, free: free}
+	m.entries[key] = e
+	return e, nil
+}
+
+// release drops a reference. Releasing more often than acquiring is a bug
+// in the caller, but not one worth crashing over, so it is only reported.
+// Handles still held at Shutdown may be released afterwards.
+func (m *AssetManager) release(e *assetEntry) {
+	if e == nil || e.unloaded {
+		return
+	}
+	if e.refs <= 0 {
+		fmt.Printf("asset %q released more times than it was acquired\n", e.key)
+		return
+	}
+	e.refs--
+	if e.refs == 0 && m.AutoUnload {
+		m.unload(e)
+	}
+}
+
+// retain adds a reference for a copied handle. An unloaded entry's GL
+// objects are gone and a new load has its own entry, so retaining one is a
+// bug that would otherwise show up as drawing with deleted objects.
+func retain(e *assetEntry) {
+	if e.unloaded {
+		panic(fmt.Sprintf("asset %q retained after it was unloaded, acquire it again instead", e.key))
+	}
+	e.refs++
+}
+
+func (m *AssetManager) unload(e *assetEntry) {
+	e.unloaded = true
+	if e.free != nil {
+		e.free()
+	}
+	delete(m.entries, e.key)
+	if strings.HasPrefix(e.key, "program:") {
+		delete(m.Programs, e.key)
+	}
+}
+
+// Collect frees every asset that is no longer referenced
+func (m *AssetManager) Collect() int {
+	freed := 0
+	for _, e := range m.entries {
+		if e.refs == 0 {
+			m.unload(e)
+			freed++
+		}
+	}
+	return freed
+}
+
+// Shutdown frees everything and returns the keys of assets that still had
+// references, which are leaks in the code that loaded them.
+func (m *AssetManager) Shutdown() []string {
+	var leaked []string
+	for key, e := range m.entries {
+		if e.refs > 0 {
+			leaked = append(leaked, fmt.Sprintf("%s (%d refs)", key, e.refs))
+		}
+		m.unload(e)
+	}
+	sort.Strings(leaked)
+	return leaked
+}
+
+// Loaded returns the number of assets currently held
+func (m *AssetManager) Loaded() int {
+	return len(m.entries)
+}
+
+type TextureHandle struct {
+	m *AssetManager
+	e *assetEntry
+}
+
+func (h TextureHandle) texture() *Texture {
+	if h.e == nil {
+		return nil
+	}
+	return h.e.value.(*Texture)
+}
+
+// ID is the GL texture name. While the texture is loading, or if it failed,
+// it is the loader's placeholder, and 0 for an empty handle.
+func (h TextureHandle) ID() uint32 {
+	t := h.texture()
+	if t == nil {
+		return 0
+	}
+	if t.ID == 0 {
+		return t.placeholder
+	}
+	return t.ID
+}
+
+// Ready reports whether the texture has finished loading
+func (h TextureHandle) Ready() bool {
+	t := h.texture()
+	return t != nil && t.Ready()
+}
+
+// Target is what the texture binds to, TEXTURE_2D until it has loaded
+func (h TextureHandle) Target() uint32 {
+	if t := h.texture(); t != nil && t.Target != 0 {
+		return t.Target
+	}
+	return gl.TEXTURE_2D
+}
+
+// Err is the error the texture failed to load with, if any
+func (h TextureHandle) Err() error {
+	if t := h.texture(); t != nil {
+		return t.Err
+	}
+	return nil
+}
+
+func (h TextureHandle) Key() string {
+	return h.e.key
+}
+
+func (h TextureHandle) Release() {
+	if h.m != nil {
+		h.m.release(h.e)
+	}
+}
+
+// Retain returns another handle to the same texture
+func (h TextureHandle) Retain() TextureHandle {
+	retain(h.e)
+	return h
+}
+
+// LoadTexture returns a handle to file. With a Loader the handle is
+// returned at once and the texture arrives on a later Loader.Upload,
+// otherwise it is loaded now. Either way a failure is kept on the handle.
+func (m *AssetManager) LoadTexture(file string) (TextureHandle, error) {
+	file = NormalizeVFSPath(file)
+	e, err := m.acquire("texture:"+file, func() (interface{}, func(), error) {
+		tex := &Texture{File: file}
+		if m.Loader != nil {
+			m.Loader.Load(tex)
+		} else {
+			var data *textureData
+			tex.Options, data, tex.Err = decodeTextureFile(m.FS, file)
+			if tex.Err == nil {
+				tex.ID, tex.Target, tex.Err = data.upload(tex.Options)
+			}
+			tex.done = true
+		}
+		return tex, tex.free, nil
+	})
+	if err != nil {
+		return TextureHandle{}, err
+	}
+	h := TextureHandle{m: m, e: e}
+	return h, h.Err()
+}
+
+// LoadTextureDir loads every image in dir, keyed by file name like
+// LoadTextures. A file that fails to load doesn't stop the others, check
+// Err on its handle.
+func (m *AssetManager) LoadTextureDir(dir string) (map[string]TextureHandle, error) {
+	files, err := fs.ReadDir(m.FS, NormalizeVFSPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	textures := map[string]TextureHandle{}
+	for _, f := range files {
+		if f.IsDir() || IsTextureSidecar(f.Name()) {
+			continue
+		}
+		h, _ := m.LoadTexture(path.Join(dir, f.Name()))
+		textures[f.Name()] = h
+	}
+	return textures, nil
+}
+
+type ProgramHandle struct {
+	m *AssetManager
+	e *assetEntry
+}
+
+func (h ProgramHandle) Program() *ShaderProgram {
+	return h.e.value.(*ShaderProgram)
+}
+
+func (h ProgramHandle) Key() string {
+	return h.e.key
+}
+
+func (h ProgramHandle) Release() {
+	if h.m != nil {
+		h.m.release(h.e)
+	}
+}
+
+func (h ProgramHandle) Retain() ProgramHandle {
+	retain(h.e)
+	return h
+}
+
+func (m *AssetManager) LoadProgram(files ...string) (ProgramHandle, error) {
+	return m.LoadProgramVariant(nil, files...)
+}
+
+// LoadProgramVariant loads the files preprocessed with defines, each set of
+// defines is compiled the first time it is asked for and cached like any
+// other program
+func (m *AssetManager) LoadProgramVariant(defines ShaderDefines, files ...string) (ProgramHandle, error) {
+	normalized := make([]string, len(files))
+	for i, f := range files {
+		normalized[i] = NormalizeVFSPath(f)
+	}
+	key := "program:" + strings.Join(normalized, "|")
+	if len(defines) > 0 {
+		key += "[" + defines.Key() + "]"
+	}
+	e, err := m.acquire(key, func() (interface{}, func(), error) {
+		p, err := NewShaderProgramVariant(m.FS, defines, normalized...)
+		if err != nil {
+			return nil, nil, err
+		}
+		m.Programs[key] = p
+		return p, p.Delete, nil
+	})
+	if err != nil {
+		return ProgramHandle{}, err
+	}
+	return ProgramHandle{m: m, e: e}, nil
+}
+
+// Mesh is the GPU side of a mesh: a vertex array and the buffers it uses
+type Mesh struct {
+	VAO     uint32
+	Buffers []uint32
+}
+
+type MeshHandle struct {
+	m *AssetManager
+	e *assetEntry
+}
+
+func (h MeshHandle) Mesh() *Mesh {
+	return h.e.value.(*Mesh)
+}
+
+func (h MeshHandle) Key() string {
+	return h.e.key
+}
+
+func (h MeshHandle) Release() {
+	if h.m != nil {
+		h.m.release(h.e)
+	}
+}
+
+func (h MeshHandle) Retain() MeshHandle {
+	retain(h.e)
+	return h
+}
+
+// LoadMesh returns the named mesh, calling create to build it the first time
+func (m *AssetManager) LoadMesh(name string, create func() (*Mesh, error)) (MeshHandle, error) {
+	e, err := m.acquire("mesh:"+name, func() (interface{}, func(), error) {
+		mesh, err := create()
+		if err != nil {
+			return nil, nil, err
+		}
+		free := func() {
+			gl.DeleteVertexArrays(1, &mesh.VAO)
+			if len(mesh.Buffers) > 0 {
+				gl.DeleteBuffers(int32(len(mesh.Buffers)), &mesh.Buffers[0])
+			}
+		}
+		return mesh, free, nil
+	})
+	if err != nil {
+		return MeshHandle{}, err
+	}
+	return MeshHandle{m: m, e: e}, nil
+}
+
+type TextureArrayHandle struct {
+	m *AssetManager
+	e *assetEntry
+}
+
+func (h TextureArrayHandle) Array() *TextureArray {
+	return h.e.value.(*TextureArray)
+}
+
+// ID and Target let the handle fill a sampler slot
+func (h TextureArrayHandle) ID() uint32 {
+	return h.Array().ID
+}
+
+func (h TextureArrayHandle) Target() uint32 {
+	return gl.TEXTURE_2D_ARRAY
+}
+
+func (h TextureArrayHandle) Key() string {
+	return h.e.key
+}
+
+func (h TextureArrayHandle) Release() {
+	if h.m != nil {
+		h.m.release(h.e)
+	}
+}
+
+func (h TextureArrayHandle) Retain() TextureArrayHandle {
+	retain(h.e)
+	return h
+}
+
+// LoadTextureArray returns a handle to the array of every image in dir,
+// see LoadTextureArray. It is loaded now even with a Loader, the layers
+// have to be checked against each other before anything is uploaded.
+func (m *AssetManager) LoadTextureArray(dir string) (TextureArrayHandle, error) {
+	dir = NormalizeVFSPath(dir)
+	e, err := m.acquire("texturearray:"+dir, func() (interface{}, func(), error) {
+		array, err := LoadTextureArray(m.FS, dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		return array, array.Delete, nil
+	})
+	if err != nil {
+		return TextureArrayHandle{}, err
+	}
+	return TextureArrayHandle{m: m, e: e}, nil
+}
+
+// isDir reports whether name is a directory, for assets that can be a file
+// or a directory of them
+func (m *AssetManager) isDir(name string) bool {
+	info, err := fs.Stat(m.FS, NormalizeVFSPath(name))
+	return err == nil && info.IsDir()
+}
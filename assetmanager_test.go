@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+// loadTest acquires a mesh entry that counts how often it is freed
+func loadTest(t *testing.T, m *AssetManager, name string, freed *int) MeshHandle {
+	e, err := m.acquire("mesh:"+name, func() (interface{}, func(), error) {
+		return &Mesh{}, func() { *freed++ }, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return MeshHandle{m: m, e: e}
+}
+
+func TestAssetManagerRefs(t *testing.T) {
+	m := NewAssetManager(fstest.MapFS{})
+	freed := 0
+	a := loadTest(t, m, "cube", &freed)
+	b := loadTest(t, m, "cube", &freed)
+	c := a.Retain()
+	if a.e != b.e || a.e.refs != 3 {
+		t.Fatalf("shared entry has %d refs, want 3", a.e.refs)
+	}
+	a.Release()
+	b.Release()
+	if n := m.Collect(); n != 0 || freed != 0 {
+		t.Errorf("collected %d with a handle left", n)
+	}
+	c.Release()
+	if n := m.Collect(); n != 1 || freed != 1 || m.Loaded() != 0 {
+		t.Errorf("collected %d, freed %d, %d loaded, want the mesh freed once", n, freed, m.Loaded())
+	}
+
+	// over-release is reported, not a crash, and frees nothing twice
+	c.Release()
+	if freed != 1 {
+		t.Errorf("freed %d times after releasing an unloaded asset", freed)
+	}
+}
+
+func TestAssetManagerOverRelease(t *testing.T) {
+	m := NewAssetManager(fstest.MapFS{})
+	freed := 0
+	h := loadTest(t, m, "quad", &freed)
+	h.Release()
+	h.Release()
+	if h.e.refs != 0 || freed != 0 {
+		t.Errorf("refs %d, freed %d after over-release, want 0 and 0", h.e.refs, freed)
+	}
+}
+
+func TestAssetManagerRetainUnloaded(t *testing.T) {
+	m := NewAssetManager(fstest.MapFS{})
+	m.AutoUnload = true
+	freed := 0
+	h := loadTest(t, m, "quad", &freed)
+	h.Release()
+	if freed != 1 {
+		t.Fatalf("freed %d times with AutoUnload, want 1", freed)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("retaining an unloaded asset didn't panic")
+		}
+	}()
+	h.Retain()
+}
+
+func TestAssetManagerShutdown(t *testing.T) {
+	m := NewAssetManager(fstest.MapFS{})
+	freed := 0
+	leak := loadTest(t, m, "leak", &freed)
+	loadTest(t, m, "done", &freed).Release()
+	leaked := m.Shutdown()
+	if len(leaked) != 1 || leaked[0] != "mesh:leak (1 refs)" || freed != 2 {
+		t.Errorf("leaked %v, freed %d, want the leak reported and both freed", leaked, freed)
+	}
+	leak.Release()
+	if freed != 2 {
+		t.Errorf("releasing after Shutdown freed again")
+	}
+}
+
+func TestAssetManagerLoadError(t *testing.T) {
+	m := NewAssetManager(fstest.MapFS{})
+	fail := errors.New("no such mesh")
+	if _, err := m.LoadMesh("bad", func() (*Mesh, error) { return nil, fail }); err != fail {
+		t.Errorf("got %v, want the create error", err)
+	}
+	if m.Loaded() != 0 {
+		t.Errorf("a failed load left %d entries", m.Loaded())
+	}
+}
+
+func TestAssetManagerIsDir(t *testing.T) {
+	m := NewAssetManager(fstest.MapFS{
+		"textures/container.jpg":     {},
+		"textures/terrain/grass.png": {},
+		"textures/terrain/stone.png": {},
+		"textures/terrain.json":      {},
+	})
+	tests := map[string]bool{
+		"textures/terrain":       true,
+		"textures/terrain/":      true,
+		"textures/container.jpg": false,
+		"textures/missing":       false,
+	}
+	for name, want := range tests {
+		if got := m.isDir(name); got != want {
+			t.Errorf("isDir(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
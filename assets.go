@@ -0,0 +1,29 @@
+package main
+
+import (
+	"embed"
+	"os"
+)
+
+// The built in shaders, textures and materials are compiled into the binary
+// so it runs from any working directory.
+//
+//go:embed shaders textures materials
+var embeddedAssets embed.FS
+
+// Mount priorities of the default asset layers, packs are mounted above
+// both so mods and patches override everything shipped with the game.
+const (
+	EmbeddedAssetPriority = 0
+	WorkingDirPriority    = 10
+	PackPriority          = 20
+)
+
+// DefaultAssets serves files from the working directory when they exist and
+// falls back to the embedded copies, so shaders can still be edited on disk.
+func DefaultAssets() *VFS {
+	assets := NewVFS()
+	assets.Mount("", embeddedAssets, EmbeddedAssetPriority)
+	assets.Mount("", os.DirFS("."), WorkingDirPriority)
+	return assets
+}
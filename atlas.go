@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AtlasOptions controls how images are packed into atlas pages
+type AtlasOptions struct {
+	PageWidth  int
+	PageHeight int
+
+	// Padding is left empty around each image so filtering and mipmaps
+	// don't pick up the neighbours, Bleed of it is filled by repeating the
+	// image's edge texels.
+	Padding int
+	Bleed   int
+}
+
+func DefaultAtlasOptions() AtlasOptions {
+	return AtlasOptions{PageWidth: 1024, PageHeight: 1024, Padding: 2, Bleed: 2}
+}
+
+// AtlasEntry is where one image ended up. X, Y, Width and Height are in
+// texels, the UV rect is in texture coordinates with V0 at row Y.
+type AtlasEntry struct {
+	Page   int     `json:"page"`
+	X      int     `json:"x"`
+	Y      int     `json:"y"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	U0     float32 `json:"u0"`
+	V0     float32 `json:"v0"`
+	U1     float32 `json:"u1"`
+	V1     float32 `json:"v1"`
+}
+
+// AtlasManifest maps image names to their place in the pages
+type AtlasManifest struct {
+	PageWidth  int                   `json:"pageWidth"`
+	PageHeight int                   `json:"pageHeight"`
+	Pages      []string              `json:"pages"`
+	Entries    map[string]AtlasEntry `json:"entries"`
+}
+
+type Atlas struct {
+	Pages    []*image.RGBA
+	Manifest AtlasManifest
+}
+
+// AtlasImage is an image to pack with the name it is looked up by
+type AtlasImage struct {
+	Name  string
+	Image image.Image
+}
+
+// maxRects tracks the free space of one page as possibly overlapping
+// maximal rectangles
+type maxRects struct {
+	free []image.Rectangle
+}
+
+func newMaxRects(w, h int) *maxRects {
+	return &maxRects{free: []image.Rectangle{image.Rect(0, 0, w, h)}}
+}
+
+// insert places a w x h rectangle by best short side fit: in the free
+// rectangle that leaves the smallest leftover on its tighter side.
+func (m *maxRects) insert(w, h int) (image.Rectangle, bool) {
+	var best image.Rectangle
+	bestShort, bestLong := -1, -1
+	for _, f := range m.free {
+		if f.Dx() < w || f.Dy() < h {
+			continue
+		}
+		short, long := f.Dx()-w, f.Dy()-h
+		if short > long {
+			short, long = long, short
+		}
+		if bestShort < 0 || short < bestShort || (short == bestShort && long < bestLong) {
+			best = image.Rect(f.Min.X, f.Min.Y, f.Min.X+w, f.Min.Y+h)
+			bestShort, bestLong = short, long
+		}
+	}
+	if bestShort < 0 {
+		return image.Rectangle{}, false
+	}
+	m.place(best)
+	return best, true
+}
+
+// place splits every free rectangle the used one overlaps and drops free
+// rectangles contained in others
+func (m *maxRects) place(used image.Rectangle) {
+	var next []image.Rectangle
+	for _, f := range m.free {
+		if !f.Overlaps(used) {
+			next = append(next, f)
+			continue
+		}
+		if used.Min.X > f.Min.X {
+			next = append(next, image.Rect(f.Min.X, f.Min.Y, used.Min.X, f.Max.Y))
+		}
+		if used.Max.X < f.Max.X {
+			next = append(next, image.Rect(used.Max.X, f.Min.Y, f.Max.X, f.Max.Y))
+		}
+		if used.Min.Y > f.Min.Y {
+			next = append(next, image.Rect(f.Min.X, f.Min.Y, f.Max.X, used.Min.Y))
+		}
+		if used.Max.Y < f.Max.Y {
+			next = append(next, image.Rect(f.Min.X, used.Max.Y, f.Max.X, f.Max.Y))
+		}
+	}
+
+	m.free = m.free[:0]
+	for i, a := range next {
+		contained := false
+		for j, b := range next {
+			if i != j && a.In(b) && (a != b || j < i) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			m.free = append(m.free, a)
+		}
+	}
+}
+
+// PackRects places rectangles of the given sizes on as few pages as it
+// can. It returns the page and rectangle of each size, in input order.
+// The result only depends on the input, so atlases rebuild identically.
+func PackRects(sizes []image.Point, pageWidth, pageHeight int) ([]int, []image.Rectangle, error) {
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	// Big first packs tighter, ties keep input order
+	sort.SliceStable(order, func(a, b int) bool {
+		sa, sb := sizes[order[a]], sizes[order[b]]
+		if sa.Y != sb.Y {
+			return sa.Y > sb.Y
+		}
+		return sa.X > sb.X
+	})
+
+	pages := make([]int, len(sizes))
+	rects := make([]image.Rectangle, len(sizes))
+	var packers []*maxRects
+	for _, i := range order {
+		s := sizes[i]
+		if s.X > pageWidth || s.Y > pageHeight {
+			return nil, nil, fmt.Errorf("a %dx%d rectangle doesn't fit on a %dx%d page", s.X, s.Y, pageWidth, pageHeight)
+		}
+		placed := false
+		for p, packer := range packers {
+			if r, ok := packer.insert(s.X, s.Y); ok {
+				pages[i], rects[i], placed = p, r, true
+				break
+			}
+		}
+		if !placed {
+			packer := newMaxRects(pageWidth, pageHeight)
+			r, _ := packer.insert(s.X, s.Y)
+			packers = append(packers, packer)
+			pages[i], rects[i] = len(packers)-1, r
+		}
+	}
+	return pages, rects, nil
+}
+
+// BuildAtlas packs images into pages. Names must be unique, images are
+// packed in name order so the same set always gives the same atlas.
+func BuildAtlas(images []AtlasImage, opts AtlasOptions) (*Atlas, error) {
+	if opts.Bleed > opts.Padding {
+		return nil, errors.New("atlas bleed can't be wider than the padding")
+	}
+	images = append([]AtlasImage(nil), images...)
+	sort.Slice(images, func(i, j int) bool { return images[i].Name < images[j].Name })
+
+	sizes := make([]image.Point, len(images))
+	for i, img := range images {
+		if i > 0 && images[i-1].Name == img.Name {
+			return nil, fmt.Errorf("atlas has two images named %q", img.Name)
+		}
+		sizes[i] = img.Image.Bounds().Size().Add(image.Pt(2*opts.Padding, 2*opts.Padding))
+	}
+	pages, cells, err := PackRects(sizes, opts.PageWidth, opts.PageHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	atlas := &Atlas{Manifest: AtlasManifest{
+		PageWidth:  opts.PageWidth,
+		PageHeight: opts.PageHeight,
+		Entries:    map[string]AtlasEntry{},
+	}}
+	for i, img := range images {
+		for pages[i] >= len(atlas.Pages) {
+			atlas.Pages = append(atlas.Pages, image.NewRGBA(image.Rect(0, 0, opts.PageWidth, opts.PageHeight)))
+		}
+		page := atlas.Pages[pages[i]]
+		b := img.Image.Bounds()
+		r := image.Rectangle{cells[i].Min.Add(image.Pt(opts.Padding, opts.Padding)), image.Point{}}
+		r.Max = r.Min.Add(b.Size())
+		draw.Draw(page, r, img.Image, b.Min, draw.Src)
+		bleedEdges(page, r, opts.Bleed)
+
+		atlas.Manifest.Entries[img.Name] = AtlasEntry{
+			Page:   pages[i],
+			X:      r.Min.X,
+			Y:      r.Min.Y,
+			Width:  r.Dx(),
+			Height: r.Dy(),
+			U0:     float32(r.Min.X) / float32(opts.PageWidth),
+			V0:     float32(r.Min.Y) / float32(opts.PageHeight),
+			U1:     float32(r.Max.X) / float32(opts.PageWidth),
+			V1:     float32(r.Max.Y) / float32(opts.PageHeight),
+		}
+	}
+	return atlas, nil
+}
+
+// bleedEdges copies the border texels of r outwards by n texels
+func bleedEdges(page *image.RGBA, r image.Rectangle, n int) {
+	if n <= 0 || r.Empty() {
+		return
+	}
+	out := r.Inset(-n).Intersect(page.Rect)
+	for y := out.Min.Y; y < out.Max.Y; y++ {
+		sy := clampInt(y, r.Min.Y, r.Max.Y-1)
+		for x := out.Min.X; x < out.Max.X; x++ {
+			if (image.Point{x, y}).In(r) {
+				continue
+			}
+			sx := clampInt(x, r.Min.X, r.Max.X-1)
+			copy(page.Pix[page.PixOffset(x, y):page.PixOffset(x, y)+4], page.Pix[page.PixOffset(sx, sy):])
+		}
+	}
+}
+
+// PackAtlasDir packs every image in dir, named by file name without the
+// extension
+func PackAtlasDir(fsys fs.FS, dir string, opts AtlasOptions) (*Atlas, error) {
+	files, err := fs.ReadDir(fsys, path.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	var images []AtlasImage
+	for _, f := range files {
+		if f.IsDir() || IsTextureSidecar(f.Name()) {
+			continue
+		}
+		img, err := DecodeImage(fsys, path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, AtlasImage{Name: strings.TrimSuffix(f.Name(), path.Ext(f.Name())), Image: img})
+	}
+	return BuildAtlas(images, opts)
+}
+
+// Save writes the pages as name_0.png, name_1.png, ... and the manifest as
+// name.json into dir
+func (a *Atlas) Save(dir, name string) error {
+	a.Manifest.Pages = nil
+	for i, page := range a.Pages {
+		file := fmt.Sprintf("%s_%d.png", name, i)
+		f, err := os.Create(filepath.Join(dir, file))
+		if err != nil {
+			return err
+		}
+		err = png.Encode(f, page)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		a.Manifest.Pages = append(a.Manifest.Pages, file)
+	}
+	b, err := json.MarshalIndent(a.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, name+".json"), b, 0644)
+}
+
+func LoadAtlasManifest(fsys fs.FS, file string) (*AtlasManifest, error) {
+	b, err := fs.ReadFile(fsys, path.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	m := &AtlasManifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("atlas manifest %q: %v", file, err)
+	}
+	return m, nil
+}
+
+// RemapUV maps a coordinate in [0, 1] over a whole texture into the
+// entry's rect. flipV is for meshes drawn by shaders that sample at 1 - v,
+// like basic_tex.vert.
+func (e AtlasEntry) RemapUV(u, v float32, flipV bool) (float32, float32) {
+	u = e.U0 + u*(e.U1-e.U0)
+	if flipV {
+		return u, 1 - (e.V0 + (1-v)*(e.V1-e.V0))
+	}
+	return u, e.V0 + v*(e.V1-e.V0)
+}
+
+// RemapUVs returns a copy of interleaved vertices with the UVs at uvOffset
+// floats into each vertex of stride floats moved into the entry's rect
+func RemapUVs(vertices []float32, stride, uvOffset int, e AtlasEntry, flipV bool) []float32 {
+	out := append([]float32(nil), vertices...)
+	for i := uvOffset; i+1 < len(out); i += stride {
+		out[i], out[i+1] = e.RemapUV(out[i], out[i+1], flipV)
+	}
+	return out
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func solidImage(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestPackRectsNoOverlap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	sizes := make([]image.Point, 200)
+	for i := range sizes {
+		sizes[i] = image.Pt(rng.Intn(60)+1, rng.Intn(60)+1)
+	}
+	pages, rects, err := PackRects(sizes, 128, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := image.Rect(0, 0, 128, 128)
+	for i, r := range rects {
+		if r.Size() != sizes[i] {
+			t.Fatalf("rect %d is %v, want size %v", i, r, sizes[i])
+		}
+		if !r.In(page) {
+			t.Fatalf("rect %d at %v is off the page", i, r)
+		}
+		for j := 0; j < i; j++ {
+			if pages[i] == pages[j] && r.Overlaps(rects[j]) {
+				t.Fatalf("rects %d %v and %d %v overlap on page %d", i, r, j, rects[j], pages[i])
+			}
+		}
+	}
+}
+
+func TestPackRectsTooBig(t *testing.T) {
+	if _, _, err := PackRects([]image.Point{{65, 10}}, 64, 64); err == nil {
+		t.Fatal("packed a rectangle wider than the page")
+	}
+}
+
+func TestBuildAtlasSpillsToNextPage(t *testing.T) {
+	opts := AtlasOptions{PageWidth: 64, PageHeight: 64, Padding: 2, Bleed: 2}
+	atlas, err := BuildAtlas([]AtlasImage{
+		{Name: "a", Image: solidImage(40, 40, color.RGBA{255, 0, 0, 255})},
+		{Name: "b", Image: solidImage(40, 40, color.RGBA{0, 255, 0, 255})},
+		{Name: "c", Image: solidImage(8, 8, color.RGBA{0, 0, 255, 255})},
+	}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atlas.Pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(atlas.Pages))
+	}
+	entries := atlas.Manifest.Entries
+	if entries["a"].Page == entries["b"].Page {
+		t.Errorf("a and b share page %d, they can't both fit", entries["a"].Page)
+	}
+	if entries["c"].Page != 0 {
+		t.Errorf("c went to page %d, it fits beside a on page 0", entries["c"].Page)
+	}
+	for name, e := range entries {
+		if got := atlas.Pages[e.Page].RGBAAt(e.X+e.Width/2, e.Y+e.Height/2); got.A != 255 {
+			t.Errorf("%s: nothing drawn at its entry, got %v", name, got)
+		}
+	}
+}
+
+func TestBuildAtlasDeterministic(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	var images []AtlasImage
+	for i := 0; i < 40; i++ {
+		c := color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+		images = append(images, AtlasImage{
+			Name:  fmt.Sprintf("img%02d", i),
+			Image: solidImage(rng.Intn(30)+1, rng.Intn(30)+1, c),
+		})
+	}
+	opts := AtlasOptions{PageWidth: 128, PageHeight: 128, Padding: 2, Bleed: 1}
+	want, err := BuildAtlas(images, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for run := 0; run < 5; run++ {
+		shuffled := append([]AtlasImage(nil), images...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		got, err := BuildAtlas(shuffled, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Manifest, want.Manifest) {
+			t.Fatalf("run %d: manifest changed with the input order", run)
+		}
+		for p := range want.Pages {
+			if !bytes.Equal(got.Pages[p].Pix, want.Pages[p].Pix) {
+				t.Fatalf("run %d: page %d pixels changed with the input order", run, p)
+			}
+		}
+	}
+}
+
+func TestBuildAtlasDuplicateName(t *testing.T) {
+	img := solidImage(4, 4, color.RGBA{A: 255})
+	if _, err := BuildAtlas([]AtlasImage{{"x", img}, {"x", img}}, DefaultAtlasOptions()); err == nil {
+		t.Fatal("built an atlas with two images named x")
+	}
+}
+
+func TestBuildAtlasBleed(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	tl, tr := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}
+	bl, br := color.RGBA{0, 0, 255, 255}, color.RGBA{255, 255, 0, 255}
+	img.SetRGBA(0, 0, tl)
+	img.SetRGBA(1, 0, tr)
+	img.SetRGBA(0, 1, bl)
+	img.SetRGBA(1, 1, br)
+
+	atlas, err := BuildAtlas([]AtlasImage{{"quad", img}}, AtlasOptions{PageWidth: 16, PageHeight: 16, Padding: 2, Bleed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := atlas.Manifest.Entries["quad"]
+	page := atlas.Pages[e.Page]
+	x0, y0, x1, y1 := e.X, e.Y, e.X+e.Width-1, e.Y+e.Height-1
+	tests := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{x0 - 1, y0, tl},     // left of the top left
+		{x0, y0 - 1, tl},     // above it
+		{x0 - 1, y0 - 1, tl}, // the corner
+		{x1 + 1, y0, tr},
+		{x0 - 1, y1 + 1, bl},
+		{x1 + 1, y1 + 1, br},
+		{x1, y1 + 1, br},
+		{x0 - 2, y0, color.RGBA{}}, // past the bleed the padding stays empty
+		{x1 + 2, y1 + 2, color.RGBA{}},
+	}
+	for _, tt := range tests {
+		if got := page.RGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("texel %d,%d is %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestRemapUV(t *testing.T) {
+	e := AtlasEntry{U0: 0.25, V0: 0.5, U1: 0.5, V1: 1}
+	tests := []struct {
+		u, v   float32
+		flipV  bool
+		wu, wv float32
+	}{
+		{0, 0, false, 0.25, 0.5},
+		{1, 1, false, 0.5, 1},
+		{0.5, 0.5, false, 0.375, 0.75},
+		// a shader sampling at 1 - v must still land in the rect: 1 - 0.5
+		// is V0 at the top and 1 - 0 is V1 at the bottom
+		{0, 1, true, 0.25, 0.5},
+		{1, 0, true, 0.5, 0},
+		{0.5, 0.5, true, 0.375, 0.25},
+	}
+	for _, tt := range tests {
+		u, v := e.RemapUV(tt.u, tt.v, tt.flipV)
+		if u != tt.wu || v != tt.wv {
+			t.Errorf("RemapUV(%v, %v, %v) = %v, %v, want %v, %v", tt.u, tt.v, tt.flipV, u, v, tt.wu, tt.wv)
+		}
+	}
+}
+
+func TestRemapUVs(t *testing.T) {
+	e := AtlasEntry{U0: 0.25, V0: 0.5, U1: 0.5, V1: 1}
+	// x, y, z, u, v
+	vertices := []float32{
+		1, 2, 3, 0, 0,
+		4, 5, 6, 1, 1,
+	}
+	for _, flipV := range []bool{false, true} {
+		out := RemapUVs(vertices, 5, 3, e, flipV)
+		if vertices[3] != 0 || vertices[9] != 1 {
+			t.Fatal("RemapUVs changed its input")
+		}
+		for i := 0; i < len(out); i += 5 {
+			if out[i] != vertices[i] || out[i+1] != vertices[i+1] || out[i+2] != vertices[i+2] {
+				t.Errorf("flipV %v: position of vertex %d changed to %v", flipV, i/5, out[i:i+3])
+			}
+			u, v := e.RemapUV(vertices[i+3], vertices[i+4], flipV)
+			if out[i+3] != u || out[i+4] != v {
+				t.Errorf("flipV %v: vertex %d UV is %v, want %v, %v", flipV, i/5, out[i+3:i+5], u, v)
+			}
+		}
+	}
+}
+
+func TestRunAtlasBake(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i, c := range []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}} {
+		var b bytes.Buffer
+		png.Encode(&b, solidImage(8+i, 4, c))
+		fsys[fmt.Sprintf("sprites/s%d.png", i)] = &fstest.MapFile{Data: b.Bytes()}
+	}
+	fsys["sprites/s0.png.json"] = &fstest.MapFile{Data: []byte(`{}`)}
+
+	dir := t.TempDir()
+	if err := runAtlasBake(fsys, "sprites", dir); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadAtlasManifest(os.DirFS(dir), "sprites.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Entries) != 2 || len(m.Pages) != 1 {
+		t.Fatalf("got %d entries on %d pages, want 2 on 1", len(m.Entries), len(m.Pages))
+	}
+	if e := m.Entries["s1"]; e.Width != 9 || e.Height != 4 {
+		t.Errorf("s1 is %dx%d, want 9x4", e.Width, e.Height)
+	}
+	if _, err := os.Stat(filepath.Join(dir, m.Pages[0])); err != nil {
+		t.Error(err)
+	}
+}
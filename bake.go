@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// BakeFormats are the block formats BakeTexture can encode, by the name
+// given to -bakeformat
+var BakeFormats = map[string]uint32{
+	"bc1":  gl.COMPRESSED_RGB_S3TC_DXT1_EXT,
+	"bc1a": gl.COMPRESSED_RGBA_S3TC_DXT1_EXT,
+	"bc3":  gl.COMPRESSED_RGBA_S3TC_DXT5_EXT,
+}
+
+// BakeTexture compresses an image asset to KTX with the mips its options
+// ask for, so it loads without decoding or mip generation at run time. An
+// sRGB texture is written in the sRGB version of format.
+func BakeTexture(fsys fs.FS, file string, format uint32, w io.Writer) error {
+	opts, err := LoadTextureOptions(fsys, file)
+	if err != nil {
+		return err
+	}
+	img, err := DecodeTexture(fsys, file)
+	if err != nil {
+		return err
+	}
+	if info := compressedFormats[format]; opts.SRGB && info.srgb != 0 {
+		format = info.srgb
+	}
+	t, err := CompressImage(img, format, opts)
+	if err != nil {
+		return fmt.Errorf("texture %q: %v", file, err)
+	}
+	return EncodeKTX(w, t)
+}
+
+// runBake is the -bake mode, out defaults to the asset's name with .ktx in
+// the current directory
+func runBake(fsys fs.FS, file, formatName, out string) error {
+	format, ok := BakeFormats[strings.ToLower(formatName)]
+	if !ok {
+		var names []string
+		for name := range BakeFormats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown bake format %q, use one of %s", formatName, strings.Join(names, ", "))
+	}
+	if out == "" {
+		base := path.Base(file)
+		out = strings.TrimSuffix(base, path.Ext(base)) + ".ktx"
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := BakeTexture(fsys, file, format, f); err != nil {
+		f.Close()
+		os.Remove(out)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("baked %s to %s\n", file, out)
+	return nil
+}
+
+// runAtlasBake is the -atlas mode, it packs every image in dir into pages
+// and a manifest named after dir and written to out. The manifest is read
+// back so a bad bake fails here rather than at load.
+func runAtlasBake(fsys fs.FS, dir, out string) error {
+	atlas, err := PackAtlasDir(fsys, dir, DefaultAtlasOptions())
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		out = "."
+	}
+	name := path.Base(path.Clean(dir))
+	if err := atlas.Save(out, name); err != nil {
+		return err
+	}
+	manifest, err := LoadAtlasManifest(os.DirFS(out), name+".json")
+	if err != nil {
+		return err
+	}
+	// the corners of a whole texture, remapped as a mesh would be, must
+	// land on the texels each entry says it covers
+	corners := []float32{0, 0, 1, 1}
+	for entry, e := range manifest.Entries {
+		uv := RemapUVs(corners, 2, 0, e, false)
+		texels := []float32{float32(e.X), float32(e.Y), float32(e.X + e.Width), float32(e.Y + e.Height)}
+		for i, t := range texels {
+			size := manifest.PageWidth
+			if i%2 == 1 {
+				size = manifest.PageHeight
+			}
+			if d := uv[i]*float32(size) - t; d > 0.01 || d < -0.01 {
+				return fmt.Errorf("atlas entry %q: UVs don't match its texels", entry)
+			}
+		}
+	}
+	fmt.Printf("baked %d images from %s onto %d %dx%d pages in %s\n", len(manifest.Entries), dir, len(manifest.Pages), manifest.PageWidth, manifest.PageHeight, out)
+	return nil
+}
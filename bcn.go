@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Block compression works on 4x4 texel blocks. BC1 stores colour in 8 bytes,
+// BC2 and BC3 add 8 bytes of alpha and BC4 and BC5 are one and two channels
+// coded like the BC3 alpha.
+
+// EncodeBC1 compresses img into BC1 blocks. Blocks with texels under half
+// alpha use the punch-through mode, which needs an RGBA BC1 format to show
+// as transparent.
+func EncodeBC1(img *image.RGBA) []byte {
+	return encodeBlocks(img, 8, func(px *[16][4]uint8, dst []byte) {
+		encodeColorBlock(px, dst, true)
+	})
+}
+
+// EncodeBC3 compresses img into BC3 blocks, BC1 colour with 8 bit alpha
+func EncodeBC3(img *image.RGBA) []byte {
+	return encodeBlocks(img, 16, func(px *[16][4]uint8, dst []byte) {
+		var alpha [16]uint8
+		for i := range px {
+			alpha[i] = px[i][3]
+		}
+		encodeAlphaBlock(&alpha, dst[:8])
+		encodeColorBlock(px, dst[8:], false)
+	})
+}
+
+// encodeBlocks runs encode over every 4x4 block, repeating the edge texels
+// of images whose size isn't a multiple of 4
+func encodeBlocks(img *image.RGBA, blockBytes int, encode func(px *[16][4]uint8, dst []byte)) []byte {
+	size := img.Rect.Size()
+	bw, bh := (size.X+3)/4, (size.Y+3)/4
+	out := make([]byte, bw*bh*blockBytes)
+	var px [16][4]uint8
+	for by := 0; by < bh; by++ {
+		for bx := 0; bx < bw; bx++ {
+			for i := range px {
+				x, y := bx*4+i%4, by*4+i/4
+				if x >= size.X {
+					x = size.X - 1
+				}
+				if y >= size.Y {
+					y = size.Y - 1
+				}
+				copy(px[i][:], img.Pix[y*img.Stride+x*4:])
+			}
+			encode(&px, out[(by*bw+bx)*blockBytes:])
+		}
+	}
+	return out
+}
+
+func encodeColorBlock(px *[16][4]uint8, dst []byte, punchThrough bool) {
+	transparent := false
+	var colors [][3]float32
+	for i := range px {
+		if punchThrough && px[i][3] < 128 {
+			transparent = true
+			continue
+		}
+		colors = append(colors, [3]float32{float32(px[i][0]), float32(px[i][1]), float32(px[i][2])})
+	}
+
+	var c0, c1 uint16
+	if len(colors) > 0 {
+		lo, hi := fitColorEndpoints(colors)
+		c0, c1 = packRGB565(hi), packRGB565(lo)
+	}
+	// The order of the endpoints selects the mode: c0 > c1 is four colours,
+	// otherwise three and transparent black
+	if transparent == (c0 > c1) {
+		c0, c1 = c1, c0
+	}
+	palette := colorPalette(c0, c1, false)
+	entries := 4
+	if c0 <= c1 {
+		entries = 3
+	}
+
+	var indices uint32
+	for i := range px {
+		best := 3
+		if !transparent || px[i][3] >= 128 {
+			best = nearestPaletteEntry(palette[:entries], px[i])
+		}
+		indices |= uint32(best) << uint(2*i)
+	}
+	binary.LittleEndian.PutUint16(dst[0:], c0)
+	binary.LittleEndian.PutUint16(dst[2:], c1)
+	binary.LittleEndian.PutUint32(dst[4:], indices)
+}
+
+// fitColorEndpoints returns the ends of the line through the colours along
+// their principal axis, pulled in slightly to cut quantisation error
+func fitColorEndpoints(colors [][3]float32) (lo, hi [3]float32) {
+	var mean [3]float32
+	for _, c := range colors {
+		for i := range mean {
+			mean[i] += c[i]
+		}
+	}
+	for i := range mean {
+		mean[i] /= float32(len(colors))
+	}
+
+	var cov [3][3]float32
+	for _, c := range colors {
+		d := [3]float32{c[0] - mean[0], c[1] - mean[1], c[2] - mean[2]}
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				cov[i][j] += d[i] * d[j]
+			}
+		}
+	}
+
+	// Power iteration for the dominant eigenvector
+	axis := [3]float32{1, 1, 1}
+	for iter := 0; iter < 8; iter++ {
+		var next [3]float32
+		for i := 0; i < 3; i++ {
+			next[i] = cov[i][0]*axis[0] + cov[i][1]*axis[1] + cov[i][2]*axis[2]
+		}
+		l := float32(math.Sqrt(float64(next[0]*next[0] + next[1]*next[1] + next[2]*next[2])))
+		if l == 0 {
+			return mean, mean
+		}
+		axis = [3]float32{next[0] / l, next[1] / l, next[2] / l}
+	}
+
+	tmin, tmax := float32(math.MaxFloat32), float32(-math.MaxFloat32)
+	for _, c := range colors {
+		t := (c[0]-mean[0])*axis[0] + (c[1]-mean[1])*axis[1] + (c[2]-mean[2])*axis[2]
+		if t < tmin {
+			tmin = t
+		}
+		if t > tmax {
+			tmax = t
+		}
+	}
+	inset := (tmax - tmin) / 16
+	tmin, tmax = tmin+inset, tmax-inset
+	for i := 0; i < 3; i++ {
+		lo[i] = mean[i] + axis[i]*tmin
+		hi[i] = mean[i] + axis[i]*tmax
+	}
+	return lo, hi
+}
+
+func packRGB565(c [3]float32) uint16 {
+	q := func(v float32, max float32) uint16 {
+		v = v / 255 * max
+		if v < 0 {
+			v = 0
+		}
+		if v > max {
+			v = max
+		}
+		return uint16(v + 0.5)
+	}
+	return q(c[0], 31)<<11 | q(c[1], 63)<<5 | q(c[2], 31)
+}
+
+func unpackRGB565(c uint16) [4]uint8 {
+	r, g, b := uint8(c>>11&31), uint8(c>>5&63), uint8(c&31)
+	return [4]uint8{r<<3 | r>>2, g<<2 | g>>4, b<<3 | b>>2, 255}
+}
+
+// colorPalette expands a pair of endpoints, forceFour is for the colour half
+// of BC2 and BC3 which is always in four colour mode
+func colorPalette(c0, c1 uint16, forceFour bool) [4][4]uint8 {
+	var p [4][4]uint8
+	p[0], p[1] = unpackRGB565(c0), unpackRGB565(c1)
+	for i := 0; i < 3; i++ {
+		a, b := int(p[0][i]), int(p[1][i])
+		if c0 > c1 || forceFour {
+			p[2][i] = uint8((2*a + b + 1) / 3)
+			p[3][i] = uint8((a + 2*b + 1) / 3)
+		} else {
+			p[2][i] = uint8((a + b) / 2)
+		}
+	}
+	p[2][3] = 255
+	if c0 > c1 || forceFour {
+		p[3][3] = 255
+	}
+	return p
+}
+
+func nearestPaletteEntry(palette [][4]uint8, c [4]uint8) int {
+	best, bestDist := 0, math.MaxInt32
+	for i, p := range palette {
+		dist := 0
+		for j := 0; j < 3; j++ {
+			d := int(p[j]) - int(c[j])
+			dist += d * d
+		}
+		if dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+// encodeAlphaBlock codes 16 values between the block's minimum and maximum
+// in eight steps
+func encodeAlphaBlock(values *[16]uint8, dst []byte) {
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	dst[0], dst[1] = hi, lo
+	palette := alphaPalette(hi, lo)
+
+	var indices uint64
+	for i, v := range values {
+		best, bestDist := 0, 256
+		for j, p := range palette {
+			d := int(p) - int(v)
+			if d < 0 {
+				d = -d
+			}
+			if d < bestDist {
+				best, bestDist = j, d
+			}
+		}
+		indices |= uint64(best) << uint(3*i)
+	}
+	for i := 0; i < 6; i++ {
+		dst[2+i] = uint8(indices >> uint(8*i))
+	}
+}
+
+func alphaPalette(a0, a1 uint8) [8]uint8 {
+	p := [8]uint8{a0, a1}
+	a, b := int(a0), int(a1)
+	if a0 > a1 {
+		for i := 1; i < 7; i++ {
+			p[i+1] = uint8(((7-i)*a + i*b + 3) / 7)
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			p[i+1] = uint8(((5-i)*a + i*b + 2) / 5)
+		}
+		p[6], p[7] = 0, 255
+	}
+	return p
+}
+
+func decodeAlphaBlock(src []byte) [16]uint8 {
+	palette := alphaPalette(src[0], src[1])
+	var indices uint64
+	for i := 0; i < 6; i++ {
+		indices |= uint64(src[2+i]) << uint(8*i)
+	}
+	var out [16]uint8
+	for i := range out {
+		out[i] = palette[indices>>uint(3*i)&7]
+	}
+	return out
+}
+
+func decodeColorBlock(src []byte, forceFour bool) [16][4]uint8 {
+	c0 := binary.LittleEndian.Uint16(src[0:])
+	c1 := binary.LittleEndian.Uint16(src[2:])
+	indices := binary.LittleEndian.Uint32(src[4:])
+	palette := colorPalette(c0, c1, forceFour)
+	var out [16][4]uint8
+	for i := range out {
+		out[i] = palette[indices>>uint(2*i)&3]
+	}
+	return out
+}
+
+// DecodeBCn expands BC1 to BC5 blocks for previews and tests. format is
+// one of the GL compressed formats, BC4 and BC5 decode to red and red-green.
+func DecodeBCn(format uint32, width, height int, data []byte) (*image.RGBA, error) {
+	info, ok := compressedFormats[format]
+	if !ok || info.bcn == 0 {
+		return nil, fmt.Errorf("no decoder for %s", compressedFormatName(format))
+	}
+	bw, bh := (width+3)/4, (height+3)/4
+	if len(data) < bw*bh*info.blockBytes {
+		return nil, fmt.Errorf("%s data is %d bytes, %dx%d needs %d", info.name, len(data), width, height, bw*bh*info.blockBytes)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for by := 0; by < bh; by++ {
+		for bx := 0; bx < bw; bx++ {
+			block := data[(by*bw+bx)*info.blockBytes:]
+			var px [16][4]uint8
+			switch info.bcn {
+			case 1:
+				px = decodeColorBlock(block, false)
+			case 2:
+				px = decodeColorBlock(block[8:], true)
+				for i := range px {
+					a := block[i/2] >> uint(4*(i%2)) & 0xf
+					px[i][3] = a<<4 | a
+				}
+			case 3:
+				px = decodeColorBlock(block[8:], true)
+				alpha := decodeAlphaBlock(block)
+				for i := range px {
+					px[i][3] = alpha[i]
+				}
+			case 4, 5:
+				red := decodeAlphaBlock(block)
+				green := [16]uint8{}
+				if info.bcn == 5 {
+					green = decodeAlphaBlock(block[8:])
+				}
+				for i := range px {
+					px[i] = [4]uint8{red[i], green[i], 0, 255}
+				}
+			}
+			for i := range px {
+				x, y := bx*4+i%4, by*4+i/4
+				if x < width && y < height {
+					copy(img.Pix[y*img.Stride+x*4:], px[i][:])
+				}
+			}
+		}
+	}
+	return img, nil
+}
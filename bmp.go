@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+)
+
+func init() {
+	image.RegisterFormat("bmp", "BM", DecodeBMP, DecodeBMPConfig)
+}
+
+// BMP compression types
+const (
+	bmpRGB            = 0
+	bmpBitFields      = 3
+	bmpAlphaBitFields = 6
+)
+
+type bmpInfo struct {
+	width, height int
+	topDown       bool
+	bits          int
+	compression   uint32
+	masks         [4]uint32 // r, g, b, a
+	palette       []color.NRGBA
+	dataOffset    int
+}
+
+func readBMPInfo(r *bufio.Reader) (*bmpInfo, int, error) {
+	var file [14]byte
+	if _, err := io.ReadFull(r, file[:]); err != nil {
+		return nil, 0, fmt.Errorf("bmp: %v", err)
+	}
+	if string(file[:2]) != "BM" {
+		return nil, 0, errors.New("bmp: not a BMP file")
+	}
+	info := &bmpInfo{dataOffset: int(binary.LittleEndian.Uint32(file[10:]))}
+
+	var sizeBuf [4]byte
+	if _, err := io.ReadFull(r, sizeBuf[:]); err != nil {
+		return nil, 0, fmt.Errorf("bmp: %v", err)
+	}
+	headerSize := int(binary.LittleEndian.Uint32(sizeBuf[:]))
+	if headerSize < 12 || headerSize > 1024 {
+		return nil, 0, fmt.Errorf("bmp: bad header size %d", headerSize)
+	}
+	header := make([]byte, headerSize-4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, fmt.Errorf("bmp: %v", err)
+	}
+	read := 14 + headerSize
+
+	paletteEntry := 4
+	if headerSize == 12 {
+		// OS/2 core header with 16 bit sizes and 3 byte palette entries
+		info.width = int(binary.LittleEndian.Uint16(header[0:]))
+		info.height = int(int16(binary.LittleEndian.Uint16(header[2:])))
+		info.bits = int(binary.LittleEndian.Uint16(header[6:]))
+		paletteEntry = 3
+	} else {
+		if headerSize < 40 {
+			return nil, 0, fmt.Errorf("bmp: bad header size %d", headerSize)
+		}
+		info.width = int(int32(binary.LittleEndian.Uint32(header[0:])))
+		info.height = int(int32(binary.LittleEndian.Uint32(header[4:])))
+		info.bits = int(binary.LittleEndian.Uint16(header[10:]))
+		info.compression = binary.LittleEndian.Uint32(header[12:])
+	}
+	if info.height < 0 {
+		info.height, info.topDown = -info.height, true
+	}
+	if info.width <= 0 || info.height == 0 {
+		return nil, 0, errors.New("bmp: empty image")
+	}
+	if err := checkImageSize("bmp", info.width, info.height); err != nil {
+		return nil, 0, err
+	}
+
+	switch info.compression {
+	case bmpRGB:
+		switch info.bits {
+		case 16:
+			info.masks = [4]uint32{0x7c00, 0x03e0, 0x001f, 0}
+		case 24, 32:
+			info.masks = [4]uint32{0xff0000, 0xff00, 0xff, 0}
+		}
+	case bmpBitFields, bmpAlphaBitFields:
+		if info.bits != 16 && info.bits != 32 {
+			return nil, 0, fmt.Errorf("bmp: bit fields with %d bits", info.bits)
+		}
+		count := 3
+		if info.compression == bmpAlphaBitFields || headerSize >= 56 {
+			count = 4
+		}
+		var masks []byte
+		if headerSize >= 52 {
+			masks = header[36:]
+		} else {
+			// The masks follow a plain info header
+			masks = make([]byte, count*4)
+			if _, err := io.ReadFull(r, masks); err != nil {
+				return nil, 0, fmt.Errorf("bmp: %v", err)
+			}
+			read += count * 4
+		}
+		for i := 0; i < count && len(masks) >= (i+1)*4; i++ {
+			info.masks[i] = binary.LittleEndian.Uint32(masks[i*4:])
+		}
+	default:
+		return nil, 0, fmt.Errorf("bmp: unsupported compression %d", info.compression)
+	}
+
+	if info.bits <= 8 {
+		if info.bits != 1 && info.bits != 4 && info.bits != 8 {
+			return nil, 0, fmt.Errorf("bmp: unsupported bit depth %d", info.bits)
+		}
+		colors := 1 << uint(info.bits)
+		if headerSize >= 40 {
+			if used := int(binary.LittleEndian.Uint32(header[28:])); used > 0 && used < colors {
+				colors = used
+			}
+		}
+		raw := make([]byte, colors*paletteEntry)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, 0, fmt.Errorf("bmp: palette: %v", err)
+		}
+		read += len(raw)
+		for i := 0; i < colors; i++ {
+			p := raw[i*paletteEntry:]
+			info.palette = append(info.palette, color.NRGBA{p[2], p[1], p[0], 255})
+		}
+	} else if info.bits != 16 && info.bits != 24 && info.bits != 32 {
+		return nil, 0, fmt.Errorf("bmp: unsupported bit depth %d", info.bits)
+	}
+	return info, read, nil
+}
+
+func DecodeBMPConfig(r io.Reader) (image.Config, error) {
+	info, _, err := readBMPInfo(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: info.width, Height: info.height}, nil
+}
+
+// DecodeBMP reads uncompressed and bit field BMP images of 1 to 32 bits
+func DecodeBMP(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	info, read, err := readBMPInfo(br)
+	if err != nil {
+		return nil, err
+	}
+	if skip := info.dataOffset - read; skip > 0 {
+		if _, err := br.Discard(skip); err != nil {
+			return nil, fmt.Errorf("bmp: %v", err)
+		}
+	}
+
+	stride := (info.width*info.bits + 31) / 32 * 4
+	row := make([]byte, stride)
+	img := image.NewNRGBA(image.Rect(0, 0, info.width, info.height))
+	for y := 0; y < info.height; y++ {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return nil, fmt.Errorf("bmp: %v", err)
+		}
+		dy := info.height - 1 - y
+		if info.topDown {
+			dy = y
+		}
+		for x := 0; x < info.width; x++ {
+			c, err := info.pixel(row, x)
+			if err != nil {
+				return nil, err
+			}
+			img.SetNRGBA(x, dy, c)
+		}
+	}
+	return img, nil
+}
+
+func (info *bmpInfo) pixel(row []byte, x int) (color.NRGBA, error) {
+	var v uint32
+	switch info.bits {
+	case 1, 4, 8:
+		perByte := 8 / info.bits
+		shift := uint(8 - info.bits - (x%perByte)*info.bits)
+		index := int(row[x/perByte]>>shift) & (1<<uint(info.bits) - 1)
+		if index >= len(info.palette) {
+			return color.NRGBA{}, fmt.Errorf("bmp: color index %d out of range", index)
+		}
+		return info.palette[index], nil
+	case 16:
+		v = uint32(binary.LittleEndian.Uint16(row[x*2:]))
+	case 24:
+		v = uint32(row[x*3]) | uint32(row[x*3+1])<<8 | uint32(row[x*3+2])<<16
+	case 32:
+		v = binary.LittleEndian.Uint32(row[x*4:])
+	}
+	c := color.NRGBA{
+		R: bmpChannel(v, info.masks[0]),
+		G: bmpChannel(v, info.masks[1]),
+		B: bmpChannel(v, info.masks[2]),
+		A: 255,
+	}
+	if info.masks[3] != 0 {
+		c.A = bmpChannel(v, info.masks[3])
+	}
+	return c, nil
+}
+
+// bmpChannel extracts a masked channel and scales it to 8 bits
+func bmpChannel(v, mask uint32) uint8 {
+	if mask == 0 {
+		return 0
+	}
+	shift := uint(bits.TrailingZeros32(mask))
+	width := uint(bits.OnesCount32(mask))
+	c := uint64((v & mask) >> shift)
+	max := uint64(1)<<width - 1
+	return uint8((c*255 + max/2) / max)
+}
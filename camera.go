@@ -19,6 +19,21 @@ type Camera struct {
 	lastY             float64
 	pitch             float64
 	yaw               float64
+
+	// Velocity model. speed is the maximum speed, acceleration and damping are
+	// the rates (per second) at which the velocity approaches the target
+	// velocity while moving and decays back to zero when no key is held.
+	velocity         mgl32.Vec3
+	moveInput        mgl32.Vec3
+	acceleration     float32
+	damping          float32
+	sprintMultiplier float32
+	sprinting        bool
+
+	// Mouse smoothing time constant in seconds, 0 disables smoothing
+	mouseSmoothing float64
+	targetPitch    float64
+	targetYaw      float64
 }
 
 func NewCamera(posVec, frontVec, upVec mgl32.Vec3) *Camera {
@@ -30,6 +45,9 @@ func NewCamera(posVec, frontVec, upVec mgl32.Vec3) *Camera {
 		speed:             0.01,
 		sensitivity:       0.25,
 		isFirstMouseEvent: true,
+		acceleration:      10.0,
+		damping:           8.0,
+		sprintMultiplier:  2.0,
 	}
 }
 
@@ -42,6 +60,9 @@ func NewDefaultCamera() *Camera {
 		speed:             0.01,
 		sensitivity:       0.25,
 		isFirstMouseEvent: true,
+		acceleration:      10.0,
+		damping:           8.0,
+		sprintMultiplier:  2.0,
 	}
 }
 
@@ -54,30 +75,144 @@ func (c *Camera) SetDelta(d float32) {
 func (c *Camera) SetSensitivity(s float64) {
 	c.sensitivity = s
 }
+func (c *Camera) SetAcceleration(a float32) {
+	c.acceleration = a
+}
+func (c *Camera) SetDamping(d float32) {
+	c.damping = d
+}
+func (c *Camera) SetSprintMultiplier(m float32) {
+	c.sprintMultiplier = m
+}
+func (c *Camera) SetSprint(on bool) {
+	c.sprinting = on
+}
+func (c *Camera) SetMouseSmoothing(s float64) {
+	c.mouseSmoothing = s
+}
+func (c *Camera) Velocity() mgl32.Vec3 {
+	return c.velocity
+}
+
+// The move methods only record the requested direction for this frame,
+// the camera is moved when Update is called.
 func (c *Camera) MoveForward() {
-	c.Position = c.Position.Add(c.Front.Mul(c.speed * c.delta))
+	c.moveInput = c.moveInput.Add(mgl32.Vec3{0, 0, 1})
 }
 
 func (c *Camera) MoveBackward() {
-	c.Position = c.Position.Sub(c.Front.Mul(c.speed * c.delta))
+	c.moveInput = c.moveInput.Sub(mgl32.Vec3{0, 0, 1})
 }
 
 func (c *Camera) MoveLeft() {
-	c.Position = c.Position.Sub(c.Front.Cross(c.Up).Normalize().Mul(c.speed * c.delta))
+	c.moveInput = c.moveInput.Sub(mgl32.Vec3{1, 0, 0})
 }
 
 func (c *Camera) MoveRight() {
-	c.Position = c.Position.Add(c.Front.Cross(c.Up).Normalize().Mul(c.speed * c.delta))
+	c.moveInput = c.moveInput.Add(mgl32.Vec3{1, 0, 0})
+}
+
+// Update advances the camera by the current delta. Velocity follows an
+// exponential approach to the target velocity which is integrated exactly
+// over the step, so the path only depends on the input and elapsed time and
+// not on how the time is split into frames.
+func (c *Camera) Update() {
+	dt := float64(c.delta)
+	if dt <= 0 {
+		c.moveInput = mgl32.Vec3{}
+		return
+	}
+
+	c.updateLook(dt)
+
+	right := c.Front.Cross(c.Up).Normalize()
+	dir := c.Front.Mul(c.moveInput.Z()).Add(right.Mul(c.moveInput.X()))
+	c.moveInput = mgl32.Vec3{}
+
+	target := mgl32.Vec3{}
+	rate := float64(c.damping)
+	if dir.Len() > 0 {
+		maxSpeed := c.speed
+		if c.sprinting {
+			maxSpeed = maxSpeed * c.sprintMultiplier
+		}
+		target = dir.Normalize().Mul(maxSpeed)
+		rate = float64(c.acceleration)
+	}
+
+	// v(t) = target + (v0 - target) * e^(-rate*t)
+	// p(t) = p0 + target*t + (v0 - target) * (1 - e^(-rate*t)) / rate
+	diff := c.velocity.Sub(target)
+	if rate <= 0 {
+		c.Position = c.Position.Add(c.velocity.Mul(float32(dt)))
+		return
+	}
+	decay := math.Exp(-rate * dt)
+	c.Position = c.Position.Add(target.Mul(float32(dt))).Add(diff.Mul(float32((1 - decay) / rate)))
+	c.velocity = target.Add(diff.Mul(float32(decay)))
 }
 
 func (c *Camera) CurrentView() mgl32.Mat4 {
 	return mgl32.LookAtV(c.Position, c.Position.Add(c.Front), c.Up)
 }
 
+// RotationView is the view matrix without the translation, for things so
+// far away that moving never brings them closer, like the sky
+func (c *Camera) RotationView() mgl32.Mat4 {
+	return c.CurrentView().Mat3().Mat4()
+}
+
+func (c *Camera) Projection(aspect float32) mgl32.Mat4 {
+	return mgl32.Perspective(mgl32.DegToRad(float32(c.FOV)), aspect, 0.1, 100.0)
+}
+
+// Orientation returns the rotation that takes the default view direction
+// (-Z forward, +Y up) to the camera's current Front and Up.
+func (c *Camera) Orientation() mgl32.Quat {
+	front := c.Front.Normalize()
+	right := front.Cross(c.Up).Normalize()
+	up := right.Cross(front)
+	basis := mgl32.Mat4FromCols(right.Vec4(0), up.Vec4(0), front.Mul(-1).Vec4(0), mgl32.Vec4{0, 0, 0, 1})
+	return mgl32.Mat4ToQuat(basis).Normalize()
+}
+
+// SetPose places the camera, used when the camera is driven by a path or a
+// saved view rather than by input. Yaw and pitch are derived from the new
+// front so mouse look continues from the same direction.
+func (c *Camera) SetPose(pos mgl32.Vec3, rot mgl32.Quat, fov float64) {
+	c.Position = pos
+	c.Front = rot.Rotate(mgl32.Vec3{0, 0, -1}).Normalize()
+	c.Up = rot.Rotate(mgl32.Vec3{0, 1, 0}).Normalize()
+	c.FOV = fov
+	c.velocity = mgl32.Vec3{}
+
+	c.pitch = float64(mgl32.RadToDeg(float32(math.Asin(float64(mgl32.Clamp(c.Front.Y(), -1, 1))))))
+	c.yaw = float64(mgl32.RadToDeg(float32(math.Atan2(float64(c.Front.Z()), float64(c.Front.X())))))
+	c.targetPitch = c.pitch
+	c.targetYaw = c.yaw
+}
+
+// LevelUp puts Up back to world up after a pose that rolled the camera,
+// keeping the direction it looks in as far as mouse look allows
+func (c *Camera) LevelUp() {
+	c.Up = mgl32.Vec3{0, 1, 0}
+	c.pitch = math.Max(-89, math.Min(89, c.pitch))
+	c.targetPitch = c.pitch
+	c.updateFront()
+}
+
+// ResetMouse treats the next cursor event as the first, so the view doesn't
+// jump when the cursor is captured again after moving freely
+func (c *Camera) ResetMouse() {
+	c.isFirstMouseEvent = true
+}
+
 func (c *Camera) HandleCursorEvent(xpos, ypos float64) {
 	if c.isFirstMouseEvent {
 		c.lastX = xpos
 		c.lastY = ypos
+		c.targetYaw = c.yaw
+		c.targetPitch = c.pitch
 		c.isFirstMouseEvent = false
 	}
 
@@ -89,17 +224,36 @@ func (c *Camera) HandleCursorEvent(xpos, ypos float64) {
 	xoffset = xoffset * c.sensitivity
 	yoffset = yoffset * c.sensitivity
 
-	c.yaw = c.yaw + xoffset
-	c.pitch = c.pitch + yoffset
+	c.targetYaw = c.targetYaw + xoffset
+	c.targetPitch = c.targetPitch + yoffset
+
+	if c.targetPitch > 89.0 {
+		c.targetPitch = 89.0
+	}
 
-	if c.pitch > 89.0 {
-		c.pitch = 89.0
+	if c.targetPitch < -89.0 {
+		c.targetPitch = -89.0
 	}
 
-	if c.pitch < -89.0 {
-		c.pitch = -89.0
+	if c.mouseSmoothing <= 0 {
+		c.yaw = c.targetYaw
+		c.pitch = c.targetPitch
+		c.updateFront()
 	}
+}
+
+// updateLook moves yaw and pitch towards the mouse target when smoothing is enabled
+func (c *Camera) updateLook(dt float64) {
+	if c.mouseSmoothing <= 0 || c.isFirstMouseEvent {
+		return
+	}
+	t := 1 - math.Exp(-dt/c.mouseSmoothing)
+	c.yaw = c.yaw + (c.targetYaw-c.yaw)*t
+	c.pitch = c.pitch + (c.targetPitch-c.pitch)*t
+	c.updateFront()
+}
 
+func (c *Camera) updateFront() {
 	fX := float32(math.Cos(float64(mgl32.DegToRad(float32(c.yaw)))) * math.Cos(float64(mgl32.DegToRad(float32(c.pitch)))))
 	fY := float32(math.Sin(float64(mgl32.DegToRad(float32(c.pitch)))))
 	fZ := float32(math.Sin(float64(mgl32.DegToRad(float32(c.yaw)))) * math.Cos(float64(mgl32.DegToRad(float32(c.pitch)))))
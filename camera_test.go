@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// simulate runs the camera for one second at the given frame rate, holding
+// forward and right for the first half and nothing for the second
+func simulate(fps int, sprint bool) *Camera {
+	c := NewDefaultCamera()
+	c.SetSpeed(5)
+	c.SetSprint(sprint)
+	for frame := 0; frame < fps; frame++ {
+		c.SetDelta(1 / float32(fps))
+		if frame < fps/2 {
+			c.MoveForward()
+			c.MoveRight()
+		}
+		c.Update()
+	}
+	return c
+}
+
+func TestCameraFrameRateIndependence(t *testing.T) {
+	for _, sprint := range []bool{false, true} {
+		slow := simulate(30, sprint)
+		fast := simulate(300, sprint)
+		if d := slow.Position.Sub(fast.Position).Len(); d > 1e-3 {
+			t.Errorf("sprint %v: position at 30 FPS %v, at 300 FPS %v", sprint, slow.Position, fast.Position)
+		}
+		if d := slow.Velocity().Sub(fast.Velocity()).Len(); d > 1e-3 {
+			t.Errorf("sprint %v: velocity at 30 FPS %v, at 300 FPS %v", sprint, slow.Velocity(), fast.Velocity())
+		}
+		if slow.Position.Sub(mgl32.Vec3{0, 0, 3}).Len() < 0.5 {
+			t.Errorf("sprint %v: camera barely moved, ended at %v", sprint, slow.Position)
+		}
+	}
+}
+
+func TestCameraMaxSpeed(t *testing.T) {
+	c := NewDefaultCamera()
+	c.SetSpeed(5)
+	for i := 0; i < 600; i++ {
+		c.SetDelta(1.0 / 60)
+		c.MoveForward()
+		c.Update()
+	}
+	if v := c.Velocity().Len(); v > 5.0001 || v < 4.99 {
+		t.Errorf("speed after 10s held is %v, want 5", v)
+	}
+
+	c.SetSprint(true)
+	for i := 0; i < 600; i++ {
+		c.SetDelta(1.0 / 60)
+		c.MoveForward()
+		c.Update()
+	}
+	if v := c.Velocity().Len(); v > 10.0001 || v < 9.99 {
+		t.Errorf("sprint speed is %v, want 10", v)
+	}
+}
+
+func TestCameraDamping(t *testing.T) {
+	c := NewDefaultCamera()
+	c.SetSpeed(5)
+	c.SetDelta(1)
+	c.MoveForward()
+	c.Update()
+	for i := 0; i < 10; i++ {
+		c.Update()
+	}
+	if v := c.Velocity().Len(); v > 1e-3 {
+		t.Errorf("velocity %v after 10s without input, want about 0", v)
+	}
+}
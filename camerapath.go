@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Interpolation modes for the position of a camera path
+const (
+	InterpLinear     = "linear"
+	InterpCatmullRom = "catmullrom"
+	InterpBezier     = "bezier"
+)
+
+// Easing functions applied to the normalised playback time of a path
+const (
+	EaseLinear    = "linear"
+	EaseIn        = "easeIn"
+	EaseOut       = "easeOut"
+	EaseInOut     = "easeInOut"
+	arcLengthStep = 32 // samples per segment for the arc length table
+)
+
+type Keyframe struct {
+	Time     float32    `json:"time"`
+	Position mgl32.Vec3 `json:"position"`
+	Rotation mgl32.Quat `json:"rotation"`
+	FOV      float64    `json:"fov"`
+
+	// Optional Bézier handles relative to Position. When missing the
+	// Catmull-Rom tangent is used so the curve stays smooth.
+	InHandle  *mgl32.Vec3 `json:"inHandle,omitempty"`
+	OutHandle *mgl32.Vec3 `json:"outHandle,omitempty"`
+}
+
+type CameraPath struct {
+	Keyframes     []Keyframe `json:"keyframes"`
+	Interpolation string     `json:"interpolation"`
+	Easing        string     `json:"easing"`
+	ConstantSpeed bool       `json:"constantSpeed"`
+
+	// Loop restarts playback at the end. For a smooth loop repeat the first
+	// keyframe's position as the last, the tangents at the ends then wrap
+	// past the repeat. Without it the camera jumps back to the start.
+	Loop bool `json:"loop"`
+
+	// cumulative arc length at each sample, built lazily
+	arcLengths []float32
+}
+
+// NewKeyframeFromCamera captures the current camera view as a keyframe
+func NewKeyframeFromCamera(c *Camera, time float32) Keyframe {
+	return Keyframe{
+		Time:     time,
+		Position: c.Position,
+		Rotation: c.Orientation(),
+		FOV:      c.FOV,
+	}
+}
+
+func LoadCameraPath(file string) (*CameraPath, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	path := &CameraPath{}
+	if err := json.Unmarshal(b, path); err != nil {
+		return nil, fmt.Errorf("camera path %q: %v", file, err)
+	}
+	if len(path.Keyframes) == 0 {
+		return nil, fmt.Errorf("camera path %q has no keyframes", file)
+	}
+	path.sortKeyframes()
+	return path, nil
+}
+
+func (p *CameraPath) Save(file string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// AddKeyframe inserts a keyframe keeping the list ordered by time
+func (p *CameraPath) AddKeyframe(k Keyframe) {
+	p.Keyframes = append(p.Keyframes, k)
+	p.sortKeyframes()
+}
+
+func (p *CameraPath) sortKeyframes() {
+	sort.SliceStable(p.Keyframes, func(i, j int) bool {
+		return p.Keyframes[i].Time < p.Keyframes[j].Time
+	})
+	p.arcLengths = nil
+}
+
+func (p *CameraPath) Duration() float32 {
+	if len(p.Keyframes) == 0 {
+		return 0
+	}
+	return p.Keyframes[len(p.Keyframes)-1].Time - p.Keyframes[0].Time
+}
+
+// Sample returns the camera pose at time t, measured from the first keyframe
+func (p *CameraPath) Sample(t float32) (mgl32.Vec3, mgl32.Quat, float64) {
+	n := len(p.Keyframes)
+	if n == 0 {
+		return mgl32.Vec3{}, mgl32.QuatIdent(), 45.0
+	}
+	if n == 1 {
+		k := p.Keyframes[0]
+		return k.Position, k.Rotation, k.FOV
+	}
+
+	duration := p.Duration()
+	if duration <= 0 {
+		k := p.Keyframes[0]
+		return k.Position, k.Rotation, k.FOV
+	}
+	s := mgl32.Clamp(t/duration, 0, 1)
+	s = ease(p.Easing, s)
+
+	var seg int
+	var u float32
+	if p.ConstantSpeed {
+		seg, u = p.segmentAtDistance(s)
+	} else {
+		seg, u = p.segmentAtTime(p.Keyframes[0].Time + s*duration)
+	}
+
+	k0, k1 := p.Keyframes[seg], p.Keyframes[seg+1]
+	pos := p.position(seg, u)
+	rot := slerp(k0.Rotation, k1.Rotation, u)
+	fov := k0.FOV + (k1.FOV-k0.FOV)*float64(u)
+	return pos, rot, fov
+}
+
+// segmentAtTime finds the keyframe segment containing time and the local parameter in it
+func (p *CameraPath) segmentAtTime(time float32) (int, float32) {
+	last := len(p.Keyframes) - 2
+	for i := 0; i <= last; i++ {
+		k0, k1 := p.Keyframes[i], p.Keyframes[i+1]
+		if time <= k1.Time || i == last {
+			span := k1.Time - k0.Time
+			if span <= 0 {
+				return i, 1
+			}
+			return i, mgl32.Clamp((time-k0.Time)/span, 0, 1)
+		}
+	}
+	return last, 1
+}
+
+// segmentAtDistance maps a fraction of the total path length to a segment and
+// the local parameter in it, so the camera moves at a constant speed.
+func (p *CameraPath) segmentAtDistance(s float32) (int, float32) {
+	table := p.arcLengthTable()
+	total := table[len(table)-1]
+	if total <= 0 {
+		return 0, 0
+	}
+	target := s * total
+
+	i := sort.Search(len(table), func(i int) bool { return table[i] >= target })
+	if i == 0 {
+		return 0, 0
+	}
+	if i >= len(table) {
+		i = len(table) - 1
+	}
+
+	// interpolate between the two samples either side of the target
+	frac := float32(0)
+	if span := table[i] - table[i-1]; span > 0 {
+		frac = (target - table[i-1]) / span
+	}
+	global := (float32(i-1) + frac) / arcLengthStep
+	seg := int(global)
+	if seg > len(p.Keyframes)-2 {
+		seg = len(p.Keyframes) - 2
+	}
+	return seg, mgl32.Clamp(global-float32(seg), 0, 1)
+}
+
+func (p *CameraPath) arcLengthTable() []float32 {
+	if p.arcLengths != nil {
+		return p.arcLengths
+	}
+	segments := len(p.Keyframes) - 1
+	table := make([]float32, 0, segments*arcLengthStep+1)
+	table = append(table, 0)
+	prev := p.position(0, 0)
+	total := float32(0)
+	for seg := 0; seg < segments; seg++ {
+		for i := 1; i <= arcLengthStep; i++ {
+			pt := p.position(seg, float32(i)/arcLengthStep)
+			total += pt.Sub(prev).Len()
+			table = append(table, total)
+			prev = pt
+		}
+	}
+	p.arcLengths = table
+	return table
+}
+
+// closed reports whether the last keyframe repeats the first, as it
+// should for a path that loops without a jump
+func (p *CameraPath) closed() bool {
+	n := len(p.Keyframes)
+	return n > 2 && p.Keyframes[0].Position.ApproxEqual(p.Keyframes[n-1].Position)
+}
+
+// position evaluates the curve in segment seg at local parameter u. On a
+// looping path the neighbours of the end segments wrap around, skipping
+// the repeated keyframe of a closed path.
+func (p *CameraPath) position(seg int, u float32) mgl32.Vec3 {
+	n := len(p.Keyframes)
+	k1, k2 := p.Keyframes[seg], p.Keyframes[seg+1]
+	p1, p2 := k1.Position, k2.Position
+	p0, p3 := p1, p2
+	wrap := 0
+	if p.closed() {
+		wrap = 1
+	}
+	if seg > 0 {
+		p0 = p.Keyframes[seg-1].Position
+	} else if p.Loop && n > 2 {
+		p0 = p.Keyframes[n-1-wrap].Position
+	}
+	if seg+2 < n {
+		p3 = p.Keyframes[seg+2].Position
+	} else if p.Loop && n > 2 {
+		p3 = p.Keyframes[wrap].Position
+	}
+
+	switch p.Interpolation {
+	case InterpLinear:
+		return p1.Add(p2.Sub(p1).Mul(u))
+	case InterpBezier:
+		c1 := p1.Add(p2.Sub(p0).Mul(1.0 / 6.0))
+		if k1.OutHandle != nil {
+			c1 = p1.Add(*k1.OutHandle)
+		}
+		c2 := p2.Sub(p3.Sub(p1).Mul(1.0 / 6.0))
+		if k2.InHandle != nil {
+			c2 = p2.Add(*k2.InHandle)
+		}
+		return bezier(p1, c1, c2, p2, u)
+	default:
+		return catmullRom(p0, p1, p2, p3, u)
+	}
+}
+
+func catmullRom(p0, p1, p2, p3 mgl32.Vec3, t float32) mgl32.Vec3 {
+	t2 := t * t
+	t3 := t2 * t
+	a := p1.Mul(2)
+	b := p2.Sub(p0).Mul(t)
+	c := p0.Mul(2).Sub(p1.Mul(5)).Add(p2.Mul(4)).Sub(p3).Mul(t2)
+	d := p1.Mul(3).Sub(p0).Sub(p2.Mul(3)).Add(p3).Mul(t3)
+	return a.Add(b).Add(c).Add(d).Mul(0.5)
+}
+
+func bezier(p0, p1, p2, p3 mgl32.Vec3, t float32) mgl32.Vec3 {
+	it := 1 - t
+	return p0.Mul(it * it * it).
+		Add(p1.Mul(3 * it * it * t)).
+		Add(p2.Mul(3 * it * t * t)).
+		Add(p3.Mul(t * t * t))
+}
+
+// slerp interpolates along the shortest arc between two rotations
+func slerp(q1, q2 mgl32.Quat, t float32) mgl32.Quat {
+	if q1.Dot(q2) < 0 {
+		q2 = q2.Scale(-1)
+	}
+	return mgl32.QuatSlerp(q1, q2, t)
+}
+
+func ease(mode string, t float32) float32 {
+	switch mode {
+	case EaseIn:
+		return t * t * t
+	case EaseOut:
+		it := 1 - t
+		return 1 - it*it*it
+	case EaseInOut:
+		if t < 0.5 {
+			return 4 * t * t * t
+		}
+		return 1 - float32(math.Pow(float64(-2*t+2), 3))/2
+	default:
+		return t
+	}
+}
+
+// CameraPathPlayer plays a path back against the game clock
+type CameraPathPlayer struct {
+	Path  *CameraPath
+	Speed float32
+
+	playing   bool
+	time      float32
+	lastClock float64
+}
+
+func NewCameraPathPlayer(path *CameraPath) *CameraPathPlayer {
+	return &CameraPathPlayer{Path: path, Speed: 1.0}
+}
+
+func (pp *CameraPathPlayer) Playing() bool {
+	return pp.playing
+}
+
+func (pp *CameraPathPlayer) Time() float32 {
+	return pp.time
+}
+
+// Play starts or resumes playback, clock is the current game time
+func (pp *CameraPathPlayer) Play(clock float64) {
+	if pp.time >= pp.Path.Duration() && !pp.Path.Loop {
+		pp.time = 0
+	}
+	pp.playing = true
+	pp.lastClock = clock
+}
+
+// Pause stops playback and hands the camera back to the player, levelled
+// so the free camera isn't left rolled
+func (pp *CameraPathPlayer) Pause(c *Camera) {
+	pp.playing = false
+	c.LevelUp()
+}
+
+// Seek scrubs to a time on the path and moves the camera there. While
+// paused the camera is levelled, since it is free again.
+func (pp *CameraPathPlayer) Seek(t float32, c *Camera) {
+	pp.setTime(t)
+	pp.apply(c)
+}
+
+func (pp *CameraPathPlayer) setTime(t float32) {
+	duration := pp.Path.Duration()
+	if pp.Path.Loop && duration > 0 {
+		t = float32(math.Mod(float64(t), float64(duration)))
+		if t < 0 {
+			t += duration
+		}
+	}
+	pp.time = mgl32.Clamp(t, 0, duration)
+}
+
+func (pp *CameraPathPlayer) apply(c *Camera) {
+	pos, rot, fov := pp.Path.Sample(pp.time)
+	c.SetPose(pos, rot, fov)
+	if !pp.playing {
+		c.LevelUp()
+	}
+}
+
+// Update advances playback to clock and applies the pose to the camera
+func (pp *CameraPathPlayer) Update(clock float64, c *Camera) {
+	if pp.playing {
+		pp.setTime(pp.time + float32(clock-pp.lastClock)*pp.Speed)
+		pp.lastClock = clock
+		if !pp.Path.Loop && pp.time >= pp.Path.Duration() {
+			pp.playing = false
+		}
+	}
+	pp.apply(c)
+}
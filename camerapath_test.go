@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func testPath(loop bool) *CameraPath {
+	rolled := mgl32.QuatRotate(mgl32.DegToRad(30), mgl32.Vec3{0, 0, 1})
+	return &CameraPath{
+		Interpolation: InterpCatmullRom,
+		Loop:          loop,
+		Keyframes: []Keyframe{
+			{Time: 0, Position: mgl32.Vec3{0, 0, 0}, Rotation: mgl32.QuatIdent(), FOV: 45},
+			{Time: 1, Position: mgl32.Vec3{1, 0, 0}, Rotation: rolled, FOV: 45},
+			{Time: 2, Position: mgl32.Vec3{1, 1, 0}, Rotation: rolled, FOV: 45},
+			{Time: 3, Position: mgl32.Vec3{0, 0, 0}, Rotation: mgl32.QuatIdent(), FOV: 45},
+		},
+	}
+}
+
+func TestCameraPathSeekWhilePaused(t *testing.T) {
+	pp := NewCameraPathPlayer(testPath(false))
+	c := NewDefaultCamera()
+	pp.Seek(1, c)
+	if !c.Position.ApproxEqualThreshold(mgl32.Vec3{1, 0, 0}, 1e-4) {
+		t.Errorf("paused seek to 1s left the camera at %v, want {1 0 0}", c.Position)
+	}
+	if c.Up != (mgl32.Vec3{0, 1, 0}) {
+		t.Errorf("paused camera up is %v, want world up", c.Up)
+	}
+}
+
+func TestCameraPathStopRestoresUp(t *testing.T) {
+	pp := NewCameraPathPlayer(testPath(false))
+	c := NewDefaultCamera()
+	pp.Play(0)
+	pp.Update(1, c)
+	if c.Up.ApproxEqualThreshold(mgl32.Vec3{0, 1, 0}, 1e-3) {
+		t.Fatalf("camera up %v is not rolled while playing", c.Up)
+	}
+	pp.Pause(c)
+	if c.Up != (mgl32.Vec3{0, 1, 0}) {
+		t.Errorf("up after pause is %v, want world up", c.Up)
+	}
+}
+
+// A closed looping path has the same tangent either side of the seam
+func TestCameraPathLoopTangent(t *testing.T) {
+	p := testPath(true)
+	n := len(p.Keyframes)
+	before := p.position(n-2, 1).Sub(p.position(n-2, 0.999))
+	after := p.position(0, 0.001).Sub(p.position(0, 0))
+	if before.Normalize().Dot(after.Normalize()) < 0.999 {
+		t.Errorf("tangent %v before the seam, %v after", before.Normalize(), after.Normalize())
+	}
+}
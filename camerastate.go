@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// CameraState holds everything needed to put a camera back exactly where it was
+type CameraState struct {
+	Position         mgl32.Vec3 `json:"position"`
+	Front            mgl32.Vec3 `json:"front"`
+	Up               mgl32.Vec3 `json:"up"`
+	FOV              float64    `json:"fov"`
+	Yaw              float64    `json:"yaw"`
+	Pitch            float64    `json:"pitch"`
+	Speed            float32    `json:"speed"`
+	Sensitivity      float64    `json:"sensitivity"`
+	Acceleration     float32    `json:"acceleration"`
+	Damping          float32    `json:"damping"`
+	SprintMultiplier float32    `json:"sprintMultiplier"`
+	MouseSmoothing   float64    `json:"mouseSmoothing"`
+}
+
+func (c *Camera) State() CameraState {
+	return CameraState{
+		Position:         c.Position,
+		Front:            c.Front,
+		Up:               c.Up,
+		FOV:              c.FOV,
+		Yaw:              c.yaw,
+		Pitch:            c.pitch,
+		Speed:            c.speed,
+		Sensitivity:      c.sensitivity,
+		Acceleration:     c.acceleration,
+		Damping:          c.damping,
+		SprintMultiplier: c.sprintMultiplier,
+		MouseSmoothing:   c.mouseSmoothing,
+	}
+}
+
+// SetState restores a saved state. Motion is stopped and the next mouse event
+// is treated as the first so the view does not jump.
+func (c *Camera) SetState(s CameraState) {
+	c.Position = s.Position
+	c.Front = s.Front
+	c.Up = s.Up
+	c.FOV = s.FOV
+	c.yaw = s.Yaw
+	c.pitch = s.Pitch
+	c.targetYaw = s.Yaw
+	c.targetPitch = s.Pitch
+	c.speed = s.Speed
+	c.sensitivity = s.Sensitivity
+	c.acceleration = s.Acceleration
+	c.damping = s.Damping
+	c.sprintMultiplier = s.SprintMultiplier
+	c.mouseSmoothing = s.MouseSmoothing
+	c.velocity = mgl32.Vec3{}
+	c.moveInput = mgl32.Vec3{}
+	c.isFirstMouseEvent = true
+}
+
+func (c *Camera) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.State())
+}
+
+func (c *Camera) UnmarshalJSON(b []byte) error {
+	s := c.State()
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	c.SetState(s)
+	return nil
+}
+
+// CameraViews stores named bookmarks and the last view of the previous run
+type CameraViews struct {
+	Last      *CameraState           `json:"last,omitempty"`
+	Bookmarks map[string]CameraState `json:"bookmarks"`
+
+	file string
+}
+
+// DefaultCameraViewsFile is where views are kept when no file is given
+func DefaultCameraViewsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "camera_views.json"
+	}
+	return filepath.Join(dir, "stumct-opengl", "camera_views.json")
+}
+
+// NewCameraViews is an empty set of views saved to file
+func NewCameraViews(file string) *CameraViews {
+	return &CameraViews{Bookmarks: map[string]CameraState{}, file: file}
+}
+
+// LoadCameraViews reads the views file, a missing file gives an empty set.
+// Fields missing from a saved view are taken from c, so an older or hand
+// written file can't leave the camera with no speed or acceleration.
+func LoadCameraViews(file string, c *Camera) (*CameraViews, error) {
+	views := NewCameraViews(file)
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return views, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Last      json.RawMessage            `json:"last"`
+		Bookmarks map[string]json.RawMessage `json:"bookmarks"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("camera views %q: %v", file, err)
+	}
+	if len(raw.Last) > 0 && string(raw.Last) != "null" {
+		s := c.State()
+		if err := json.Unmarshal(raw.Last, &s); err != nil {
+			return nil, fmt.Errorf("camera views %q: last: %v", file, err)
+		}
+		views.Last = &s
+	}
+	for name, data := range raw.Bookmarks {
+		s := c.State()
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("camera views %q: bookmark %q: %v", file, name, err)
+		}
+		views.Bookmarks[name] = s
+	}
+	return views, nil
+}
+
+func (v *CameraViews) Save() error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(v.file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(v.file, b, 0644)
+}
+
+func (v *CameraViews) SetBookmark(name string, c *Camera) {
+	v.Bookmarks[name] = c.State()
+}
+
+func (v *CameraViews) ApplyBookmark(name string, c *Camera) error {
+	s, ok := v.Bookmarks[name]
+	if !ok {
+		return fmt.Errorf("no camera bookmark %q", name)
+	}
+	c.SetState(s)
+	return nil
+}
+
+func (v *CameraViews) SetLast(c *Camera) {
+	s := c.State()
+	v.Last = &s
+}
+
+// RestoreLast puts the camera back to the last saved view, if there is one
+func (v *CameraViews) RestoreLast(c *Camera) bool {
+	if v.Last == nil {
+		return false
+	}
+	c.SetState(*v.Last)
+	return true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func writeViews(t *testing.T, data string) string {
+	file := filepath.Join(t.TempDir(), "views.json")
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadCameraViewsSeedsMissingFields(t *testing.T) {
+	file := writeViews(t, `{"bookmarks": {"door": {"position": [1, 2, 3]}}, "last": {"fov": 30}}`)
+	c := NewDefaultCamera()
+	c.SetSpeed(5)
+	views, err := LoadCameraViews(file, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	door := views.Bookmarks["door"]
+	if door.Position != (mgl32.Vec3{1, 2, 3}) {
+		t.Errorf("position %v, want {1 2 3}", door.Position)
+	}
+	want := c.State()
+	if door.Speed != want.Speed || door.Acceleration != want.Acceleration || door.Damping != want.Damping || door.Front != want.Front {
+		t.Errorf("missing fields were not taken from the camera: got %+v, camera %+v", door, want)
+	}
+	if views.Last == nil || views.Last.FOV != 30 || views.Last.Acceleration != want.Acceleration {
+		t.Errorf("last view %+v, want fov 30 and the camera's acceleration", views.Last)
+	}
+}
+
+func TestLoadCameraViewsErrors(t *testing.T) {
+	c := NewDefaultCamera()
+	for _, data := range []string{`{`, `{"bookmarks": {"a": {"speed": "fast"}}}`, `{"last": 3}`} {
+		if _, err := LoadCameraViews(writeViews(t, data), c); err == nil {
+			t.Errorf("%s: no error", data)
+		}
+	}
+	views, err := LoadCameraViews(filepath.Join(t.TempDir(), "missing.json"), c)
+	if err != nil || len(views.Bookmarks) != 0 || views.Last != nil {
+		t.Errorf("missing file gave %+v, %v, want an empty set", views, err)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"math/bits"
+	"path"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// EXT_texture_sRGB formats, the 3.3 core bindings don't export them
+const (
+	compressedSRGBDXT1      = 0x8C4C
+	compressedSRGBAlphaDXT1 = 0x8C4D
+	compressedSRGBAlphaDXT3 = 0x8C4E
+	compressedSRGBAlphaDXT5 = 0x8C4F
+)
+
+type compressedFormat struct {
+	name       string
+	blockBytes int
+	bcn        int    // 1-5 if DecodeBCn can expand it
+	srgb       uint32 // the sRGB version of a linear format
+	opaque     bool   // BC1 without alpha
+}
+
+var compressedFormats = map[uint32]compressedFormat{
+	gl.COMPRESSED_RGB_S3TC_DXT1_EXT:              {name: "BC1 RGB", blockBytes: 8, bcn: 1, srgb: compressedSRGBDXT1, opaque: true},
+	gl.COMPRESSED_RGBA_S3TC_DXT1_EXT:             {name: "BC1 RGBA", blockBytes: 8, bcn: 1, srgb: compressedSRGBAlphaDXT1},
+	gl.COMPRESSED_RGBA_S3TC_DXT3_EXT:             {name: "BC2", blockBytes: 16, bcn: 2, srgb: compressedSRGBAlphaDXT3},
+	gl.COMPRESSED_RGBA_S3TC_DXT5_EXT:             {name: "BC3", blockBytes: 16, bcn: 3, srgb: compressedSRGBAlphaDXT5},
+	compressedSRGBDXT1:                           {name: "BC1 sRGB", blockBytes: 8, bcn: 1, opaque: true},
+	compressedSRGBAlphaDXT1:                      {name: "BC1 sRGB alpha", blockBytes: 8, bcn: 1},
+	compressedSRGBAlphaDXT3:                      {name: "BC2 sRGB", blockBytes: 16, bcn: 2},
+	compressedSRGBAlphaDXT5:                      {name: "BC3 sRGB", blockBytes: 16, bcn: 3},
+	gl.COMPRESSED_RED_RGTC1:                      {name: "BC4", blockBytes: 8, bcn: 4},
+	gl.COMPRESSED_SIGNED_RED_RGTC1:               {name: "BC4 signed", blockBytes: 8},
+	gl.COMPRESSED_RG_RGTC2:                       {name: "BC5", blockBytes: 16, bcn: 5},
+	gl.COMPRESSED_SIGNED_RG_RGTC2:                {name: "BC5 signed", blockBytes: 16},
+	gl.COMPRESSED_RGB_BPTC_UNSIGNED_FLOAT_ARB:    {name: "BC6H", blockBytes: 16},
+	gl.COMPRESSED_RGB_BPTC_SIGNED_FLOAT_ARB:      {name: "BC6H signed", blockBytes: 16},
+	gl.COMPRESSED_RGBA_BPTC_UNORM_ARB:            {name: "BC7", blockBytes: 16, srgb: gl.COMPRESSED_SRGB_ALPHA_BPTC_UNORM_ARB},
+	gl.COMPRESSED_SRGB_ALPHA_BPTC_UNORM_ARB:      {name: "BC7 sRGB", blockBytes: 16},
+	gl.COMPRESSED_RGB8_ETC2:                      {name: "ETC2 RGB", blockBytes: 8, srgb: gl.COMPRESSED_SRGB8_ETC2},
+	gl.COMPRESSED_SRGB8_ETC2:                     {name: "ETC2 sRGB", blockBytes: 8},
+	gl.COMPRESSED_RGB8_PUNCHTHROUGH_ALPHA1_ETC2:  {name: "ETC2 RGB A1", blockBytes: 8, srgb: gl.COMPRESSED_SRGB8_PUNCHTHROUGH_ALPHA1_ETC2},
+	gl.COMPRESSED_SRGB8_PUNCHTHROUGH_ALPHA1_ETC2: {name: "ETC2 sRGB A1", blockBytes: 8},
+	gl.COMPRESSED_RGBA8_ETC2_EAC:                 {name: "ETC2 RGBA", blockBytes: 16, srgb: gl.COMPRESSED_SRGB8_ALPHA8_ETC2_EAC},
+	gl.COMPRESSED_SRGB8_ALPHA8_ETC2_EAC:          {name: "ETC2 sRGB alpha", blockBytes: 16},
+	gl.COMPRESSED_R11_EAC:                        {name: "EAC R11", blockBytes: 8},
+	gl.COMPRESSED_SIGNED_R11_EAC:                 {name: "EAC R11 signed", blockBytes: 8},
+	gl.COMPRESSED_RG11_EAC:                       {name: "EAC RG11", blockBytes: 16},
+	gl.COMPRESSED_SIGNED_RG11_EAC:                {name: "EAC RG11 signed", blockBytes: 16},
+}
+
+func compressedFormatName(format uint32) string {
+	if info, ok := compressedFormats[format]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("format 0x%04X", format)
+}
+
+// CompressedImageSize is the number of bytes of one image in a block format.
+// Sizes past maxImageSize are refused so the result can't overflow.
+func CompressedImageSize(format uint32, width, height int) (int, error) {
+	info, ok := compressedFormats[format]
+	if !ok {
+		return 0, fmt.Errorf("unsupported compressed %s", compressedFormatName(format))
+	}
+	if width <= 0 || height <= 0 || width > maxImageSize || height > maxImageSize {
+		return 0, fmt.Errorf("bad compressed image size %dx%d", width, height)
+	}
+	return ((width + 3) / 4) * ((height + 3) / 4) * info.blockBytes, nil
+}
+
+// maxTextureLayers caps the array layers a container can claim, well past
+// what drivers allow for an array texture
+const maxTextureLayers = 2048
+
+// maxMipLevels is the length of a full mip chain down to 1x1
+func maxMipLevels(width, height int) int {
+	if height > width {
+		width = height
+	}
+	return bits.Len(uint(width))
+}
+
+// checkCompressedHeader rejects the sizes from a container header that no
+// texture can have, before anything is allocated for them. With these
+// bounds the size of a whole level fits easily in 64 bits.
+func checkCompressedHeader(width, height, layers, faces, levels uint32) error {
+	if width == 0 || height == 0 {
+		return errors.New("empty texture")
+	}
+	if width > maxImageSize || height > maxImageSize {
+		return fmt.Errorf("%dx%d texture is larger than the %d pixel limit", width, height, maxImageSize)
+	}
+	if layers > maxTextureLayers {
+		return fmt.Errorf("%d layers, the limit is %d", layers, maxTextureLayers)
+	}
+	if faces != 1 && faces != 6 {
+		return fmt.Errorf("%d faces, a texture has 1 and a cubemap 6", faces)
+	}
+	if limit := maxMipLevels(int(width), int(height)); int(levels) > limit {
+		return fmt.Errorf("%d mip levels, a %dx%d texture has at most %d", levels, width, height, limit)
+	}
+	return nil
+}
+
+// CompressedTexture is block compressed data read from a KTX, KTX2 or DDS
+// file, or produced by CompressImage
+type CompressedTexture struct {
+	Format        uint32 // GL internal format
+	Width, Height int
+	Layers        int // array layers, 1 for a plain texture
+	Faces         int // 6 for a cubemap, otherwise 1
+
+	// Images[level][layer*Faces+face] holds the blocks of one image
+	Images [][][]byte
+}
+
+func (t *CompressedTexture) Levels() int {
+	return len(t.Images)
+}
+
+// LevelSize is the size of a mip level in texels
+func (t *CompressedTexture) LevelSize(level int) (int, int) {
+	w, h := t.Width, t.Height
+	for i := 0; i < level; i++ {
+		w, h = halve(w), halve(h)
+	}
+	return w, h
+}
+
+// Target is the texture target the data uploads to
+func (t *CompressedTexture) Target() uint32 {
+	switch {
+	case t.Faces == 6:
+		return gl.TEXTURE_CUBE_MAP
+	case t.Layers > 1:
+		return gl.TEXTURE_2D_ARRAY
+	}
+	return gl.TEXTURE_2D
+}
+
+// Validate checks that every image has the size its format and level need
+func (t *CompressedTexture) Validate() error {
+	if t.Width <= 0 || t.Height <= 0 {
+		return errors.New("empty compressed texture")
+	}
+	if t.Faces != 1 && t.Faces != 6 {
+		return fmt.Errorf("%d faces, a texture has 1 and a cubemap 6", t.Faces)
+	}
+	if t.Faces == 6 && t.Layers > 1 {
+		return errors.New("cubemap arrays are not supported")
+	}
+	if len(t.Images) == 0 {
+		return errors.New("compressed texture has no levels")
+	}
+	for level, images := range t.Images {
+		if len(images) != t.Layers*t.Faces {
+			return fmt.Errorf("level %d has %d images, expected %d", level, len(images), t.Layers*t.Faces)
+		}
+		w, h := t.LevelSize(level)
+		size, err := CompressedImageSize(t.Format, w, h)
+		if err != nil {
+			return err
+		}
+		for i, img := range images {
+			if len(img) != size {
+				return fmt.Errorf("level %d image %d is %d bytes, %s at %dx%d needs %d", level, i, len(img), compressedFormatName(t.Format), w, h, size)
+			}
+		}
+	}
+	return nil
+}
+
+// Decode expands one image for a CPU preview, BC1 to BC5 only
+func (t *CompressedTexture) Decode(level, index int) (*image.RGBA, error) {
+	if level >= len(t.Images) || index >= len(t.Images[level]) {
+		return nil, fmt.Errorf("no image %d at level %d", index, level)
+	}
+	w, h := t.LevelSize(level)
+	img, err := DecodeBCn(t.Format, w, h, t.Images[level][index])
+	if err != nil {
+		return nil, err
+	}
+	if compressedFormats[t.Format].opaque {
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 255
+		}
+	}
+	return img, nil
+}
+
+// IsCompressedTextureFile reports whether file is a container read by
+// LoadCompressedTexture rather than an image
+func IsCompressedTextureFile(file string) bool {
+	switch strings.ToLower(path.Ext(file)) {
+	case ".ktx", ".ktx2", ".dds":
+		return true
+	}
+	return false
+}
+
+func LoadCompressedTexture(fsys fs.FS, file string) (*CompressedTexture, error) {
+	b, err := fs.ReadFile(fsys, path.Clean(file))
+	if err != nil {
+		return nil, fmt.Errorf("texture %q not found: %v", file, err)
+	}
+	var t *CompressedTexture
+	switch strings.ToLower(path.Ext(file)) {
+	case ".ktx":
+		t, err = DecodeKTX(b)
+	case ".ktx2":
+		t, err = DecodeKTX2(b)
+	case ".dds":
+		t, err = DecodeDDS(b)
+	default:
+		err = errors.New("unknown container")
+	}
+	if err == nil {
+		err = t.Validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("texture %q: %v", file, err)
+	}
+	return t, nil
+}
+
+// CompressImage bakes an image into BC1 or BC3 with a mip chain when the
+// options ask for one, CPU mips use the box filter unless MipFilter is set.
+func CompressImage(img *image.RGBA, format uint32, opts TextureOptions) (*CompressedTexture, error) {
+	var encode func(*image.RGBA) []byte
+	switch format {
+	case gl.COMPRESSED_RGB_S3TC_DXT1_EXT, gl.COMPRESSED_RGBA_S3TC_DXT1_EXT, compressedSRGBDXT1, compressedSRGBAlphaDXT1:
+		encode = EncodeBC1
+	case gl.COMPRESSED_RGBA_S3TC_DXT5_EXT, compressedSRGBAlphaDXT5:
+		encode = EncodeBC3
+	default:
+		return nil, fmt.Errorf("cannot encode %s, only BC1 and BC3", compressedFormatName(format))
+	}
+
+	levels := []*image.RGBA{img}
+	if opts.Mipmaps {
+		if opts.MipFilter == "" {
+			opts.MipFilter = MipFilterBox
+		}
+		var err error
+		if levels, err = GenerateMips(img, opts.mipOptions()); err != nil {
+			return nil, err
+		}
+	}
+	size := img.Rect.Size()
+	t := &CompressedTexture{Format: format, Width: size.X, Height: size.Y, Layers: 1, Faces: 1}
+	for _, level := range levels {
+		t.Images = append(t.Images, [][]byte{encode(level)})
+	}
+	return t, nil
+}
+
+// UploadCompressedTexture creates a texture from block compressed data
+// without expanding it. Sampling options apply as for other textures, but
+// mips can only come from the file. A format the driver can't sample is
+// expanded to RGBA on the CPU if DecodeBCn can read it, otherwise it is an
+// error.
+func UploadCompressedTexture(t *CompressedTexture, opts TextureOptions) (uint32, error) {
+	if err := t.Validate(); err != nil {
+		return 0, err
+	}
+	format := t.Format
+	if info := compressedFormats[format]; opts.SRGB && info.srgb != 0 {
+		format = info.srgb
+	}
+	expand := !CompressedFormatSupported(format)
+	if expand && compressedFormats[t.Format].bcn == 0 {
+		return 0, fmt.Errorf("%s textures are not supported by the driver", compressedFormatName(format))
+	}
+	internal := opts.internalFormat()
+	if isCompressedSRGB(format) {
+		internal = gl.SRGB8_ALPHA8
+	}
+	if t.Levels() == 1 {
+		opts = opts.withoutMipmaps()
+	}
+
+	target := t.Target()
+	var texture uint32
+	gl.GenTextures(1, &texture)
+	gl.BindTexture(target, texture)
+	applyTextureOptions(target, opts)
+	gl.TexParameteri(target, gl.TEXTURE_MAX_LEVEL, int32(t.Levels()-1))
+	clearGLErrors()
+
+	fail := func(err error) (uint32, error) {
+		gl.DeleteTextures(1, &texture)
+		return 0, err
+	}
+	for level, images := range t.Images {
+		w, h := t.LevelSize(level)
+		if expand {
+			var err error
+			if images, err = t.expandLevel(level); err != nil {
+				return fail(err)
+			}
+		}
+		switch target {
+		case gl.TEXTURE_2D, gl.TEXTURE_CUBE_MAP:
+			for i, img := range images {
+				face := target
+				if target == gl.TEXTURE_CUBE_MAP {
+					face = gl.TEXTURE_CUBE_MAP_POSITIVE_X + uint32(i)
+				}
+				if expand {
+					gl.TexImage2D(face, int32(level), internal, int32(w), int32(h), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(img))
+				} else {
+					gl.CompressedTexImage2D(face, int32(level), format, int32(w), int32(h), 0, int32(len(img)), gl.Ptr(img))
+				}
+				if err := uploadError(format, level); err != nil {
+					return fail(err)
+				}
+			}
+		case gl.TEXTURE_2D_ARRAY:
+			var layers []byte
+			for _, img := range images {
+				layers = append(layers, img...)
+			}
+			if expand {
+				gl.TexImage3D(target, int32(level), internal, int32(w), int32(h), int32(t.Layers), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(layers))
+			} else {
+				gl.CompressedTexImage3D(target, int32(level), format, int32(w), int32(h), int32(t.Layers), 0, int32(len(layers)), gl.Ptr(layers))
+			}
+			if err := uploadError(format, level); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	return texture, nil
+}
+
+// expandLevel decodes every image of a level to RGBA pixels
+func (t *CompressedTexture) expandLevel(level int) ([][]byte, error) {
+	images := make([][]byte, len(t.Images[level]))
+	for i := range images {
+		img, err := t.Decode(level, i)
+		if err != nil {
+			return nil, err
+		}
+		images[i] = img.Pix
+	}
+	return images, nil
+}
+
+// uploadError picks up an error left by the last upload of a level
+func uploadError(format uint32, level int) error {
+	if code := gl.GetError(); code != gl.NO_ERROR {
+		return fmt.Errorf("uploading %s level %d: GL error 0x%04X", compressedFormatName(format), level, code)
+	}
+	return nil
+}
+
+func isCompressedSRGB(format uint32) bool {
+	for _, info := range compressedFormats {
+		if info.srgb == format {
+			return true
+		}
+	}
+	return false
+}
+
+var compressedSupport map[uint32]bool
+
+// CompressedFormatSupported reports whether the context can sample a block
+// format. Drivers leave formats out of GL_COMPRESSED_TEXTURE_FORMATS, so the
+// extension or version that adds each family counts as well.
+func CompressedFormatSupported(format uint32) bool {
+	if compressedSupport == nil {
+		compressedSupport = queryCompressedFormats()
+	}
+	return compressedSupport[format]
+}
+
+func queryCompressedFormats() map[uint32]bool {
+	supported := map[uint32]bool{}
+	var n int32
+	gl.GetIntegerv(gl.NUM_COMPRESSED_TEXTURE_FORMATS, &n)
+	if n > 0 {
+		formats := make([]int32, n)
+		gl.GetIntegerv(gl.COMPRESSED_TEXTURE_FORMATS, &formats[0])
+		for _, f := range formats {
+			supported[uint32(f)] = true
+		}
+	}
+
+	exts := map[string]bool{}
+	gl.GetIntegerv(gl.NUM_EXTENSIONS, &n)
+	for i := int32(0); i < n; i++ {
+		exts[gl.GoStr(gl.GetStringi(gl.EXTENSIONS, uint32(i)))] = true
+	}
+	s3tc := exts["GL_EXT_texture_compression_s3tc"]
+	s3tcSRGB := s3tc && (exts["GL_EXT_texture_sRGB"] || exts["GL_EXT_texture_compression_s3tc_srgb"])
+	bptc := GLVersionAtLeast(4, 2) || exts["GL_ARB_texture_compression_bptc"]
+	etc2 := GLVersionAtLeast(4, 3) || exts["GL_ARB_ES3_compatibility"]
+
+	for format := range compressedFormats {
+		switch format {
+		case gl.COMPRESSED_RGB_S3TC_DXT1_EXT, gl.COMPRESSED_RGBA_S3TC_DXT1_EXT, gl.COMPRESSED_RGBA_S3TC_DXT3_EXT, gl.COMPRESSED_RGBA_S3TC_DXT5_EXT:
+			supported[format] = supported[format] || s3tc
+		case compressedSRGBDXT1, compressedSRGBAlphaDXT1, compressedSRGBAlphaDXT3, compressedSRGBAlphaDXT5:
+			supported[format] = supported[format] || s3tcSRGB
+		case gl.COMPRESSED_RED_RGTC1, gl.COMPRESSED_SIGNED_RED_RGTC1, gl.COMPRESSED_RG_RGTC2, gl.COMPRESSED_SIGNED_RG_RGTC2:
+			// core since 3.0
+			supported[format] = true
+		case gl.COMPRESSED_RGB_BPTC_UNSIGNED_FLOAT_ARB, gl.COMPRESSED_RGB_BPTC_SIGNED_FLOAT_ARB, gl.COMPRESSED_RGBA_BPTC_UNORM_ARB, gl.COMPRESSED_SRGB_ALPHA_BPTC_UNORM_ARB:
+			supported[format] = supported[format] || bptc
+		default:
+			// ETC2 and EAC
+			supported[format] = supported[format] || etc2
+		}
+	}
+	clearGLErrors()
+	return supported
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+func gradientImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 128, uint8(255 - x*128/w)})
+		}
+	}
+	return img
+}
+
+// maxChannelError is the largest difference of any channel, alpha is
+// skipped for opaque formats
+func maxChannelError(a, b *image.RGBA, alpha bool) int {
+	worst := 0
+	for i := range a.Pix {
+		if !alpha && i%4 == 3 {
+			continue
+		}
+		d := int(a.Pix[i]) - int(b.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		if d > worst {
+			worst = d
+		}
+	}
+	return worst
+}
+
+func TestCompressedKTXRoundTrip(t *testing.T) {
+	src := gradientImage(64, 64)
+	opts := DefaultTextureOptions()
+	for _, format := range []uint32{gl.COMPRESSED_RGB_S3TC_DXT1_EXT, gl.COMPRESSED_RGBA_S3TC_DXT5_EXT} {
+		name := compressedFormatName(format)
+		baked, err := CompressImage(src, format, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if baked.Levels() != 7 {
+			t.Errorf("%s: %d levels, want 7 down to 1x1", name, baked.Levels())
+		}
+
+		var buf bytes.Buffer
+		if err := EncodeKTX(&buf, baked); err != nil {
+			t.Fatalf("%s: encode: %v", name, err)
+		}
+		decoded, err := DecodeKTX(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: decode: %v", name, err)
+		}
+		if err := decoded.Validate(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if decoded.Format != format || decoded.Levels() != baked.Levels() {
+			t.Fatalf("%s: got %s with %d levels", name, compressedFormatName(decoded.Format), decoded.Levels())
+		}
+		for level := range baked.Images {
+			if !bytes.Equal(decoded.Images[level][0], baked.Images[level][0]) {
+				t.Errorf("%s: level %d blocks changed in the round trip", name, level)
+			}
+		}
+
+		img, err := decoded.Decode(0, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		alpha := !compressedFormats[format].opaque
+		if e := maxChannelError(src, img, alpha); e > 12 {
+			t.Errorf("%s: decoded image is off by up to %d", name, e)
+		}
+	}
+}
+
+func TestBakeTexture(t *testing.T) {
+	var pngData bytes.Buffer
+	png.Encode(&pngData, gradientImage(8, 4))
+	fsys := fstest.MapFS{
+		"textures/wall.png":      {Data: pngData.Bytes()},
+		"textures/wall.png.json": {Data: []byte(`{"srgb": true}`)},
+	}
+	var out bytes.Buffer
+	if err := BakeTexture(fsys, "textures/wall.png", BakeFormats["bc3"], &out); err != nil {
+		t.Fatal(err)
+	}
+	baked, err := DecodeKTX(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baked.Format != compressedSRGBAlphaDXT5 {
+		t.Errorf("baked %s, want the sRGB format from the sidecar", compressedFormatName(baked.Format))
+	}
+	if baked.Width != 8 || baked.Height != 4 || baked.Levels() != 4 {
+		t.Errorf("baked %dx%d with %d levels, want 8x4 with 4", baked.Width, baked.Height, baked.Levels())
+	}
+}
+
+// ktx2File writes t as KTX 2, the inverse of DecodeKTX2
+func ktx2File(t *CompressedTexture) []byte {
+	var vkFormat uint32
+	for vk, format := range ktx2Formats {
+		if format == t.Format {
+			vkFormat = vk
+		}
+	}
+	h := ktx2Header{
+		VkFormat:    vkFormat,
+		TypeSize:    1,
+		PixelWidth:  uint32(t.Width),
+		PixelHeight: uint32(t.Height),
+		FaceCount:   uint32(t.Faces),
+		LevelCount:  uint32(t.Levels()),
+	}
+	if t.Layers > 1 {
+		h.LayerCount = uint32(t.Layers)
+	}
+	offset := uint64(len(ktx2Identifier) + binary.Size(h) + binary.Size(ktx2Level{})*t.Levels())
+	var data bytes.Buffer
+	index := make([]ktx2Level, t.Levels())
+	for level, images := range t.Images {
+		index[level].ByteOffset = offset + uint64(data.Len())
+		for _, img := range images {
+			data.Write(img)
+		}
+		index[level].ByteLength = offset + uint64(data.Len()) - index[level].ByteOffset
+		index[level].UncompressedByteLength = index[level].ByteLength
+	}
+	var buf bytes.Buffer
+	buf.Write(ktx2Identifier)
+	binary.Write(&buf, binary.LittleEndian, h)
+	binary.Write(&buf, binary.LittleEndian, index)
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
+
+// ddsFile writes a BC3 texture as DDS with every mip of a face together
+func ddsFile(t *CompressedTexture) []byte {
+	var h ddsHeader
+	h.Size = 124
+	h.Flags = ddsMipmapCount
+	h.Width, h.Height = uint32(t.Width), uint32(t.Height)
+	h.MipMapCount = uint32(t.Levels())
+	h.PixelFormat.Size = 32
+	h.PixelFormat.Flags = ddsFourCC
+	h.PixelFormat.FourCC = binary.LittleEndian.Uint32([]byte("DXT5"))
+	if t.Faces == 6 {
+		h.Caps2 = ddsCubemap
+	}
+	var buf bytes.Buffer
+	buf.WriteString("DDS ")
+	binary.Write(&buf, binary.LittleEndian, h)
+	for i := 0; i < t.Layers*t.Faces; i++ {
+		for _, images := range t.Images {
+			buf.Write(images[i])
+		}
+	}
+	return buf.Bytes()
+}
+
+func bakedBC3(t *testing.T) *CompressedTexture {
+	baked, err := CompressImage(gradientImage(16, 8), gl.COMPRESSED_RGBA_S3TC_DXT5_EXT, DefaultTextureOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return baked
+}
+
+var containerDecoders = []struct {
+	name   string
+	encode func(*CompressedTexture) []byte
+	decode func([]byte) (*CompressedTexture, error)
+}{
+	{"ktx", func(t *CompressedTexture) []byte {
+		var buf bytes.Buffer
+		EncodeKTX(&buf, t)
+		return buf.Bytes()
+	}, DecodeKTX},
+	{"ktx2", ktx2File, DecodeKTX2},
+	{"dds", ddsFile, DecodeDDS},
+}
+
+func TestContainerRoundTrip(t *testing.T) {
+	baked := bakedBC3(t)
+	for _, c := range containerDecoders {
+		decoded, err := c.decode(c.encode(baked))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if err := decoded.Validate(); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		for level := range baked.Images {
+			if !bytes.Equal(decoded.Images[level][0], baked.Images[level][0]) {
+				t.Errorf("%s: level %d blocks changed in the round trip", c.name, level)
+			}
+		}
+	}
+}
+
+func TestContainerHostileHeaders(t *testing.T) {
+	baked := bakedBC3(t)
+	ktx := containerDecoders[0].encode(baked)
+	ktx2 := ktx2File(baked)
+	dds := ddsFile(baked)
+	put := func(file []byte, offset int, v uint32) []byte {
+		b := append([]byte(nil), file...)
+		binary.LittleEndian.PutUint32(b[offset:], v)
+		return b
+	}
+	put64 := func(file []byte, offset int, v uint64) []byte {
+		b := append([]byte(nil), file...)
+		binary.LittleEndian.PutUint64(b[offset:], v)
+		return b
+	}
+	ktxField := func(n int) int { return len(ktxIdentifier) + 4*n }
+	ktx2Field := func(n int) int { return len(ktx2Identifier) + 4*n }
+	ktx2Index := len(ktx2Identifier) + binary.Size(ktx2Header{})
+
+	tests := []struct {
+		name   string
+		decode func([]byte) (*CompressedTexture, error)
+		data   []byte
+	}{
+		{"ktx huge width", DecodeKTX, put(ktx, ktxField(6), 0xFFFFFFFF)},
+		{"ktx huge size", DecodeKTX, put(put(ktx, ktxField(6), 0xFFFFFFFF), ktxField(7), 0xFFFFFFFF)},
+		{"ktx huge layers", DecodeKTX, put(ktx, ktxField(9), 0xFFFFFFFF)},
+		{"ktx bad faces", DecodeKTX, put(ktx, ktxField(10), 5)},
+		{"ktx too many levels", DecodeKTX, put(ktx, ktxField(11), 0xFFFFFFFF)},
+		{"ktx huge key/value data", DecodeKTX, put(ktx, ktxField(12), 0xFFFFFFF0)},
+		{"ktx truncated", DecodeKTX, ktx[:len(ktx)-9]},
+		{"ktx2 huge width", DecodeKTX2, put(ktx2, ktx2Field(2), 0xFFFFFFFF)},
+		{"ktx2 too many levels", DecodeKTX2, put(ktx2, ktx2Field(7), 0xFFFFFFFF)},
+		{"ktx2 huge layers", DecodeKTX2, put(ktx2, ktx2Field(5), 0xFFFFFFFF)},
+		{"ktx2 wrapping offset", DecodeKTX2, put64(ktx2, ktx2Index, 0xFFFFFFFFFFFFFFF0)},
+		{"ktx2 offset past the end", DecodeKTX2, put64(ktx2, ktx2Index, uint64(len(ktx2)))},
+		{"dds huge mip count", DecodeDDS, put(dds, 4+24, 0xFFFFFFF0)},
+		{"dds huge width", DecodeDDS, put(dds, 4+12, 0xFFFFFFFF)},
+		{"dds zero height", DecodeDDS, put(dds, 4+8, 0)},
+		{"dds truncated", DecodeDDS, dds[:len(dds)-1]},
+	}
+	for _, tt := range tests {
+		if _, err := tt.decode(tt.data); err == nil {
+			t.Errorf("%s: decoded without an error", tt.name)
+		}
+	}
+}
+
+// TestContainerCorruption mutates valid files at random, a decoder must
+// return an error or a texture that validates, never panic or run out of
+// memory
+func TestContainerCorruption(t *testing.T) {
+	baked := bakedBC3(t)
+	rng := rand.New(rand.NewSource(1))
+	for _, c := range containerDecoders {
+		valid := c.encode(baked)
+		for i := 0; i < 2000; i++ {
+			data := append([]byte(nil), valid...)
+			for n := rng.Intn(4) + 1; n > 0; n-- {
+				at := rng.Intn(len(data) - 4)
+				switch rng.Intn(3) {
+				case 0:
+					data[at] = byte(rng.Intn(256))
+				case 1:
+					binary.LittleEndian.PutUint32(data[at:], 0xFFFFFFFF)
+				default:
+					binary.LittleEndian.PutUint32(data[at:], rng.Uint32())
+				}
+			}
+			if rng.Intn(4) == 0 {
+				data = data[:rng.Intn(len(data))]
+			}
+			decoded, err := c.decode(data)
+			if err != nil {
+				continue
+			}
+			if err := decoded.Validate(); err == nil {
+				decoded.Decode(0, 0)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// ComputeProgram is a program with a single compute stage. It needs an
+// OpenGL 4.3 context, see the -gl43 flag.
+type ComputeProgram struct {
+	*ShaderProgram
+
+	localSize   [3]uint32
+	localSizeOf uint32 // program ID localSize was read from
+}
+
+func NewComputeProgram(fsys fs.FS, file string, defines ShaderDefines) (*ComputeProgram, error) {
+	if !GLVersionAtLeast(4, 3) {
+		return nil, fmt.Errorf("compute shader %q needs an OpenGL 4.3 context", file)
+	}
+	stage, err := shaderStage(file)
+	if err != nil {
+		return nil, err
+	}
+	if stage != gl.COMPUTE_SHADER {
+		return nil, fmt.Errorf("%q is a %s shader, not a compute shader", file, stageName(stage))
+	}
+	p, err := buildShaderProgram(fsys, []uint32{gl.COMPUTE_SHADER}, []string{file}, defines)
+	if err != nil {
+		return nil, err
+	}
+	return &ComputeProgram{ShaderProgram: p}, nil
+}
+
+// LocalSize is the work group size declared with layout(local_size_x...)
+func (c *ComputeProgram) LocalSize() [3]uint32 {
+	// the size can change when the program is reloaded
+	if c.localSizeOf != c.ID {
+		var size [3]int32
+		gl.GetProgramiv(c.ID, gl.COMPUTE_WORK_GROUP_SIZE, &size[0])
+		c.localSize = [3]uint32{uint32(size[0]), uint32(size[1]), uint32(size[2])}
+		c.localSizeOf = c.ID
+	}
+	return c.localSize
+}
+
+// Dispatch runs the given number of work groups
+func (c *ComputeProgram) Dispatch(x, y, z uint32) {
+	c.Use()
+	gl.DispatchCompute(x, y, z)
+}
+
+// DispatchSize runs enough work groups to cover width x height x depth invocations
+func (c *ComputeProgram) DispatchSize(width, height, depth uint32) {
+	size := c.LocalSize()
+	c.Dispatch(groupCount(width, size[0]), groupCount(height, size[1]), groupCount(depth, size[2]))
+}
+
+// Barrier waits for compute writes before they are read, e.g.
+// gl.SHADER_STORAGE_BARRIER_BIT before drawing particles from an SSBO
+func (c *ComputeProgram) Barrier(bits uint32) {
+	gl.MemoryBarrier(bits)
+}
+
+func groupCount(n, size uint32) uint32 {
+	if size == 0 {
+		size = 1
+	}
+	return (n + size - 1) / size
+}
+
+// GLVersionAtLeast reports whether the current context is at least major.minor
+func GLVersionAtLeast(major, minor int32) bool {
+	var ctxMajor, ctxMinor int32
+	gl.GetIntegerv(gl.MAJOR_VERSION, &ctxMajor)
+	gl.GetIntegerv(gl.MINOR_VERSION, &ctxMinor)
+	return ctxMajor > major || (ctxMajor == major && ctxMinor >= minor)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io/fs"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// Cubemap faces in the order of the GL targets, TEXTURE_CUBE_MAP_POSITIVE_X
+// plus the index
+const (
+	CubePositiveX = iota
+	CubeNegativeX
+	CubePositiveY
+	CubeNegativeY
+	CubePositiveZ
+	CubeNegativeZ
+)
+
+// cubeFaceNames are the file names, without extension, accepted for each
+// face of a cubemap directory
+var cubeFaceNames = [6][]string{
+	{"px", "posx", "right", "+x"},
+	{"nx", "negx", "left", "-x"},
+	{"py", "posy", "top", "up", "+y"},
+	{"ny", "negy", "bottom", "down", "-y"},
+	{"pz", "posz", "front", "+z"},
+	{"nz", "negz", "back", "-z"},
+}
+
+func cubeFace(file string) (int, bool) {
+	name := strings.ToLower(strings.TrimSuffix(file, path.Ext(file)))
+	for face, names := range cubeFaceNames {
+		for _, n := range names {
+			if name == n {
+				return face, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// LoadCubemapFaces reads the six faces of a cubemap from a directory with
+// one image per face, named px/nx/py/ny/pz/nz or right/left/top/bottom/
+// front/back with any image extension.
+func LoadCubemapFaces(fsys fs.FS, dir string) ([6]image.Image, error) {
+	var faces [6]image.Image
+	files, err := fs.ReadDir(fsys, path.Clean(dir))
+	if err != nil {
+		return faces, err
+	}
+	for _, f := range files {
+		face, ok := cubeFace(f.Name())
+		if f.IsDir() || !ok {
+			continue
+		}
+		if faces[face] != nil {
+			return faces, fmt.Errorf("cubemap %q has two files for face %s", dir, cubeFaceNames[face][0])
+		}
+		if faces[face], err = DecodeImage(fsys, path.Join(dir, f.Name())); err != nil {
+			return faces, err
+		}
+	}
+	for face, img := range faces {
+		if img == nil {
+			return faces, fmt.Errorf("cubemap %q has no %s face", dir, cubeFaceNames[face][0])
+		}
+	}
+	return faces, nil
+}
+
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// CrossToCubeFaces cuts a horizontal cross, four faces wide and three high:
+//
+//	    +Y
+//	-X  +Z  +X  -Z
+//	    -Y
+func CrossToCubeFaces(img image.Image) ([6]image.Image, error) {
+	var faces [6]image.Image
+	b := img.Bounds()
+	size := b.Dx() / 4
+	if size == 0 || b.Dx() != size*4 || b.Dy() != size*3 {
+		return faces, fmt.Errorf("a cubemap cross must be 4:3 with square faces, not %dx%d", b.Dx(), b.Dy())
+	}
+	sub, ok := img.(subImager)
+	if !ok {
+		return faces, fmt.Errorf("cannot cut faces from a %T", img)
+	}
+	cells := [6]image.Point{
+		CubePositiveX: {2, 1},
+		CubeNegativeX: {0, 1},
+		CubePositiveY: {1, 0},
+		CubeNegativeY: {1, 2},
+		CubePositiveZ: {1, 1},
+		CubeNegativeZ: {3, 1},
+	}
+	for face, c := range cells {
+		min := b.Min.Add(c.Mul(size))
+		faces[face] = sub.SubImage(image.Rectangle{min, min.Add(image.Pt(size, size))})
+	}
+	return faces, nil
+}
+
+// CubeFaceDirection is the direction through texel (x, y) of a face, in the
+// orientation GL samples cubemaps with. Row 0 is the first row uploaded.
+func CubeFaceDirection(face, x, y, size int) [3]float64 {
+	s := 2*(float64(x)+0.5)/float64(size) - 1
+	t := 2*(float64(y)+0.5)/float64(size) - 1
+	var d [3]float64
+	switch face {
+	case CubePositiveX:
+		d = [3]float64{1, -t, -s}
+	case CubeNegativeX:
+		d = [3]float64{-1, -t, s}
+	case CubePositiveY:
+		d = [3]float64{s, 1, t}
+	case CubeNegativeY:
+		d = [3]float64{s, -1, -t}
+	case CubePositiveZ:
+		d = [3]float64{s, -t, 1}
+	case CubeNegativeZ:
+		d = [3]float64{-s, -t, -1}
+	}
+	l := math.Sqrt(d[0]*d[0] + d[1]*d[1] + d[2]*d[2])
+	return [3]float64{d[0] / l, d[1] / l, d[2] / l}
+}
+
+// EquirectToCubeFaces resamples a latitude-longitude panorama into six
+// faces of size texels. HDR input keeps its range.
+func EquirectToCubeFaces(img image.Image, size int) [6]image.Image {
+	var faces [6]image.Image
+	hdr, isHDR := img.(*HDRImage)
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	texel := func(x, y int) [4]float64 {
+		x = ((x % w) + w) % w
+		if y < 0 {
+			y = 0
+		} else if y >= h {
+			y = h - 1
+		}
+		if isHDR {
+			i := y*hdr.Stride + x*3
+			return [4]float64{float64(hdr.Pix[i]), float64(hdr.Pix[i+1]), float64(hdr.Pix[i+2]), 1}
+		}
+		r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+		return [4]float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(bl) / 0xffff, float64(a) / 0xffff}
+	}
+	sample := func(u, v float64) [4]float64 {
+		fx, fy := u*float64(w)-0.5, v*float64(h)-0.5
+		x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+		tx, ty := fx-float64(x0), fy-float64(y0)
+		var out [4]float64
+		c00, c10, c01, c11 := texel(x0, y0), texel(x0+1, y0), texel(x0, y0+1), texel(x0+1, y0+1)
+		for i := range out {
+			top := c00[i]*(1-tx) + c10[i]*tx
+			bottom := c01[i]*(1-tx) + c11[i]*tx
+			out[i] = top*(1-ty) + bottom*ty
+		}
+		return out
+	}
+
+	for face := range faces {
+		var out image.Image
+		var hdrFace *HDRImage
+		var rgbaFace *image.RGBA
+		if isHDR {
+			hdrFace = NewHDRImage(image.Rect(0, 0, size, size))
+			out = hdrFace
+		} else {
+			rgbaFace = image.NewRGBA(image.Rect(0, 0, size, size))
+			out = rgbaFace
+		}
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				d := CubeFaceDirection(face, x, y, size)
+				u := 0.5 + math.Atan2(d[0], -d[2])/(2*math.Pi)
+				v := 0.5 - math.Asin(d[1])/math.Pi
+				c := sample(u, v)
+				if isHDR {
+					i := y*hdrFace.Stride + x*3
+					hdrFace.Pix[i], hdrFace.Pix[i+1], hdrFace.Pix[i+2] = float32(c[0]), float32(c[1]), float32(c[2])
+				} else {
+					rgbaFace.SetRGBA(x, y, color.RGBA{quantize(float32(c[0])), quantize(float32(c[1])), quantize(float32(c[2])), quantize(float32(c[3]))})
+				}
+			}
+		}
+		faces[face] = out
+	}
+	return faces
+}
+
+// GradientCubeFaces builds a sky that fades from horizon to zenith above
+// and to ground below, the fallback when no skybox is shipped.
+func GradientCubeFaces(size int, zenith, horizon, ground color.RGBA) [6]image.Image {
+	var faces [6]image.Image
+	mix := func(a, b color.RGBA, t float64) color.RGBA {
+		l := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*t + 0.5) }
+		return color.RGBA{l(a.R, b.R), l(a.G, b.G), l(a.B, b.B), l(a.A, b.A)}
+	}
+	for face := range faces {
+		img := image.NewRGBA(image.Rect(0, 0, size, size))
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				up := CubeFaceDirection(face, x, y, size)[1]
+				c := mix(horizon, zenith, math.Sqrt(math.Max(up, 0)))
+				if up < 0 {
+					c = mix(horizon, ground, math.Sqrt(-up))
+				}
+				img.SetRGBA(x, y, c)
+			}
+		}
+		faces[face] = img
+	}
+	return faces
+}
+
+// UploadCubemap creates a cubemap texture from six square faces of one size
+func UploadCubemap(faces [6]image.Image, opts TextureOptions) (uint32, error) {
+	size := faces[0].Bounds().Size()
+	for face, img := range faces {
+		if s := img.Bounds().Size(); s != size || s.X != s.Y || s.X == 0 {
+			return 0, fmt.Errorf("cubemap face %s is %dx%d, faces must be square and the same size", cubeFaceNames[face][0], s.X, s.Y)
+		}
+	}
+
+	var texture uint32
+	gl.GenTextures(1, &texture)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, texture)
+	applyTextureOptions(gl.TEXTURE_CUBE_MAP, opts)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_R, textureWraps[opts.WrapS])
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	for face, img := range faces {
+		p := NewTexturePixels(img, opts)
+		gl.TexImage2D(gl.TEXTURE_CUBE_MAP_POSITIVE_X+uint32(face), 0, p.InternalFormat, int32(p.Width), int32(p.Height), 0, p.Format, p.Type, gl.Ptr(p.Pix))
+	}
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 4)
+	if opts.Mipmaps {
+		gl.GenerateMipmap(gl.TEXTURE_CUBE_MAP)
+	}
+	return texture, nil
+}
+
+// LoadCubemap loads a cubemap from a directory of faces, a KTX or DDS
+// cubemap, a 4:3 cross image or a 2:1 equirectangular panorama. Sampling
+// options come from the sidecar as for other textures, but always clamp.
+func LoadCubemap(fsys fs.FS, file string) (uint32, error) {
+	opts, err := LoadTextureOptions(fsys, file)
+	if err != nil {
+		return 0, err
+	}
+	opts.WrapS, opts.WrapT = WrapClamp, WrapClamp
+
+	if IsCompressedTextureFile(file) {
+		t, err := LoadCompressedTexture(fsys, file)
+		if err != nil {
+			return 0, err
+		}
+		if t.Faces != 6 {
+			return 0, fmt.Errorf("texture %q is not a cubemap", file)
+		}
+		return UploadCompressedTexture(t, opts)
+	}
+
+	var faces [6]image.Image
+	if info, err := fs.Stat(fsys, path.Clean(file)); err == nil && info.IsDir() {
+		faces, err = LoadCubemapFaces(fsys, file)
+		if err != nil {
+			return 0, err
+		}
+		return UploadCubemap(faces, opts)
+	}
+
+	img, err := DecodeImage(fsys, file)
+	if err != nil {
+		return 0, err
+	}
+	b := img.Bounds()
+	switch {
+	case b.Dx()*3 == b.Dy()*4:
+		faces, err = CrossToCubeFaces(img)
+	case b.Dx() == b.Dy()*2:
+		faces = EquirectToCubeFaces(img, b.Dy()/2)
+	default:
+		err = errors.New("not a 4:3 cross or a 2:1 panorama")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cubemap %q: %v", file, err)
+	}
+	return UploadCubemap(faces, opts)
+}
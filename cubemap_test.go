@@ -0,0 +1,152 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestCrossToCubeFaces(t *testing.T) {
+	// every cell of an 8x6 cross gets its own colour
+	cross := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	cell := func(cx, cy int) color.RGBA { return color.RGBA{uint8(cx * 60), uint8(cy * 100), 0, 255} }
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 8; x++ {
+			cross.SetRGBA(x, y, cell(x/2, y/2))
+		}
+	}
+	faces, err := CrossToCubeFaces(cross)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [6]color.RGBA{
+		CubePositiveX: cell(2, 1),
+		CubeNegativeX: cell(0, 1),
+		CubePositiveY: cell(1, 0),
+		CubeNegativeY: cell(1, 2),
+		CubePositiveZ: cell(1, 1),
+		CubeNegativeZ: cell(3, 1),
+	}
+	for face, img := range faces {
+		b := img.Bounds()
+		if b.Dx() != 2 || b.Dy() != 2 {
+			t.Errorf("face %s is %v, want 2x2", cubeFaceNames[face][0], b)
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if got := img.At(x, y); got != want[face] {
+					t.Errorf("face %s texel %d,%d is %v, want %v", cubeFaceNames[face][0], x, y, got, want[face])
+				}
+			}
+		}
+	}
+
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 8, 8), image.Rect(0, 0, 9, 6), image.Rect(0, 0, 3, 2)} {
+		if _, err := CrossToCubeFaces(image.NewRGBA(r)); err == nil {
+			t.Errorf("cut a %dx%d image into faces", r.Dx(), r.Dy())
+		}
+	}
+}
+
+// cubeFaceCoords is the GL spec's table for picking a face and its s, t
+// from a direction, the inverse of CubeFaceDirection
+func cubeFaceCoords(d [3]float64) (face int, s, t float64) {
+	x, y, z := d[0], d[1], d[2]
+	var sc, tc, ma float64
+	switch ax, ay, az := math.Abs(x), math.Abs(y), math.Abs(z); {
+	case ax >= ay && ax >= az && x > 0:
+		face, sc, tc, ma = CubePositiveX, -z, -y, ax
+	case ax >= ay && ax >= az:
+		face, sc, tc, ma = CubeNegativeX, z, -y, ax
+	case ay >= az && y > 0:
+		face, sc, tc, ma = CubePositiveY, x, z, ay
+	case ay >= az:
+		face, sc, tc, ma = CubeNegativeY, x, -z, ay
+	case z > 0:
+		face, sc, tc, ma = CubePositiveZ, x, -y, az
+	default:
+		face, sc, tc, ma = CubeNegativeZ, -x, -y, az
+	}
+	return face, (sc/ma + 1) / 2, (tc/ma + 1) / 2
+}
+
+func TestCubeFaceDirection(t *testing.T) {
+	centres := [6][3]float64{
+		CubePositiveX: {1, 0, 0},
+		CubeNegativeX: {-1, 0, 0},
+		CubePositiveY: {0, 1, 0},
+		CubeNegativeY: {0, -1, 0},
+		CubePositiveZ: {0, 0, 1},
+		CubeNegativeZ: {0, 0, -1},
+	}
+	const size = 3
+	for face, want := range centres {
+		if d := CubeFaceDirection(face, 1, 1, size); d != want {
+			t.Errorf("face %s centre points at %v, want %v", cubeFaceNames[face][0], d, want)
+		}
+		// each corner texel must sample back to itself through the spec
+		for _, c := range []image.Point{{0, 0}, {size - 1, 0}, {0, size - 1}, {size - 1, size - 1}} {
+			got, s, tc := cubeFaceCoords(CubeFaceDirection(face, c.X, c.Y, size))
+			ws, wt := (float64(c.X)+0.5)/size, (float64(c.Y)+0.5)/size
+			if got != face || math.Abs(s-ws) > 1e-9 || math.Abs(tc-wt) > 1e-9 {
+				t.Errorf("face %s texel %v samples face %s at %.3f, %.3f, want %.3f, %.3f",
+					cubeFaceNames[face][0], c, cubeFaceNames[got][0], s, tc, ws, wt)
+			}
+		}
+	}
+}
+
+func TestEquirectToCubeFaces(t *testing.T) {
+	// red is twice u and green is v at each texel centre, blue is past 1
+	const w, h, size = 64, 32, 5
+	pano := NewHDRImage(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*pano.Stride + x*3
+			pano.Pix[i] = 2 * (float32(x) + 0.5) / w
+			pano.Pix[i+1] = (float32(y) + 0.5) / h
+			pano.Pix[i+2] = 3
+		}
+	}
+	faces := EquirectToCubeFaces(pano, size)
+	centre := func(face int) []float32 {
+		img, ok := faces[face].(*HDRImage)
+		if !ok {
+			t.Fatalf("face %s is a %T, want *HDRImage", cubeFaceNames[face][0], faces[face])
+		}
+		i := size/2*img.Stride + size/2*3
+		return img.Pix[i : i+3]
+	}
+	near := func(a, b float32) bool { return math.Abs(float64(a-b)) < 1e-3 }
+
+	if c := centre(CubeNegativeZ); !near(c[0], 1) || !near(c[1], 0.5) {
+		t.Errorf("-Z centre sampled u, v %v, %v, want 0.5, 0.5", c[0]/2, c[1])
+	}
+	if c := centre(CubePositiveX); !near(c[0], 1.5) {
+		t.Errorf("+X centre sampled u %v, want 0.75 with red past 1", c[0]/2)
+	}
+	if c := centre(CubePositiveY); !near(c[1], 0.5/h) {
+		t.Errorf("+Y centre sampled v %v, want the top row", c[1])
+	}
+	for face := range faces {
+		if c := centre(face); !near(c[2], 3) {
+			t.Errorf("face %s blue is %v, want 3 kept from the HDR input", cubeFaceNames[face][0], c[2])
+		}
+	}
+
+	// 8 bit input gives 8 bit faces sampled the same way
+	ldr := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ldr.SetRGBA(x, y, color.RGBA{uint8(x * 4), 0, 0, 255})
+		}
+	}
+	img, ok := EquirectToCubeFaces(ldr, size)[CubeNegativeZ].(*image.RGBA)
+	if !ok {
+		t.Fatal("8 bit panorama didn't give *image.RGBA faces")
+	}
+	if got := img.RGBAAt(size/2, size/2).R; got < 125 || got > 127 {
+		t.Errorf("-Z centre of the 8 bit panorama is %d, want about 126 at u 0.5", got)
+	}
+}
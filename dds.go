@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+const (
+	ddsMipmapCount = 0x20000 // header flag: MipMapCount is valid
+	ddsFourCC      = 0x4     // pixel format flag: FourCC is valid
+	ddsCubemap     = 0x200   // caps2
+	ddsDX10Cube    = 0x4     // DX10 misc flag
+)
+
+type ddsHeader struct {
+	Size              uint32
+	Flags             uint32
+	Height            uint32
+	Width             uint32
+	PitchOrLinearSize uint32
+	Depth             uint32
+	MipMapCount       uint32
+	Reserved1         [11]uint32
+	PixelFormat       struct {
+		Size, Flags, FourCC, RGBBitCount, RMask, GMask, BMask, AMask uint32
+	}
+	Caps, Caps2, Caps3, Caps4, Reserved2 uint32
+}
+
+type ddsHeaderDX10 struct {
+	DXGIFormat        uint32
+	ResourceDimension uint32
+	MiscFlag          uint32
+	ArraySize         uint32
+	MiscFlags2        uint32
+}
+
+var ddsFourCCFormats = map[string]uint32{
+	"DXT1": gl.COMPRESSED_RGBA_S3TC_DXT1_EXT,
+	"DXT2": gl.COMPRESSED_RGBA_S3TC_DXT3_EXT,
+	"DXT3": gl.COMPRESSED_RGBA_S3TC_DXT3_EXT,
+	"DXT4": gl.COMPRESSED_RGBA_S3TC_DXT5_EXT,
+	"DXT5": gl.COMPRESSED_RGBA_S3TC_DXT5_EXT,
+	"ATI1": gl.COMPRESSED_RED_RGTC1,
+	"BC4U": gl.COMPRESSED_RED_RGTC1,
+	"BC4S": gl.COMPRESSED_SIGNED_RED_RGTC1,
+	"ATI2": gl.COMPRESSED_RG_RGTC2,
+	"BC5U": gl.COMPRESSED_RG_RGTC2,
+	"BC5S": gl.COMPRESSED_SIGNED_RG_RGTC2,
+}
+
+var ddsDXGIFormats = map[uint32]uint32{
+	71: gl.COMPRESSED_RGBA_S3TC_DXT1_EXT,
+	72: compressedSRGBAlphaDXT1,
+	74: gl.COMPRESSED_RGBA_S3TC_DXT3_EXT,
+	75: compressedSRGBAlphaDXT3,
+	77: gl.COMPRESSED_RGBA_S3TC_DXT5_EXT,
+	78: compressedSRGBAlphaDXT5,
+	80: gl.COMPRESSED_RED_RGTC1,
+	81: gl.COMPRESSED_SIGNED_RED_RGTC1,
+	83: gl.COMPRESSED_RG_RGTC2,
+	84: gl.COMPRESSED_SIGNED_RG_RGTC2,
+	95: gl.COMPRESSED_RGB_BPTC_UNSIGNED_FLOAT_ARB,
+	96: gl.COMPRESSED_RGB_BPTC_SIGNED_FLOAT_ARB,
+	98: gl.COMPRESSED_RGBA_BPTC_UNORM_ARB,
+	99: gl.COMPRESSED_SRGB_ALPHA_BPTC_UNORM_ARB,
+}
+
+// DecodeDDS reads a DirectDraw Surface holding BC1-BC7 data, with the DX10
+// header for arrays and the newer formats. DDS stores every mip of a face
+// before the next face, they are reordered into CompressedTexture.Images.
+func DecodeDDS(data []byte) (*CompressedTexture, error) {
+	if !bytes.HasPrefix(data, []byte("DDS ")) {
+		return nil, errors.New("dds: not a DDS file")
+	}
+	r := bytes.NewReader(data[4:])
+	var h ddsHeader
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("dds: %v", err)
+	}
+	if h.Size != 124 {
+		return nil, fmt.Errorf("dds: bad header size %d", h.Size)
+	}
+	if h.PixelFormat.Flags&ddsFourCC == 0 {
+		return nil, errors.New("dds: only block compressed data is supported")
+	}
+
+	t := &CompressedTexture{Width: int(h.Width), Height: int(h.Height), Layers: 1, Faces: 1}
+	if h.Caps2&ddsCubemap != 0 {
+		t.Faces = 6
+	}
+
+	fourCC := string([]byte{byte(h.PixelFormat.FourCC), byte(h.PixelFormat.FourCC >> 8), byte(h.PixelFormat.FourCC >> 16), byte(h.PixelFormat.FourCC >> 24)})
+	if fourCC == "DX10" {
+		var dx10 ddsHeaderDX10
+		if err := binary.Read(r, binary.LittleEndian, &dx10); err != nil {
+			return nil, fmt.Errorf("dds: %v", err)
+		}
+		format, ok := ddsDXGIFormats[dx10.DXGIFormat]
+		if !ok {
+			return nil, fmt.Errorf("dds: unsupported DXGI format %d", dx10.DXGIFormat)
+		}
+		t.Format = format
+		t.Layers = int(maxUint32(dx10.ArraySize, 1))
+		if dx10.MiscFlag&ddsDX10Cube != 0 {
+			t.Faces = 6
+		}
+	} else {
+		format, ok := ddsFourCCFormats[fourCC]
+		if !ok {
+			return nil, fmt.Errorf("dds: unsupported FourCC %q", fourCC)
+		}
+		t.Format = format
+	}
+	if h.Depth > 1 && t.Layers == 1 && t.Faces == 1 {
+		return nil, errors.New("dds: volume textures are not supported")
+	}
+
+	levels := uint32(1)
+	if h.Flags&ddsMipmapCount != 0 && h.MipMapCount > 1 {
+		levels = h.MipMapCount
+	}
+	if err := checkCompressedHeader(h.Width, h.Height, uint32(t.Layers), uint32(t.Faces), levels); err != nil {
+		return nil, fmt.Errorf("dds: %v", err)
+	}
+	t.Images = make([][][]byte, levels)
+
+	offset := len(data) - r.Len()
+	for image := 0; image < t.Layers*t.Faces; image++ {
+		for level := 0; level < int(levels); level++ {
+			w, ht := t.LevelSize(level)
+			size, err := CompressedImageSize(t.Format, w, ht)
+			if err != nil {
+				return nil, fmt.Errorf("dds: %v", err)
+			}
+			if offset+size > len(data) {
+				return nil, fmt.Errorf("dds: truncated at level %d of image %d", level, image)
+			}
+			t.Images[level] = append(t.Images[level], data[offset:offset+size])
+			offset += size
+		}
+	}
+	return t, nil
+}
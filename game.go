@@ -1,6 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"io/fs"
+	"runtime"
+	"time"
+
 	"github.com/go-gl/gl/v3.3-core/gl"
 	"github.com/go-gl/glfw/v3.1/glfw"
 	"github.com/go-gl/mathgl/mgl32"
@@ -9,55 +14,138 @@ import (
 // calculate the memory size of floats used to calculate total memory size of float arrays
 const floatSize = 4
 
+// DefaultCubeMaterial is the material the cubes are drawn with
+const DefaultCubeMaterial = "materials/cube.json"
+
 type Game struct {
 	Width  int
 	Height int
 	VAO    uint32
 	Camera *Camera
 
-	ShaderPrograms map[string]uint32
-	Textures       map[string]uint32
+	// Where shaders and textures are loaded from, DefaultAssets when not
+	// set, and the cache of what has been loaded. handles are released
+	// again in Shutdown.
+	Assets       fs.FS
+	AssetManager *AssetManager
+	handles      []AssetHandle
+
+	// Optional scripted camera path, toggled with P
+	PathPlayer *CameraPathPlayer
+
+	// Camera bookmarks, 0-9 restores and Ctrl+0-9 saves
+	Views *CameraViews
+
+	// Click to select, Selected is the index into positions or -1. Tab
+	// frees the cursor to click anywhere instead of through the centre.
+	PickMesh     *PickMesh
+	Selected     int
+	CursorLocked bool
+
+	// Offscreen ID pass used instead of ray tests when UseGPUPicking is
+	// set, G toggles it. With the cursor free, dragging selects every cube
+	// in the Marquee rectangle, kept in framebuffer pixels while dragging.
+	PickPass      *PickingPass
+	UseGPUPicking bool
+	Marquee       *PickRect
+
+	// Drawn after the cubes, loaded from SkyboxPath in the assets
+	Skybox     *Skybox
+	SkyboxPath string
+
+	// State filters redundant GL state changes, its LastFrame counts show
+	// how many were saved
+	State *StateCache
+
+	// ShaderPrograms is the asset manager's program map once Setup has
+	// run, so the watcher reloads every program it loads as well as the
+	// ones Setup adds by name
+	ShaderPrograms map[string]*ShaderProgram
+	ShaderWatcher  *ShaderWatcher
+	PerFrame       *UniformBuffer
 	InputKeys      map[glfw.Key]bool
 
-	MixValue float32
-	Cubes    []mgl32.Vec3
+	// Cube is drawn at every position with the material from
+	// CubeMaterialPath
+	Cube             *Renderable
+	CubeMaterialPath string
+	Cubes            []mgl32.Vec3
 
 	deltaTime float32
 	lastFrame float32
+	reported  map[string]bool
 }
 
 func NewGame(width, height int, camera *Camera) *Game {
 	return &Game{
-		Width:          width,
-		Height:         height,
-		Camera:         camera,
-		ShaderPrograms: map[string]uint32{},
-		Textures:       map[string]uint32{},
-		InputKeys:      map[glfw.Key]bool{},
+		Width:            width,
+		Height:           height,
+		Camera:           camera,
+		State:            NewStateCache(),
+		ShaderPrograms:   map[string]*ShaderProgram{},
+		InputKeys:        map[glfw.Key]bool{},
+		Selected:         -1,
+		SkyboxPath:       DefaultSkybox,
+		CubeMaterialPath: DefaultCubeMaterial,
 	}
 }
 
 func (game *Game) Setup() {
+	if game.Assets == nil {
+		game.Assets = DefaultAssets()
+	}
+	game.AssetManager = NewAssetManager(game.Assets)
+	game.AssetManager.Loader = NewTextureLoader(game.Assets, runtime.NumCPU())
+	game.ShaderPrograms = game.AssetManager.Programs
+	game.ShaderWatcher = NewShaderWatcher(game.ShaderPrograms, 500*time.Millisecond)
+
+	// The material picks the shaders and textures the cubes are drawn with
+	material, err := game.AssetManager.LoadMaterial(game.CubeMaterialPath)
+	if err != nil {
+		panic(err)
+	}
 
-	// Configure the vertex and fragment shaders
-	prog, err := NewShaderProgram("./shaders/basic_tex.vert", "./shaders/basic_tex.frag")
+	perFrame, err := NewUniformBuffer(PerFrameBinding, PerFrame{})
 	if err != nil {
 		panic(err)
 	}
-	game.ShaderPrograms["BasicTextureShaders"] = prog
+	game.PerFrame = perFrame
 
-	// Load the textures
-	tex, err := LoadTextures("./textures")
+	cube, err := game.AssetManager.LoadMesh("cube", newCubeMesh)
 	if err != nil {
 		panic(err)
 	}
-	game.Textures = tex
+	game.handles = append(game.handles, cube)
+	game.VAO = cube.Mesh().VAO
+	game.Cube = &Renderable{Material: material, VAO: game.VAO, Count: 36}
 
+	cubemap, err := LoadSkyboxCubemap(game.Assets, game.SkyboxPath)
+	if err != nil {
+		panic(err)
+	}
+	skybox, err := NewSkybox(game.Assets, cubemap, game.VAO)
+	if err != nil {
+		panic(err)
+	}
+	game.Skybox = skybox
+	game.ShaderPrograms["Skybox"] = skybox.Program
+
+	game.PickMesh = NewPickMesh(verticesCube, 5)
+
+	pickPass, err := NewPickingPass(game.Assets, game.Width, game.Height)
+	if err != nil {
+		panic(err)
+	}
+	pickPass.State = game.State
+	game.PickPass = pickPass
+	game.ShaderPrograms["Picking"] = pickPass.Program
+}
+
+func newCubeMesh() (*Mesh, error) {
 	// Configure the vertex data
 	var VAO uint32
 	gl.GenVertexArrays(1, &VAO)
 	gl.BindVertexArray(VAO)
-	game.VAO = VAO
 
 	var VBO uint32
 	gl.GenBuffers(1, &VBO)
@@ -81,6 +169,38 @@ func (game *Game) Setup() {
 
 	gl.BindVertexArray(0)
 
+	return &Mesh{VAO: VAO, Buffers: []uint32{VBO}}, nil
+}
+
+// Shutdown releases everything Setup loaded and reports assets that are
+// still referenced. It must run while the GL context is still current.
+func (game *Game) Shutdown() {
+	for _, h := range game.handles {
+		h.Release()
+	}
+	game.handles = nil
+	if game.Cube != nil {
+		game.Cube.Material.Release()
+	}
+
+	if game.PickPass != nil {
+		game.PickPass.Delete()
+		delete(game.ShaderPrograms, "Picking")
+	}
+	if game.PerFrame != nil {
+		game.PerFrame.Delete()
+	}
+	if game.Skybox != nil {
+		game.Skybox.Delete()
+		delete(game.ShaderPrograms, "Skybox")
+	}
+
+	for _, leak := range game.AssetManager.Shutdown() {
+		fmt.Println("leaked asset:", leak)
+	}
+	if game.AssetManager.Loader != nil {
+		game.AssetManager.Loader.Close()
+	}
 }
 
 func (game *Game) Render() {
@@ -88,43 +208,131 @@ func (game *Game) Render() {
 	game.UpdateTimes(float32(glfw.GetTime()))
 	game.UpdateCameraPosition()
 
-	gl.UseProgram(game.ShaderPrograms["BasicTextureShaders"])
+	for _, err := range game.ShaderWatcher.Poll() {
+		fmt.Println(err)
+	}
+	for _, err := range game.AssetManager.Loader.Upload() {
+		fmt.Println(err)
+	}
+
+	err := game.PerFrame.Update(PerFrame{
+		View:           game.Camera.CurrentView(),
+		Projection:     game.Camera.Projection(game.aspect()),
+		CameraPosition: game.Camera.Position,
+		Time:           float32(glfw.GetTime()),
+	})
+	if err != nil {
+		game.report(err)
+	}
+
+	// Everything above may have bound objects without the cache
+	game.State.BeginFrame()
 
-	gl.ActiveTexture(gl.TEXTURE0)
-	gl.BindTexture(gl.TEXTURE_2D, game.Textures["container.jpg"])
-	gl.Uniform1i(gl.GetUniformLocation(game.ShaderPrograms["BasicTextureShaders"], gl.Str("texture1\x00")), 0)
+	if err := game.Cube.Draw(game.State, game.CubeModels(glfw.GetTime())); err != nil {
+		game.report(err)
+	}
 
-	gl.ActiveTexture(gl.TEXTURE1)
-	gl.BindTexture(gl.TEXTURE_2D, game.Textures["awesomeface.png"])
-	gl.Uniform1i(gl.GetUniformLocation(game.ShaderPrograms["BasicTextureShaders"], gl.Str("texture2\x00")), 1)
+	if err := game.Skybox.Draw(game.State, game.Camera.RotationView()); err != nil {
+		game.report(err)
+	}
+	game.DrawMarquee()
 
-	gl.BindVertexArray(game.VAO)
+	// Leave depth writes on so the next frame's clear reaches the depth buffer
+	game.State.Apply(DefaultRenderState())
+}
 
-	for _, pos := range positions {
+// CubeModels returns the model matrix of every cube at the given time
+func (game *Game) CubeModels(time float64) []mgl32.Mat4 {
+	models := make([]mgl32.Mat4, len(positions))
+	for i, pos := range positions {
 		model0 := mgl32.Translate3D(pos.X(), pos.Y(), pos.Z())
-		model1 := mgl32.HomogRotate3DX(mgl32.DegToRad(float32(glfw.GetTime() * 50.0)))
-		model2 := mgl32.HomogRotate3DY(mgl32.DegToRad(float32(glfw.GetTime() * 50.0)))
-		model := model0.Mul4(model1).Mul4(model2)
-		//view := mgl32.Translate3D(0, 0, -4.0)
-		//radius := 10.0
-		//camX := math.Sin(glfw.GetTime()) * radius
-		//camZ := math.Cos(glfw.GetTime()) * radius
-		//view = glm::lookAt(glm::vec3(camX, 0.0, camZ), glm::vec3(0.0, 0.0, 0.0), glm::vec3(0.0, 1.0, 0.0));
-		//view := mgl32.LookAtV(mgl32.Vec3{float32(camX), 0.0, float32(camZ)}, mgl32.Vec3{0.0, 0.0, 0.0}, mgl32.Vec3{0.0, 1.0, 0.0})
-		view := game.Camera.CurrentView()
-
-		projection := mgl32.Perspective(mgl32.DegToRad(float32(game.Camera.FOV)), 800/600, 0.1, 100.0)
-
-		gl.UniformMatrix4fv(gl.GetUniformLocation(game.ShaderPrograms["BasicTextureShaders"], gl.Str("model\x00")), 1, false, &model[0])
-		gl.UniformMatrix4fv(gl.GetUniformLocation(game.ShaderPrograms["BasicTextureShaders"], gl.Str("view\x00")), 1, false, &view[0])
-		gl.UniformMatrix4fv(gl.GetUniformLocation(game.ShaderPrograms["BasicTextureShaders"], gl.Str("projection\x00")), 1, false, &projection[0])
+		model1 := mgl32.HomogRotate3DX(mgl32.DegToRad(float32(time * 50.0)))
+		model2 := mgl32.HomogRotate3DY(mgl32.DegToRad(float32(time * 50.0)))
+		models[i] = model0.Mul4(model1).Mul4(model2)
+	}
+	return models
+}
+
+func (game *Game) aspect() float32 {
+	return float32(game.Width) / float32(game.Height)
+}
+
+// PickAt selects the cube under a cursor position given in window coordinates
+func (game *Game) PickAt(xpos, ypos float64, width, height int) (PickHit, bool) {
+	ray := ScreenRay(xpos, ypos, width, height, game.Camera.CurrentView(), game.Camera.Projection(game.aspect()))
+	hit, ok := game.PickMesh.Pick(ray, game.CubeModels(glfw.GetTime()))
+	game.Selected = hit.Instance
+	return hit, ok
+}
+
+// RenderPickIDs draws every cube into the picking buffer with its ID
+func (game *Game) RenderPickIDs() {
+	prog := game.PickPass.Program
 
+	game.PickPass.Begin()
+	game.State.UseProgram(prog.ID)
+	game.State.BindVertexArray(game.VAO)
+	for i, model := range game.CubeModels(glfw.GetTime()) {
+		if err := prog.SetMat4("model", model); err != nil {
+			game.report(err)
+		}
+		if err := game.PickPass.SetID(prog, i); err != nil {
+			game.report(err)
+		}
 		gl.DrawArrays(gl.TRIANGLES, 0, 36)
 	}
+	game.PickPass.End()
+	gl.Viewport(0, 0, int32(game.Width), int32(game.Height))
+}
 
-	//gl.BindTexture(gl.TEXTURE_2D, game.Textures["container.jpg"])
-	//gl.DrawElements(gl.TRIANGLES, 6, gl.UNSIGNED_INT, gl.Ptr(nil))
-	gl.BindVertexArray(0)
+// GPUPickRect selects every cube visible in a rectangle of framebuffer
+// pixels, origin top left
+func (game *Game) GPUPickRect(r PickRect) []int {
+	game.RenderPickIDs()
+	selected := PickRectangle(game.PickPass, r)
+	game.Selected = -1
+	if len(selected) > 0 {
+		game.Selected = selected[0]
+	}
+	return selected
+}
+
+// framebufferPoint scales a cursor position to framebuffer pixels, they
+// differ from window coordinates on high DPI displays
+func (game *Game) framebufferPoint(w *glfw.Window, xpos, ypos float64) (int, int) {
+	width, height := w.GetSize()
+	return int(xpos * float64(game.Width) / float64(width)), int(ypos * float64(game.Height) / float64(height))
+}
+
+// DrawMarquee outlines the rectangle being dragged out. The edges are
+// scissored clears so it needs no shader or vertices.
+func (game *Game) DrawMarquee() {
+	if game.Marquee == nil {
+		return
+	}
+	x, y, w, h := game.Marquee.Framebuffer(game.Width, game.Height)
+	if w <= 0 || h <= 0 {
+		return
+	}
+	gl.ClearColor(1, 1, 1, 1)
+	game.State.SetEnabled(gl.SCISSOR_TEST, true)
+	for _, edge := range [][4]int{{x, y, w, 1}, {x, y + h - 1, w, 1}, {x, y, 1, h}, {x + w - 1, y, 1, h}} {
+		game.State.Scissor(int32(edge[0]), int32(edge[1]), int32(edge[2]), int32(edge[3]))
+		gl.Clear(gl.COLOR_BUFFER_BIT)
+	}
+	game.State.SetEnabled(gl.SCISSOR_TEST, false)
+}
+
+// report prints a per-frame error once instead of on every frame, so a
+// shader edit that drops a uniform doesn't bring the game down.
+func (game *Game) report(err error) {
+	if game.reported == nil {
+		game.reported = map[string]bool{}
+	}
+	if !game.reported[err.Error()] {
+		game.reported[err.Error()] = true
+		fmt.Println(err)
+	}
 }
 
 func (game *Game) UpdateTimes(time float32) {
@@ -132,6 +340,10 @@ func (game *Game) UpdateTimes(time float32) {
 	game.lastFrame = time
 }
 func (game *Game) UpdateCameraPosition() {
+	if game.PathPlayer != nil && game.PathPlayer.Playing() {
+		game.PathPlayer.Update(glfw.GetTime(), game.Camera)
+		return
+	}
 	game.Camera.SetDelta(float32(game.deltaTime))
 	if game.InputKeys[glfw.KeyW] {
 		game.Camera.MoveForward()
@@ -145,14 +357,62 @@ func (game *Game) UpdateCameraPosition() {
 	if game.InputKeys[glfw.KeyD] {
 		game.Camera.MoveRight()
 	}
+	game.Camera.SetSprint(game.InputKeys[glfw.KeyLeftShift])
+	game.Camera.Update()
 }
 
 func (game *Game) CursorEventHandler() func(w *glfw.Window, xpos float64, ypos float64) {
 	return func(w *glfw.Window, xpos float64, ypos float64) {
+		if !game.CursorLocked {
+			if game.Marquee != nil {
+				game.Marquee.X1, game.Marquee.Y1 = game.framebufferPoint(w, xpos, ypos)
+			}
+			return
+		}
 		game.Camera.HandleCursorEvent(xpos, ypos)
 	}
 }
 
+func (game *Game) MouseButtonEventHandler() func(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+	return func(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+		if button != glfw.MouseButtonLeft || game.PickMesh == nil {
+			return
+		}
+		width, height := w.GetSize()
+		xpos, ypos := w.GetCursorPos()
+		// With a captured cursor we pick through the centre of the screen
+		if game.CursorLocked {
+			xpos, ypos = float64(width)/2, float64(height)/2
+		}
+		if game.UseGPUPicking && game.PickPass != nil {
+			x, y := game.framebufferPoint(w, xpos, ypos)
+			r := PickRect{x, y, x, y}
+			switch {
+			case action == glfw.Press && !game.CursorLocked:
+				// picked on release, a click without dragging is a 1px marquee
+				game.Marquee = &r
+			case action == glfw.Release && game.Marquee != nil:
+				r = PickRect{game.Marquee.X0, game.Marquee.Y0, r.X0, r.Y0}
+				game.Marquee = nil
+				if selected := game.GPUPickRect(r); len(selected) > 0 {
+					fmt.Printf("selected cubes %v\n", selected)
+				}
+			case action == glfw.Press:
+				if selected := game.GPUPickRect(r); len(selected) > 0 {
+					fmt.Printf("selected cube %d\n", selected[0])
+				}
+			}
+			return
+		}
+		if action != glfw.Press {
+			return
+		}
+		if hit, ok := game.PickAt(xpos, ypos, width, height); ok {
+			fmt.Printf("selected cube %d (triangle %d)\n", hit.Instance, hit.Triangle)
+		}
+	}
+}
+
 func (game *Game) ScrollEventHandler() func(w *glfw.Window, xoff float64, yoff float64) {
 	return func(w *glfw.Window, xoff float64, yoff float64) {
 		game.Camera.HandleScrollEvent(xoff, yoff)
@@ -167,6 +427,57 @@ func (game *Game) KeyEventHandler() func(*glfw.Window, glfw.Key, int, glfw.Actio
 			w.SetShouldClose(true)
 		}
 
+		if key == glfw.KeyG && action == glfw.Press {
+			game.UseGPUPicking = !game.UseGPUPicking
+			game.Marquee = nil
+			fmt.Println("GPU picking:", game.UseGPUPicking)
+		}
+
+		// Tab frees the cursor for clicking and dragging out a marquee
+		if key == glfw.KeyTab && action == glfw.Press {
+			game.CursorLocked = !game.CursorLocked
+			game.Marquee = nil
+			if game.CursorLocked {
+				w.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
+				game.Camera.ResetMouse()
+			} else {
+				w.SetInputMode(glfw.CursorMode, glfw.CursorNormal)
+			}
+		}
+
+		if key == glfw.KeyP && action == glfw.Press && game.PathPlayer != nil {
+			if game.PathPlayer.Playing() {
+				game.PathPlayer.Pause(game.Camera)
+			} else {
+				game.PathPlayer.Play(glfw.GetTime())
+			}
+		}
+
+		// [ and ] scrub the path a second at a time, also while paused
+		if (key == glfw.KeyLeftBracket || key == glfw.KeyRightBracket) && action != glfw.Release && game.PathPlayer != nil {
+			step := float32(1)
+			if key == glfw.KeyLeftBracket {
+				step = -step
+			}
+			game.PathPlayer.Seek(game.PathPlayer.Time()+step, game.Camera)
+		}
+
+		// Up and Down fade between the two cube textures, if the material
+		// has a mixValue to fade with
+		if (key == glfw.KeyUp || key == glfw.KeyDown) && action != glfw.Release && game.Cube != nil {
+			step := float32(0.1)
+			if key == glfw.KeyDown {
+				step = -step
+			}
+			if mix, ok := game.Cube.Material.Params["mixValue"].(float32); ok {
+				game.Cube.Material.SetParam("mixValue", mgl32.Clamp(mix+step, 0, 1))
+			}
+		}
+
+		if key >= glfw.Key0 && key <= glfw.Key9 && action == glfw.Press && game.Views != nil {
+			game.handleBookmarkKey(fmt.Sprint(int(key-glfw.Key0)), mods&glfw.ModControl != 0)
+		}
+
 		if action == glfw.Press {
 			game.InputKeys[key] = true
 		} else if action == glfw.Release {
@@ -174,3 +485,16 @@ func (game *Game) KeyEventHandler() func(*glfw.Window, glfw.Key, int, glfw.Actio
 		}
 	}
 }
+
+func (game *Game) handleBookmarkKey(name string, save bool) {
+	if save {
+		game.Views.SetBookmark(name, game.Camera)
+		if err := game.Views.Save(); err != nil {
+			fmt.Println("failed to save camera bookmark:", err)
+		}
+		return
+	}
+	if err := game.Views.ApplyBookmark(name, game.Camera); err != nil {
+		fmt.Println(err)
+	}
+}
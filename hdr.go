@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+)
+
+func init() {
+	image.RegisterFormat("hdr", "#?RADIANCE", DecodeHDR, DecodeHDRConfig)
+	image.RegisterFormat("hdr", "#?RGBE", DecodeHDR, DecodeHDRConfig)
+}
+
+// HDRImage holds linear RGB floats, three per pixel. It is uploaded as a
+// float texture, At clamps to [0, 1] for code that only knows 8 bit images.
+type HDRImage struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+}
+
+func NewHDRImage(r image.Rectangle) *HDRImage {
+	return &HDRImage{Pix: make([]float32, r.Dx()*r.Dy()*3), Stride: r.Dx() * 3, Rect: r}
+}
+
+func (m *HDRImage) ColorModel() color.Model { return color.RGBA64Model }
+
+func (m *HDRImage) Bounds() image.Rectangle { return m.Rect }
+
+func (m *HDRImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return color.RGBA64{}
+	}
+	i := (y-m.Rect.Min.Y)*m.Stride + (x-m.Rect.Min.X)*3
+	return color.RGBA64{
+		R: uint16(clamp01(m.Pix[i]) * 0xffff),
+		G: uint16(clamp01(m.Pix[i+1]) * 0xffff),
+		B: uint16(clamp01(m.Pix[i+2]) * 0xffff),
+		A: 0xffff,
+	}
+}
+
+func (m *HDRImage) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(m.Rect)
+	if r.Empty() {
+		return &HDRImage{}
+	}
+	i := (r.Min.Y-m.Rect.Min.Y)*m.Stride + (r.Min.X-m.Rect.Min.X)*3
+	return &HDRImage{Pix: m.Pix[i:], Stride: m.Stride, Rect: r}
+}
+
+// readHDRHeader reads up to and including the resolution line
+func readHDRHeader(r *bufio.Reader) (width, height int, err error) {
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "#?") {
+		return 0, 0, errors.New("hdr: not a Radiance file")
+	}
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return 0, 0, fmt.Errorf("hdr: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return 0, 0, fmt.Errorf("hdr: unsupported %s", line)
+		}
+	}
+	line, err = r.ReadString('\n')
+	if err != nil {
+		return 0, 0, fmt.Errorf("hdr: %v", err)
+	}
+	if _, err := fmt.Sscanf(line, "-Y %d +X %d", &height, &width); err != nil {
+		return 0, 0, fmt.Errorf("hdr: unsupported orientation %q", strings.TrimSpace(line))
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, errors.New("hdr: empty image")
+	}
+	if err := checkImageSize("hdr", width, height); err != nil {
+		return 0, 0, err
+	}
+	return width, height, nil
+}
+
+func DecodeHDRConfig(r io.Reader) (image.Config, error) {
+	w, h, err := readHDRHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.RGBA64Model, Width: w, Height: h}, nil
+}
+
+// DecodeHDR reads a Radiance RGBE image into an *HDRImage
+func DecodeHDR(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	width, height, err := readHDRHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	img := NewHDRImage(image.Rect(0, 0, width, height))
+	scanline := make([]byte, width*4)
+	for y := 0; y < height; y++ {
+		if err := readHDRScanline(br, scanline); err != nil {
+			return nil, err
+		}
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			rgbe := scanline[x*4 : x*4+4]
+			if rgbe[3] == 0 {
+				continue
+			}
+			f := float32(math.Ldexp(1, int(rgbe[3])-(128+8)))
+			row[x*3] = float32(rgbe[0]) * f
+			row[x*3+1] = float32(rgbe[1]) * f
+			row[x*3+2] = float32(rgbe[2]) * f
+		}
+	}
+	return img, nil
+}
+
+// readHDRScanline reads one scanline of RGBE pixels, run length encoded
+// per component or flat
+func readHDRScanline(r *bufio.Reader, dst []byte) error {
+	width := len(dst) / 4
+	head, err := r.Peek(4)
+	if err != nil {
+		return fmt.Errorf("hdr: %v", err)
+	}
+	if width < 8 || width > 0x7fff || head[0] != 2 || head[1] != 2 || head[2]&0x80 != 0 {
+		if _, err := io.ReadFull(r, dst); err != nil {
+			return fmt.Errorf("hdr: %v", err)
+		}
+		return nil
+	}
+	if int(head[2])<<8|int(head[3]) != width {
+		return errors.New("hdr: scanline width mismatch")
+	}
+	if _, err := r.Discard(4); err != nil {
+		return fmt.Errorf("hdr: %v", err)
+	}
+
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			count, err := r.ReadByte()
+			if err != nil {
+				return fmt.Errorf("hdr: %v", err)
+			}
+			run := count > 128
+			n := int(count)
+			if run {
+				n -= 128
+			}
+			if n == 0 || x+n > width {
+				return errors.New("hdr: bad run length")
+			}
+			if run {
+				v, err := r.ReadByte()
+				if err != nil {
+					return fmt.Errorf("hdr: %v", err)
+				}
+				for ; n > 0; n-- {
+					dst[x*4+c] = v
+					x++
+				}
+				continue
+			}
+			for ; n > 0; n-- {
+				v, err := r.ReadByte()
+				if err != nil {
+					return fmt.Errorf("hdr: %v", err)
+				}
+				dst[x*4+c] = v
+				x++
+			}
+		}
+	}
+	return nil
+}
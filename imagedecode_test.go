@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+func tgaFile(w, h uint16) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, tgaHeader{ImageType: tgaTrueColor, Width: w, Height: h, Bits: 32})
+	buf.Write(make([]byte, 64))
+	return buf.Bytes()
+}
+
+func bmpFile(w, h int32) []byte {
+	b := make([]byte, 14+40)
+	copy(b, "BM")
+	binary.LittleEndian.PutUint32(b[10:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[14:], 40)
+	binary.LittleEndian.PutUint32(b[18:], uint32(w))
+	binary.LittleEndian.PutUint32(b[22:], uint32(h))
+	binary.LittleEndian.PutUint16(b[26:], 1)
+	binary.LittleEndian.PutUint16(b[28:], 32)
+	return b
+}
+
+func TestDecodeRejectsHugeImages(t *testing.T) {
+	tests := []struct {
+		name   string
+		decode func() error
+	}{
+		{"tga", func() error { _, err := DecodeTGA(bytes.NewReader(tgaFile(65535, 65535))); return err }},
+		{"bmp", func() error { _, err := DecodeBMP(bytes.NewReader(bmpFile(1<<30, 1<<30))); return err }},
+		{"bmp top down", func() error { _, err := DecodeBMP(bytes.NewReader(bmpFile(64, -(1 << 30)))); return err }},
+		{"bmp config", func() error { _, err := DecodeBMPConfig(bytes.NewReader(bmpFile(1<<30, 1))); return err }},
+		{"hdr", func() error {
+			_, err := DecodeHDR(strings.NewReader("#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y 100000 +X 100000\n"))
+			return err
+		}},
+		{"hdr config", func() error {
+			_, err := DecodeHDRConfig(strings.NewReader("#?RADIANCE\n\n-Y 1 +X 2000000000\n"))
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		err := tt.decode()
+		if err == nil || !strings.Contains(err.Error(), "limit") {
+			t.Errorf("%s: got %v, want the size limit error", tt.name, err)
+		}
+	}
+}
+
+func TestDecodeTruncatedHDR(t *testing.T) {
+	// an RLE scanline head for a 16 pixel row with nothing after it
+	data := "#?RADIANCE\n\n-Y 1 +X 16\n\x02\x02\x00\x10"
+	if _, err := DecodeHDR(strings.NewReader(data)); err == nil {
+		t.Fatal("truncated HDR decoded without an error")
+	}
+}
+
+// nrgbaPixels lists img's pixels row by row
+func nrgbaPixels(t *testing.T, img image.Image) []color.NRGBA {
+	n, ok := img.(*image.NRGBA)
+	if !ok {
+		t.Fatalf("decoded a %T, want *image.NRGBA", img)
+	}
+	var pix []color.NRGBA
+	for y := n.Rect.Min.Y; y < n.Rect.Max.Y; y++ {
+		for x := n.Rect.Min.X; x < n.Rect.Max.X; x++ {
+			pix = append(pix, n.NRGBAAt(x, y))
+		}
+	}
+	return pix
+}
+
+var (
+	red   = color.NRGBA{255, 0, 0, 255}
+	green = color.NRGBA{0, 255, 0, 255}
+	blue  = color.NRGBA{0, 0, 255, 255}
+	white = color.NRGBA{255, 255, 255, 255}
+)
+
+func tgaImage(h tgaHeader, data ...byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, h)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func TestDecodeTGA(t *testing.T) {
+	// BGR, the first row in the file is the bottom one unless the
+	// descriptor says otherwise
+	rows := []byte{0, 0, 255, 0, 255, 0, 255, 0, 0, 255, 255, 255}
+	rgb := tgaHeader{ImageType: tgaTrueColor, Width: 2, Height: 2, Bits: 24}
+	topDown, rightToLeft := rgb, rgb
+	topDown.Descriptor = 0x20
+	rightToLeft.Descriptor = 0x10
+
+	rle := tgaHeader{ImageType: tgaRLETrueColor, Width: 3, Height: 1, Bits: 32, Descriptor: 0x28}
+	mapped := tgaHeader{
+		ColorMapType: 1, ImageType: tgaColorMapped,
+		ColorMapFirst: 1, ColorMapLen: 2, ColorMapBits: 24,
+		Width: 3, Height: 1, Bits: 8, Descriptor: 0x20,
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []color.NRGBA
+	}{
+		{"raw", tgaImage(rgb, rows...), []color.NRGBA{blue, white, red, green}},
+		{"top to bottom", tgaImage(topDown, rows...), []color.NRGBA{red, green, blue, white}},
+		{"right to left", tgaImage(rightToLeft, rows...), []color.NRGBA{white, blue, green, red}},
+		// a run of two half transparent reds then one literal blue
+		{"rle", tgaImage(rle, 0x81, 0, 0, 255, 128, 0x00, 255, 0, 0, 255),
+			[]color.NRGBA{{255, 0, 0, 128}, {255, 0, 0, 128}, blue}},
+		// indexes start at ColorMapFirst
+		{"color mapped", tgaImage(mapped, 0, 0, 255, 0, 255, 0, 2, 1, 2),
+			[]color.NRGBA{green, red, green}},
+	}
+	for _, tt := range tests {
+		img, err := DecodeTGA(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := nrgbaPixels(t, img); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// bmpImage writes a BMP with an info header of headerSize bytes, masks go
+// in the header and palette between it and the pixels
+func bmpImage(headerSize int, w, h int32, bits uint16, compression, colorsUsed uint32, masks []uint32, palette, pixels []byte) []byte {
+	b := make([]byte, 14+headerSize)
+	copy(b, "BM")
+	binary.LittleEndian.PutUint32(b[10:], uint32(len(b)+len(palette)))
+	binary.LittleEndian.PutUint32(b[14:], uint32(headerSize))
+	binary.LittleEndian.PutUint32(b[18:], uint32(w))
+	binary.LittleEndian.PutUint32(b[22:], uint32(h))
+	binary.LittleEndian.PutUint16(b[26:], 1)
+	binary.LittleEndian.PutUint16(b[28:], bits)
+	binary.LittleEndian.PutUint32(b[30:], compression)
+	binary.LittleEndian.PutUint32(b[46:], colorsUsed)
+	for i, m := range masks {
+		binary.LittleEndian.PutUint32(b[54+i*4:], m)
+	}
+	b = append(b, palette...)
+	return append(b, pixels...)
+}
+
+func TestDecodeBMP(t *testing.T) {
+	// two BGR pixels a row, padded to four bytes
+	rows := []byte{
+		0, 0, 255, 0, 255, 0, 0, 0,
+		255, 0, 0, 255, 255, 255, 0, 0,
+	}
+	// BGRX entries
+	palette := []byte{0, 0, 255, 0, 0, 255, 0, 0}
+	// each mask picks the byte in RGBA order, not the usual BGRA
+	rgbaMasks := []uint32{0xff, 0xff00, 0xff0000, 0xff000000}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []color.NRGBA
+	}{
+		{"bottom up", bmpImage(40, 2, 2, 24, bmpRGB, 0, nil, nil, rows), []color.NRGBA{blue, white, red, green}},
+		{"top down", bmpImage(40, 2, -2, 24, bmpRGB, 0, nil, nil, rows), []color.NRGBA{red, green, blue, white}},
+		// only two palette entries, the pixels follow straight after them
+		{"palette", bmpImage(40, 3, 1, 8, bmpRGB, 2, nil, palette, []byte{1, 0, 1, 0}), []color.NRGBA{green, red, green}},
+		{"alpha bit fields", bmpImage(56, 1, 1, 32, bmpBitFields, 0, rgbaMasks, nil, []byte{10, 20, 30, 128}),
+			[]color.NRGBA{{10, 20, 30, 128}}},
+	}
+	for _, tt := range tests {
+		img, err := DecodeBMP(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := nrgbaPixels(t, img); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeHDR(t *testing.T) {
+	// an exponent of 129 scales a mantissa by 1/128
+	flat := "#?RADIANCE\n\n-Y 1 +X 2\n" + string([]byte{128, 64, 32, 129, 0, 0, 0, 0})
+
+	// 8 pixels wide, each component a run or literal packets
+	var rle []byte
+	rle = append(rle, 2, 2, 0, 8)
+	rle = append(rle, 0x88, 128)
+	rle = append(rle, 8, 0, 16, 32, 48, 64, 80, 96, 112)
+	rle = append(rle, 0x84, 0, 4, 32, 64, 96, 128)
+	rle = append(rle, 0x88, 129)
+
+	tests := []struct {
+		name string
+		data string
+		want []float32
+	}{
+		{"flat", flat, []float32{1, 0.5, 0.25, 0, 0, 0}},
+		{"rle", "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y 1 +X 8\n" + string(rle), []float32{
+			1, 0, 0, 1, 0.125, 0, 1, 0.25, 0, 1, 0.375, 0,
+			1, 0.5, 0.25, 1, 0.625, 0.5, 1, 0.75, 0.75, 1, 0.875, 1,
+		}},
+	}
+	for _, tt := range tests {
+		img, err := DecodeHDR(strings.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		hdr, ok := img.(*HDRImage)
+		if !ok {
+			t.Fatalf("%s: decoded a %T", tt.name, img)
+		}
+		if !reflect.DeepEqual(hdr.Pix, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, hdr.Pix, tt.want)
+		}
+	}
+}
+
+func TestNewTexturePixelsFormats(t *testing.T) {
+	rect := image.Rect(0, 0, 2, 1)
+	gray := image.NewGray16(rect)
+	gray.SetGray16(1, 0, color.Gray16{0x1234})
+	rgba64 := image.NewRGBA64(rect)
+	rgba64.SetRGBA64(0, 0, color.RGBA64{0x8000, 0x4000, 0, 0x8000})
+	// the same pixel unpremultiplied, it must upload as above
+	nrgba64 := image.NewNRGBA64(rect)
+	nrgba64.SetNRGBA64(0, 0, color.NRGBA64{0xffff, 0x8000, 0, 0x8000})
+	hdr := NewHDRImage(rect)
+	hdr.Pix[3] = 4
+
+	srgb := DefaultTextureOptions()
+	srgb.SRGB = true
+
+	tests := []struct {
+		name           string
+		img            image.Image
+		opts           TextureOptions
+		internalFormat int32
+		format, typ    uint32
+		pix            interface{}
+	}{
+		{"gray16", gray, DefaultTextureOptions(), gl.R16, gl.RED, gl.UNSIGNED_SHORT, []uint16{0, 0x1234}},
+		{"rgba64", rgba64, DefaultTextureOptions(), gl.RGBA16, gl.RGBA, gl.UNSIGNED_SHORT,
+			[]uint16{0x8000, 0x4000, 0, 0x8000, 0, 0, 0, 0}},
+		{"nrgba64", nrgba64, DefaultTextureOptions(), gl.RGBA16, gl.RGBA, gl.UNSIGNED_SHORT,
+			[]uint16{0x8000, 0x4000, 0, 0x8000, 0, 0, 0, 0}},
+		{"hdr", hdr, DefaultTextureOptions(), gl.RGB16F, gl.RGB, gl.FLOAT, []float32{0, 0, 0, 4, 0, 0}},
+		{"8 bit", image.NewNRGBA(rect), DefaultTextureOptions(), gl.RGBA8, gl.RGBA, gl.UNSIGNED_BYTE, make([]uint8, 8)},
+		{"8 bit srgb", image.NewNRGBA(rect), srgb, gl.SRGB8_ALPHA8, gl.RGBA, gl.UNSIGNED_BYTE, make([]uint8, 8)},
+	}
+	for _, tt := range tests {
+		p := NewTexturePixels(tt.img, tt.opts)
+		if p.InternalFormat != tt.internalFormat || p.Format != tt.format || p.Type != tt.typ {
+			t.Errorf("%s: formats are 0x%X 0x%X 0x%X, want 0x%X 0x%X 0x%X", tt.name,
+				p.InternalFormat, p.Format, p.Type, tt.internalFormat, tt.format, tt.typ)
+		}
+		if p.Width != 2 || p.Height != 1 || !reflect.DeepEqual(p.Pix, tt.pix) {
+			t.Errorf("%s: %dx%d %v, want 2x1 %v", tt.name, p.Width, p.Height, p.Pix, tt.pix)
+		}
+	}
+}
+
+func TestTextureLevelsCPUMips(t *testing.T) {
+	opts := DefaultTextureOptions()
+	opts.MipFilter = MipFilterKaiser
+	rect := image.Rect(0, 0, 8, 8)
+	if levels, err := TextureLevels(image.NewNRGBA(rect), opts); err != nil || len(levels) != 4 {
+		t.Errorf("8 bit image got %d levels and %v, want 4", len(levels), err)
+	}
+	for _, img := range []image.Image{image.NewGray16(rect), image.NewRGBA64(rect), NewHDRImage(rect)} {
+		levels, err := TextureLevels(img, opts)
+		if err != nil || len(levels) != 1 {
+			t.Errorf("%T got %d levels and %v, want 1 for the driver to mip", img, len(levels), err)
+		}
+	}
+}
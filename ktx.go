@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+var (
+	ktxIdentifier  = []byte{0xAB, 'K', 'T', 'X', ' ', '1', '1', 0xBB, '\r', '\n', 0x1A, '\n'}
+	ktx2Identifier = []byte{0xAB, 'K', 'T', 'X', ' ', '2', '0', 0xBB, '\r', '\n', 0x1A, '\n'}
+)
+
+const ktxEndianness = 0x04030201
+
+type ktxHeader struct {
+	Endianness           uint32
+	GLType               uint32
+	GLTypeSize           uint32
+	GLFormat             uint32
+	GLInternalFormat     uint32
+	GLBaseInternalFormat uint32
+	PixelWidth           uint32
+	PixelHeight          uint32
+	PixelDepth           uint32
+	ArrayElements        uint32
+	Faces                uint32
+	MipmapLevels         uint32
+	KeyValueBytes        uint32
+}
+
+// DecodeKTX reads a KTX 1 file holding a compressed 2D texture, array or
+// cubemap
+func DecodeKTX(data []byte) (*CompressedTexture, error) {
+	if !bytes.HasPrefix(data, ktxIdentifier) || len(data) < len(ktxIdentifier)+4 {
+		return nil, errors.New("ktx: not a KTX 1 file")
+	}
+	r := bytes.NewReader(data[len(ktxIdentifier):])
+	var order binary.ByteOrder = binary.LittleEndian
+	if binary.BigEndian.Uint32(data[len(ktxIdentifier):]) == ktxEndianness {
+		order = binary.BigEndian
+	}
+	var h ktxHeader
+	if err := binary.Read(r, order, &h); err != nil {
+		return nil, fmt.Errorf("ktx: %v", err)
+	}
+	if h.GLType != 0 {
+		return nil, errors.New("ktx: only compressed textures are supported")
+	}
+	if h.PixelHeight == 0 || h.PixelDepth > 1 {
+		return nil, errors.New("ktx: only 2D textures are supported")
+	}
+	if uint64(h.KeyValueBytes) > uint64(r.Len()) {
+		return nil, errors.New("ktx: truncated key/value data")
+	}
+	levels := maxUint32(h.MipmapLevels, 1)
+	if err := checkCompressedHeader(h.PixelWidth, h.PixelHeight, maxUint32(h.ArrayElements, 1), h.Faces, levels); err != nil {
+		return nil, fmt.Errorf("ktx: %v", err)
+	}
+
+	t := &CompressedTexture{
+		Format: h.GLInternalFormat,
+		Width:  int(h.PixelWidth),
+		Height: int(h.PixelHeight),
+		Layers: int(maxUint32(h.ArrayElements, 1)),
+		Faces:  int(h.Faces),
+	}
+	// A non-array cubemap gives the size of one face and pads each face
+	cubePadding := t.Faces == 6 && h.ArrayElements == 0
+
+	offset := len(data) - r.Len() + int(h.KeyValueBytes)
+	for level := 0; level < int(levels); level++ {
+		if offset+4 > len(data) {
+			return nil, fmt.Errorf("ktx: truncated at level %d", level)
+		}
+		imageSize := order.Uint32(data[offset:])
+		offset += 4
+		w, ht := t.LevelSize(level)
+		size, err := CompressedImageSize(t.Format, w, ht)
+		if err != nil {
+			return nil, fmt.Errorf("ktx: %v", err)
+		}
+
+		count := t.Layers * t.Faces
+		stride := size
+		if cubePadding {
+			stride += pad4(size)
+		} else if uint64(imageSize) != uint64(size)*uint64(count) {
+			return nil, fmt.Errorf("ktx: level %d is %d bytes, expected %d", level, imageSize, uint64(size)*uint64(count))
+		}
+		if uint64(stride)*uint64(count) > uint64(len(data)-offset) {
+			return nil, fmt.Errorf("ktx: truncated at level %d", level)
+		}
+		images := make([][]byte, count)
+		for i := range images {
+			images[i] = data[offset : offset+size]
+			offset += stride
+		}
+		offset += pad4(int(imageSize))
+		t.Images = append(t.Images, images)
+	}
+	return t, nil
+}
+
+// EncodeKTX writes a compressed texture as KTX 1, the bake step output
+func EncodeKTX(w io.Writer, t *CompressedTexture) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	base := uint32(gl.RGBA)
+	if compressedFormats[t.Format].opaque {
+		base = gl.RGB
+	}
+	h := ktxHeader{
+		Endianness:           ktxEndianness,
+		GLTypeSize:           1,
+		GLInternalFormat:     t.Format,
+		GLBaseInternalFormat: base,
+		PixelWidth:           uint32(t.Width),
+		PixelHeight:          uint32(t.Height),
+		Faces:                uint32(t.Faces),
+		MipmapLevels:         uint32(t.Levels()),
+	}
+	if t.Layers > 1 {
+		h.ArrayElements = uint32(t.Layers)
+	}
+	if _, err := w.Write(ktxIdentifier); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, &h); err != nil {
+		return err
+	}
+
+	cubePadding := t.Faces == 6 && t.Layers == 1
+	for _, images := range t.Images {
+		imageSize := 0
+		for _, img := range images {
+			imageSize += len(img)
+		}
+		if cubePadding {
+			imageSize = len(images[0])
+		}
+		if err := binary.Write(w, binary.LittleEndian, uint32(imageSize)); err != nil {
+			return err
+		}
+		for _, img := range images {
+			if _, err := w.Write(img); err != nil {
+				return err
+			}
+			if cubePadding {
+				w.Write(make([]byte, pad4(len(img))))
+			}
+		}
+		if _, err := w.Write(make([]byte, pad4(imageSize))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ktx2Header struct {
+	VkFormat               uint32
+	TypeSize               uint32
+	PixelWidth             uint32
+	PixelHeight            uint32
+	PixelDepth             uint32
+	LayerCount             uint32
+	FaceCount              uint32
+	LevelCount             uint32
+	SupercompressionScheme uint32
+	DFDByteOffset          uint32
+	DFDByteLength          uint32
+	KVDByteOffset          uint32
+	KVDByteLength          uint32
+	SGDByteOffset          uint64
+	SGDByteLength          uint64
+}
+
+type ktx2Level struct {
+	ByteOffset             uint64
+	ByteLength             uint64
+	UncompressedByteLength uint64
+}
+
+// Vulkan block formats and their GL equivalents
+var ktx2Formats = map[uint32]uint32{
+	131: gl.COMPRESSED_RGB_S3TC_DXT1_EXT,
+	132: compressedSRGBDXT1,
+	133: gl.COMPRESSED_RGBA_S3TC_DXT1_EXT,
+	134: compressedSRGBAlphaDXT1,
+	135: gl.COMPRESSED_RGBA_S3TC_DXT3_EXT,
+	136: compressedSRGBAlphaDXT3,
+	137: gl.COMPRESSED_RGBA_S3TC_DXT5_EXT,
+	138: compressedSRGBAlphaDXT5,
+	139: gl.COMPRESSED_RED_RGTC1,
+	140: gl.COMPRESSED_SIGNED_RED_RGTC1,
+	141: gl.COMPRESSED_RG_RGTC2,
+	142: gl.COMPRESSED_SIGNED_RG_RGTC2,
+	143: gl.COMPRESSED_RGB_BPTC_UNSIGNED_FLOAT_ARB,
+	144: gl.COMPRESSED_RGB_BPTC_SIGNED_FLOAT_ARB,
+	145: gl.COMPRESSED_RGBA_BPTC_UNORM_ARB,
+	146: gl.COMPRESSED_SRGB_ALPHA_BPTC_UNORM_ARB,
+	147: gl.COMPRESSED_RGB8_ETC2,
+	148: gl.COMPRESSED_SRGB8_ETC2,
+	149: gl.COMPRESSED_RGB8_PUNCHTHROUGH_ALPHA1_ETC2,
+	150: gl.COMPRESSED_SRGB8_PUNCHTHROUGH_ALPHA1_ETC2,
+	151: gl.COMPRESSED_RGBA8_ETC2_EAC,
+	152: gl.COMPRESSED_SRGB8_ALPHA8_ETC2_EAC,
+	153: gl.COMPRESSED_R11_EAC,
+	154: gl.COMPRESSED_SIGNED_R11_EAC,
+	155: gl.COMPRESSED_RG11_EAC,
+	156: gl.COMPRESSED_SIGNED_RG11_EAC,
+}
+
+// DecodeKTX2 reads a KTX 2 file holding a block compressed texture, array
+// or cubemap. Supercompressed files (Basis, zstd) are not supported.
+func DecodeKTX2(data []byte) (*CompressedTexture, error) {
+	if !bytes.HasPrefix(data, ktx2Identifier) {
+		return nil, errors.New("ktx2: not a KTX 2 file")
+	}
+	r := bytes.NewReader(data[len(ktx2Identifier):])
+	var h ktx2Header
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("ktx2: %v", err)
+	}
+	if h.SupercompressionScheme != 0 {
+		return nil, fmt.Errorf("ktx2: supercompression scheme %d is not supported", h.SupercompressionScheme)
+	}
+	format, ok := ktx2Formats[h.VkFormat]
+	if !ok {
+		return nil, fmt.Errorf("ktx2: unsupported vkFormat %d", h.VkFormat)
+	}
+	if h.PixelHeight == 0 || h.PixelDepth > 1 {
+		return nil, errors.New("ktx2: only 2D textures are supported")
+	}
+
+	if err := checkCompressedHeader(h.PixelWidth, h.PixelHeight, maxUint32(h.LayerCount, 1), h.FaceCount, maxUint32(h.LevelCount, 1)); err != nil {
+		return nil, fmt.Errorf("ktx2: %v", err)
+	}
+
+	levels := make([]ktx2Level, maxUint32(h.LevelCount, 1))
+	if err := binary.Read(r, binary.LittleEndian, levels); err != nil {
+		return nil, fmt.Errorf("ktx2: level index: %v", err)
+	}
+
+	t := &CompressedTexture{
+		Format: format,
+		Width:  int(h.PixelWidth),
+		Height: int(h.PixelHeight),
+		Layers: int(maxUint32(h.LayerCount, 1)),
+		Faces:  int(h.FaceCount),
+	}
+	for level, l := range levels {
+		w, ht := t.LevelSize(level)
+		size, err := CompressedImageSize(format, w, ht)
+		if err != nil {
+			return nil, fmt.Errorf("ktx2: %v", err)
+		}
+		count := t.Layers * t.Faces
+		// compared without adding so a huge offset can't wrap around
+		if l.ByteLength != uint64(size)*uint64(count) || l.ByteOffset > uint64(len(data)) || l.ByteLength > uint64(len(data))-l.ByteOffset {
+			return nil, fmt.Errorf("ktx2: level %d has a bad offset or length", level)
+		}
+		images := make([][]byte, count)
+		for i := range images {
+			start := int(l.ByteOffset) + i*size
+			images[i] = data[start : start+size]
+		}
+		t.Images = append(t.Images, images)
+	}
+	return t, nil
+}
+
+func maxUint32(v, min uint32) uint32 {
+	if v < min {
+		return min
+	}
+	return v
+}
+
+// pad4 is the padding after n bytes to the next multiple of 4
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
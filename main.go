@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
 	"runtime"
+	"strings"
 
 	"github.com/go-gl/gl/v3.3-core/gl"
 	"github.com/go-gl/glfw/v3.1/glfw"
@@ -14,7 +17,50 @@ func init() {
 	runtime.LockOSThread()
 }
 
+var (
+	cameraPathFile  = flag.String("path", "", "camera path JSON file, played back with P")
+	cameraViewsFile = flag.String("views", DefaultCameraViewsFile(), "camera bookmarks and last view file")
+	restoreView     = flag.Bool("restore", true, "restore the camera view from the previous run")
+	useGL43         = flag.Bool("gl43", false, "create an OpenGL 4.3 context for compute shaders")
+	assetPacks      = flag.String("packs", "", "comma separated .zip files or directories mounted over the built in assets, later ones win")
+	shaderCacheDir  = flag.String("shadercache", DefaultProgramCacheDir(), "directory for cached program binaries, empty to disable")
+	skyboxPath      = flag.String("skybox", DefaultSkybox, "cubemap asset drawn as the sky, a gradient is used if it doesn't exist")
+	gpuPicking      = flag.Bool("gpupick", false, "select with the offscreen ID pass instead of ray tests, G toggles it")
+	cubeMaterial    = flag.String("material", DefaultCubeMaterial, "material asset the cubes are drawn with, a .json or .yaml file")
+	bakeTexture     = flag.String("bake", "", "image asset to compress to a KTX file, runs without a window and exits")
+	bakeFormat      = flag.String("bakeformat", "bc3", "block format for -bake: bc1, bc1a or bc3")
+	bakeAtlas       = flag.String("atlas", "", "image asset directory to pack into atlas pages and a manifest, runs without a window and exits")
+	bakeOut         = flag.String("bakeout", "", "file written by -bake, defaults to the asset name with .ktx, or directory written by -atlas")
+)
+
 func main() {
+	flag.Parse()
+
+	assets := DefaultAssets()
+	defer assets.Close()
+	if *assetPacks != "" {
+		for i, pack := range strings.Split(*assetPacks, ",") {
+			if err := assets.MountPack("", pack, PackPriority+i); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	if *bakeTexture != "" {
+		if err := runBake(assets, *bakeTexture, *bakeFormat, *bakeOut); err != nil {
+			fmt.Println("bake failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *bakeAtlas != "" {
+		if err := runAtlasBake(assets, *bakeAtlas, *bakeOut); err != nil {
+			fmt.Println("atlas bake failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// make sure that we display any errors that are encountered
 	//glfw.SetErrorCallback(errorCallback)
 
@@ -25,10 +71,16 @@ func main() {
 	}
 	defer glfw.Terminate()
 
+	// OpenGL 3.3 unless compute shaders are wanted
+	major, minor := 3, 3
+	if *useGL43 {
+		major, minor = 4, 3
+	}
+
 	// Provide window hints for GLFW
 	glfw.WindowHint(glfw.Samples, 4)                            // desired number of samples to use for mulitsampling
-	glfw.WindowHint(glfw.ContextVersionMajor, 3)                // OpenGL Version 3.3
-	glfw.WindowHint(glfw.ContextVersionMinor, 3)                // OpenGL Version 3.3
+	glfw.WindowHint(glfw.ContextVersionMajor, major)            // OpenGL Version
+	glfw.WindowHint(glfw.ContextVersionMinor, minor)            // OpenGL Version
 	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile) // use the core version
 	glfw.WindowHint(glfw.Resizable, glfw.False)                 // disable window resizing
 
@@ -64,11 +116,37 @@ func main() {
 	version := gl.GoStr(gl.GetString(gl.VERSION))
 	fmt.Println("OpenGL version", version)
 
+	if *shaderCacheDir != "" {
+		ShaderBinaryCache = &ProgramBinaryCache{Dir: *shaderCacheDir}
+	}
+
 	///////////////////////////////////////////
 	camera := NewDefaultCamera()
 	camera.SetSpeed(5.00)
 	game := NewGame(width, height, camera)
+	game.SkyboxPath = *skyboxPath
+	game.CubeMaterialPath = *cubeMaterial
+
+	game.Assets = assets
+
 	game.Setup()
+	if *cameraPathFile != "" {
+		path, err := LoadCameraPath(*cameraPathFile)
+		if err != nil {
+			panic(err)
+		}
+		game.PathPlayer = NewCameraPathPlayer(path)
+	}
+
+	views, err := LoadCameraViews(*cameraViewsFile, camera)
+	if err != nil {
+		fmt.Println("ignoring camera views, saving will replace them:", err)
+		views = NewCameraViews(*cameraViewsFile)
+	}
+	if *restoreView {
+		views.RestoreLast(camera)
+	}
+	game.Views = views
 	/////////////////////////////////////////////
 
 	// Key callback function to handle key press
@@ -76,9 +154,13 @@ func main() {
 	window.SetKeyCallback(game.KeyEventHandler())
 	window.SetCursorPosCallback(game.CursorEventHandler())
 	window.SetScrollCallback(game.ScrollEventHandler())
+	window.SetMouseButtonCallback(game.MouseButtonEventHandler())
 	window.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
+	game.CursorLocked = true
+	game.UseGPUPicking = *gpuPicking
 
-	// Game Loop
+	// Game Loop, the title shows how much GL state the cache saved
+	lastTitle := glfw.GetTime()
 	for !window.ShouldClose() {
 		// Check and call events
 		glfw.PollEvents()
@@ -91,10 +173,23 @@ func main() {
 		// Run the main game render method
 		game.Render()
 
+		if now := glfw.GetTime(); now-lastTitle >= 1 {
+			window.SetTitle(fmt.Sprintf("Testing - %v", game.State.LastFrame()))
+			lastTitle = now
+		}
+
 		// Swap the buffers
 		window.SwapBuffers()
 
 	}
+
+	game.Shutdown()
+
+	// Remember where we were for the next run
+	views.SetLast(camera)
+	if err := views.Save(); err != nil {
+		fmt.Println("failed to save camera view:", err)
+	}
 }
 
 // handle GLFW errors by printing them out
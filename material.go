@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Material is how something looks: the program it is drawn with, the
+// uniform values and textures it sets and the render state it needs. It is
+// loaded from a JSON or YAML file so looks can change without a rebuild:
+//
+//	{
+//	  "shader": ["shaders/basic_tex.vert", "shaders/basic_tex.frag"],
+//	  "defines": {"HAS_TINT": "", "NUM_LIGHTS": "4"},
+//	  "params": {"mixValue": 0.2, "tint": "#ffcc88"},
+//	  "textures": {"texture1": "textures/container.jpg", "layers": "textures/terrain"},
+//	  "state": {"blend": "alpha", "cull": "back"}
+//	}
+//
+// A texture naming a directory is loaded as a texture array of the images
+// in it, for a sampler2DArray.
+type Material struct {
+	Name    string
+	Program *ShaderProgram
+
+	// Params are uniform values by name: float32, int32 for bools and
+	// ints, or mgl32.Vec2, Vec3 and Vec4
+	Params   map[string]interface{}
+	Samplers SamplerSlots
+	State    RenderState
+
+	handles []AssetHandle
+}
+
+// materialFile is what a material file holds. Textures are by sampler
+// name, the samplers get texture units in name order.
+type materialFile struct {
+	Shader   []string               `json:"shader"`
+	Defines  ShaderDefines          `json:"defines"`
+	Params   map[string]interface{} `json:"params"`
+	Textures map[string]string      `json:"textures"`
+	State    RenderState            `json:"state"`
+}
+
+// decodeMaterialFile reads a material as JSON, or as YAML when the
+// extension says so
+func decodeMaterialFile(data []byte, file string) (*materialFile, error) {
+	switch strings.ToLower(path.Ext(file)) {
+	case ".yaml", ".yml":
+		v, err := parseYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	case ".json":
+	default:
+		return nil, errors.New("materials must be .json, .yaml or .yml files")
+	}
+	def := &materialFile{State: DefaultRenderState()}
+	// numbers stay as written so params can tell ints from floats
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(def); err != nil {
+		return nil, err
+	}
+	if len(def.Shader) == 0 {
+		return nil, errors.New("no shader files")
+	}
+	return def, def.State.Validate()
+}
+
+// parseMaterialParam turns a decoded JSON value into a uniform value: whole
+// numbers are ints and other numbers floats, bools are ints, arrays of 2 to
+// 4 numbers are vectors and "#rrggbb" or "#rrggbbaa" colours are a Vec3 or
+// Vec4. An int for a float uniform becomes a float once the program is
+// known, see matchParamTypes.
+func parseMaterialParam(raw interface{}) (interface{}, error) {
+	switch v := raw.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 32); err == nil {
+			return int32(i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a number", v)
+		}
+		return float32(f), nil
+	case bool:
+		if v {
+			return int32(1), nil
+		}
+		return int32(0), nil
+	case string:
+		return parseColor(v)
+	case []interface{}:
+		var f [4]float32
+		for i, e := range v {
+			n, ok := e.(json.Number)
+			if !ok || i >= 4 {
+				return nil, fmt.Errorf("a vector is 2 to 4 numbers, not %v", v)
+			}
+			x, err := n.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("%s is not a number", n)
+			}
+			f[i] = float32(x)
+		}
+		switch len(v) {
+		case 2:
+			return mgl32.Vec2{f[0], f[1]}, nil
+		case 3:
+			return mgl32.Vec3{f[0], f[1], f[2]}, nil
+		case 4:
+			return mgl32.Vec4(f), nil
+		}
+		return nil, fmt.Errorf("a vector is 2 to 4 numbers, not %v", v)
+	}
+	return nil, fmt.Errorf("unsupported value %v", raw)
+}
+
+// parseColor reads a "#rrggbb" colour as a Vec3 or "#rrggbbaa" as a Vec4
+func parseColor(s string) (interface{}, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if hex == s || (len(hex) != 6 && len(hex) != 8) {
+		return nil, fmt.Errorf("%q is not a #rrggbb or #rrggbbaa colour", s)
+	}
+	var c mgl32.Vec4
+	for i := 0; i < len(hex)/2; i++ {
+		b, err := strconv.ParseUint(hex[i*2:i*2+2], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a #rrggbb or #rrggbbaa colour", s)
+		}
+		c[i] = float32(b) / 255
+	}
+	if len(hex) == 6 {
+		return c.Vec3(), nil
+	}
+	return c, nil
+}
+
+// materialParamTypes are the uniform types a param value can set
+func materialParamTypes(v interface{}) []uint32 {
+	switch v.(type) {
+	case float32:
+		return []uint32{gl.FLOAT}
+	case int32:
+		return []uint32{gl.INT, gl.BOOL}
+	case mgl32.Vec2:
+		return []uint32{gl.FLOAT_VEC2}
+	case mgl32.Vec3:
+		return []uint32{gl.FLOAT_VEC3}
+	case mgl32.Vec4:
+		return []uint32{gl.FLOAT_VEC4}
+	}
+	return nil
+}
+
+// LoadMaterial reads a material file and loads its program and textures
+// through the manager, so materials sharing them share the GPU objects.
+// Each call returns a new Material whose params can be changed on their
+// own. Release it when done.
+func (m *AssetManager) LoadMaterial(file string) (*Material, error) {
+	b, err := fs.ReadFile(m.FS, NormalizeVFSPath(file))
+	if err != nil {
+		return nil, err
+	}
+	def, err := decodeMaterialFile(b, file)
+	if err != nil {
+		return nil, fmt.Errorf("material %q: %v", file, err)
+	}
+
+	mat := &Material{Name: file, Params: map[string]interface{}{}, State: def.State}
+	for name, raw := range def.Params {
+		v, err := parseMaterialParam(raw)
+		if err != nil {
+			return nil, fmt.Errorf("material %q: param %q: %v", file, name, err)
+		}
+		mat.Params[name] = v
+	}
+
+	prog, err := m.LoadProgramVariant(def.Defines, def.Shader...)
+	if err != nil {
+		return nil, err
+	}
+	mat.Program = prog.Program()
+	mat.handles = append(mat.handles, prog)
+
+	samplers := make([]string, 0, len(def.Textures))
+	for name := range def.Textures {
+		samplers = append(samplers, name)
+	}
+	sort.Strings(samplers)
+	for _, name := range samplers {
+		if m.isDir(def.Textures[name]) {
+			array, err := m.LoadTextureArray(def.Textures[name])
+			if err != nil {
+				mat.Release()
+				return nil, fmt.Errorf("material %q: %v", file, err)
+			}
+			mat.handles = append(mat.handles, array)
+			mat.Samplers.Set(name, array)
+			continue
+		}
+		// with a Loader failures show up on Loader.Upload instead
+		tex, err := m.LoadTexture(def.Textures[name])
+		mat.handles = append(mat.handles, tex)
+		if err != nil {
+			mat.Release()
+			return nil, fmt.Errorf("material %q: %v", file, err)
+		}
+		mat.Samplers.Set(name, tex)
+	}
+
+	mat.matchParamTypes()
+	if err := mat.Validate(); err != nil {
+		mat.Release()
+		return nil, fmt.Errorf("material %q: %v", file, err)
+	}
+	return mat, nil
+}
+
+// matchParamTypes makes whole number params for float uniforms floats, so
+// "mixValue": 1 works as well as 1.0
+func (mat *Material) matchParamTypes() {
+	for name, v := range mat.Params {
+		if i, ok := v.(int32); ok {
+			if u, ok := mat.Program.uniforms[name]; ok && u.Type == gl.FLOAT {
+				mat.Params[name] = float32(i)
+			}
+		}
+	}
+}
+
+// Validate checks the render state and that every param and texture
+// matches a uniform of the program
+func (mat *Material) Validate() error {
+	if err := mat.State.Validate(); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(mat.Params))
+	for name := range mat.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		types := materialParamTypes(mat.Params[name])
+		if types == nil {
+			return fmt.Errorf("param %q: unsupported value %T", name, mat.Params[name])
+		}
+		if _, err := mat.Program.uniform(name, 1, types...); err != nil {
+			return err
+		}
+	}
+	return mat.Samplers.Validate(mat.Program)
+}
+
+// SetParam changes a uniform value, it is set on the next Use
+func (mat *Material) SetParam(name string, v interface{}) {
+	mat.Params[name] = v
+}
+
+// Use applies the render state, makes the program current and sets the
+// params and textures, so draws only need to set per object uniforms.
+// Everything is applied even when one of them fails, the first error is
+// returned.
+func (mat *Material) Use(c *StateCache) error {
+	c.Apply(mat.State)
+	c.UseProgram(mat.Program.ID)
+	var first error
+	for name, v := range mat.Params {
+		if err := mat.Program.setValue(name, v); err != nil && first == nil {
+			first = err
+		}
+	}
+	if err := mat.Samplers.Bind(c, mat.Program); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
+
+// Release gives back the program and textures the material holds
+func (mat *Material) Release() {
+	for _, h := range mat.handles {
+		h.Release()
+	}
+	mat.handles = nil
+	mat.Samplers = SamplerSlots{}
+}
+
+// Renderable is a mesh drawn with a material
+type Renderable struct {
+	Material *Material
+	VAO      uint32
+
+	// Count is the number of vertices drawn as triangles
+	Count int32
+}
+
+// Draw draws the mesh once for each model matrix
+func (r *Renderable) Draw(c *StateCache, models []mgl32.Mat4) error {
+	first := r.Material.Use(c)
+	c.BindVertexArray(r.VAO)
+	for _, model := range models {
+		if err := r.Material.Program.SetMat4("model", model); err != nil && first == nil {
+			first = err
+		}
+		gl.DrawArrays(gl.TRIANGLES, 0, r.Count)
+	}
+	return first
+}
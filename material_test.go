@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const materialJSON = `{
+  "shader": ["shaders/basic_tex.vert", "shaders/basic_tex.frag"],
+  "defines": {"HAS_TINT": "", "NUM_LIGHTS": "4"},
+  "params": {"mixValue": 0.2, "lights": 3, "tint": "#ffcc88", "offset": [0.5, -1, 2], "lit": true},
+  "textures": {"texture1": "textures/container.jpg", "texture2": "textures/awesomeface.png"},
+  "state": {"blend": "alpha", "cull": "back"}
+}`
+
+const materialYAML = `# the same material as materialJSON
+shader:
+  - shaders/basic_tex.vert
+  - shaders/basic_tex.frag
+defines:
+  HAS_TINT: ""
+  NUM_LIGHTS: "4"
+params:
+  mixValue: 0.2
+  lights: 3
+  tint: "#ffcc88"
+  offset: [0.5, -1, 2]
+  lit: true
+textures:
+  texture1: textures/container.jpg
+  texture2: textures/awesomeface.png
+state:
+  blend: alpha
+  cull: back
+`
+
+func TestMaterialYAMLMatchesJSON(t *testing.T) {
+	fromJSON, err := decodeMaterialFile([]byte(materialJSON), "cube.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := decodeMaterialFile([]byte(materialYAML), "cube.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Errorf("YAML material differs from JSON:\n%+v\n%+v", fromYAML, fromJSON)
+	}
+}
+
+func TestParseMaterialParams(t *testing.T) {
+	def, err := decodeMaterialFile([]byte(materialJSON), "cube.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"mixValue": float32(0.2),
+		"lights":   int32(3),
+		"tint":     mgl32.Vec3{1, 0.8, float32(0x88) / 255},
+		"offset":   mgl32.Vec3{0.5, -1, 2},
+		"lit":      int32(1),
+	}
+	for name, raw := range def.Params {
+		got, err := parseMaterialParam(raw)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want[name]) {
+			t.Errorf("%s = %#v, want %#v", name, got, want[name])
+		}
+	}
+}
+
+func TestParseMaterialParamErrors(t *testing.T) {
+	tests := map[string]string{
+		"short colour":      `"#fff"`,
+		"bad colour":        `"#ffzz00"`,
+		"plain string":      `"red"`,
+		"long vector":       `[1, 2, 3, 4, 5]`,
+		"one number vector": `[1]`,
+		"string in vector":  `[1, "2"]`,
+		"object":            `{"x": 1}`,
+		"null":              `null`,
+	}
+	for name, src := range tests {
+		def, err := decodeMaterialFile([]byte(`{"shader": ["a.vert"], "params": {"p": `+src+`}}`), "m.json")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if v, err := parseMaterialParam(def.Params["p"]); err == nil {
+			t.Errorf("%s: parsed as %#v", name, v)
+		}
+	}
+}
+
+func TestDecodeMaterialFileErrors(t *testing.T) {
+	tests := []struct {
+		name, file, data string
+	}{
+		{"no shader", "m.json", `{"params": {}}`},
+		{"bad extension", "m.txt", `{"shader": ["a.vert"]}`},
+		{"bad state", "m.json", `{"shader": ["a.vert"], "state": {"blend": "glow"}}`},
+		{"bad yaml", "m.yaml", "shader:\n\t- a.vert\n"},
+		{"bad json", "m.json", `{"shader": `},
+	}
+	for _, tt := range tests {
+		if _, err := decodeMaterialFile([]byte(tt.data), tt.file); err == nil {
+			t.Errorf("%s: decoded without an error", tt.name)
+		}
+	}
+}
+
+func TestMaterialParamTypes(t *testing.T) {
+	// no GL context in tests, so skip the query
+	maxTextureUnits = 16
+	prog := &ShaderProgram{uniforms: map[string]shaderVariable{
+		"mixValue": {Type: gl.FLOAT, Size: 1},
+		"lights":   {Type: gl.INT, Size: 1},
+		"lit":      {Type: gl.BOOL, Size: 1},
+		"tint":     {Type: gl.FLOAT_VEC3, Size: 1},
+	}}
+	mat := &Material{Program: prog, State: DefaultRenderState(), Params: map[string]interface{}{
+		"mixValue": int32(1),
+		"lights":   int32(3),
+		"lit":      int32(1),
+		"tint":     mgl32.Vec3{1, 1, 1},
+	}}
+	mat.matchParamTypes()
+	if v := mat.Params["mixValue"]; v != float32(1) {
+		t.Errorf("whole number for a float uniform is %#v, want float32(1)", v)
+	}
+	if v := mat.Params["lights"]; v != int32(3) {
+		t.Errorf("int uniform param is %#v, want int32(3)", v)
+	}
+	if err := mat.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := []struct {
+		name string
+		v    interface{}
+	}{
+		{"lights", float32(2.5)},
+		{"tint", mgl32.Vec4{}},
+		{"missing", float32(1)},
+		{"mixValue", "0.5"},
+	}
+	for _, b := range bad {
+		params := map[string]interface{}{b.name: b.v}
+		mat := &Material{Program: prog, State: DefaultRenderState(), Params: params}
+		err := mat.Validate()
+		if err == nil {
+			t.Errorf("%s = %#v validated", b.name, b.v)
+		} else if !strings.Contains(err.Error(), b.name) {
+			t.Errorf("%s: error %q doesn't name the param", b.name, err)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Filters for GenerateMips
+const (
+	MipFilterBox     = "box"
+	MipFilterKaiser  = "kaiser"
+	MipFilterLanczos = "lanczos"
+)
+
+// MipOptions controls how GenerateMips filters each level
+type MipOptions struct {
+	Filter string
+
+	// SRGB filters colour in linear space and encodes the result back to
+	// sRGB, without it dark and bright texels average to a too dark colour.
+	SRGB bool
+
+	// AlphaCutoff, when above 0, is the alpha test threshold of a cutout
+	// texture. Each level's alpha is scaled so the same fraction of texels
+	// pass as at the top level, otherwise foliage thins out with distance.
+	AlphaCutoff float32
+
+	// NormalMap treats RGB as a unit vector and renormalises every level
+	NormalMap bool
+
+	// Wrap samples across the edges for tiling textures instead of clamping
+	Wrap bool
+}
+
+type mipKernel struct {
+	radius float64
+	weight func(x float64) float64
+}
+
+var mipKernels = map[string]mipKernel{
+	MipFilterBox:     {0.5, boxWeight},
+	MipFilterKaiser:  {3, kaiserWeight},
+	MipFilterLanczos: {3, lanczosWeight},
+}
+
+func boxWeight(x float64) float64 {
+	if x >= -0.5 && x < 0.5 {
+		return 1
+	}
+	return 0
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+func lanczosWeight(x float64) float64 {
+	if x <= -3 || x >= 3 {
+		return 0
+	}
+	return sinc(x) * sinc(x/3)
+}
+
+// kaiserWeight is a sinc windowed by a Kaiser window of width 3 and alpha 4
+func kaiserWeight(x float64) float64 {
+	const width, alpha = 3.0, 4.0
+	t := x / width
+	if t <= -1 || t >= 1 {
+		return 0
+	}
+	return sinc(x) * besselI0(alpha*math.Sqrt(1-t*t)) / besselI0(alpha)
+}
+
+// besselI0 is the zeroth order modified Bessel function of the first kind
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 50; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < sum*1e-12 {
+			break
+		}
+	}
+	return sum
+}
+
+// MipLevelCount is the number of levels in a full chain down to 1x1
+func MipLevelCount(width, height int) int {
+	n := 1
+	for width > 1 || height > 1 {
+		width, height = halve(width), halve(height)
+		n++
+	}
+	return n
+}
+
+func halve(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return n / 2
+}
+
+// GenerateMips returns the full mip chain of src, src itself is level 0.
+// Like src the levels are premultiplied. It is deterministic, the same
+// input and options give the same bytes.
+func GenerateMips(src *image.RGBA, opts MipOptions) ([]*image.RGBA, error) {
+	kernel, ok := mipKernels[opts.Filter]
+	if !ok {
+		return nil, fmt.Errorf("unknown mip filter %q", opts.Filter)
+	}
+	if src.Rect.Empty() {
+		return nil, fmt.Errorf("cannot build mips of an empty image")
+	}
+
+	level := newMipImage(src, opts)
+	coverage := float32(0)
+	if opts.AlphaCutoff > 0 {
+		coverage = level.alphaCoverage(opts.AlphaCutoff, 1)
+	}
+
+	levels := []*image.RGBA{src}
+	for level.w > 1 || level.h > 1 {
+		w, h := halve(level.w), halve(level.h)
+		level = level.resize(w, h, kernel, opts.Wrap)
+		if opts.NormalMap {
+			level.normalize()
+		}
+		scale := float32(1)
+		if opts.AlphaCutoff > 0 {
+			scale = level.alphaScaleFor(opts.AlphaCutoff, coverage)
+		}
+		levels = append(levels, level.rgba(opts, scale))
+	}
+	return levels, nil
+}
+
+// mipImage holds four float channels per texel: colour premultiplied in
+// linear space, or a straight [-1, 1] vector for normal maps
+type mipImage struct {
+	w, h int
+	pix  []float32
+}
+
+var srgbToLinear = func() (table [256]float32) {
+	for i := range table {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			table[i] = float32(c / 12.92)
+		} else {
+			table[i] = float32(math.Pow((c+0.055)/1.055, 2.4))
+		}
+	}
+	return table
+}()
+
+func linearToSRGB(c float32) float32 {
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+	return float32(1.055*math.Pow(float64(c), 1/2.4) - 0.055)
+}
+
+// newMipImage unpremultiplies src so sRGB can be decoded from the stored
+// colour, then premultiplies again in linear space for filtering
+func newMipImage(src *image.RGBA, opts MipOptions) mipImage {
+	size := src.Rect.Size()
+	m := mipImage{w: size.X, h: size.Y, pix: make([]float32, size.X*size.Y*4)}
+	for y := 0; y < m.h; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x := 0; x < m.w; x++ {
+			s, d := row[x*4:x*4+4], m.pix[(y*m.w+x)*4:]
+			a := float32(s[3]) / 255
+			d[3] = a
+			for c := 0; c < 3; c++ {
+				v := float32(s[c]) / 255
+				if a > 0 && a < 1 {
+					v = clamp01(v / a)
+				}
+				switch {
+				case opts.NormalMap:
+					d[c] = v*2 - 1
+				case opts.SRGB:
+					d[c] = srgbToLinear[quantize(v)] * a
+				default:
+					d[c] = v * a
+				}
+			}
+		}
+	}
+	return m
+}
+
+type mipTap struct {
+	index  int
+	weight float32
+}
+
+// mipTaps lists the source texels and normalised weights that make up each
+// destination texel along one axis
+func mipTaps(srcLen, dstLen int, k mipKernel, wrap bool) [][]mipTap {
+	scale := float64(srcLen) / float64(dstLen)
+	support := k.radius * scale
+	taps := make([][]mipTap, dstLen)
+	for i := range taps {
+		center := (float64(i) + 0.5) * scale
+		var sum float64
+		var row []mipTap
+		for s := int(math.Floor(center - support)); s <= int(math.Ceil(center+support)); s++ {
+			w := k.weight((float64(s) + 0.5 - center) / scale)
+			if w == 0 {
+				continue
+			}
+			idx := s
+			if wrap {
+				idx = ((s % srcLen) + srcLen) % srcLen
+			} else if idx < 0 {
+				idx = 0
+			} else if idx >= srcLen {
+				idx = srcLen - 1
+			}
+			row = append(row, mipTap{idx, float32(w)})
+			sum += w
+		}
+		for j := range row {
+			row[j].weight /= float32(sum)
+		}
+		taps[i] = row
+	}
+	return taps
+}
+
+// resize filters horizontally then vertically
+func (m mipImage) resize(w, h int, k mipKernel, wrap bool) mipImage {
+	tmp := mipImage{w: w, h: m.h, pix: make([]float32, w*m.h*4)}
+	taps := mipTaps(m.w, w, k, wrap)
+	for y := 0; y < m.h; y++ {
+		for x := 0; x < w; x++ {
+			d := tmp.pix[(y*w+x)*4:]
+			for _, t := range taps[x] {
+				s := m.pix[(y*m.w+t.index)*4:]
+				for c := 0; c < 4; c++ {
+					d[c] += s[c] * t.weight
+				}
+			}
+		}
+	}
+
+	out := mipImage{w: w, h: h, pix: make([]float32, w*h*4)}
+	taps = mipTaps(m.h, h, k, wrap)
+	for y := 0; y < h; y++ {
+		for _, t := range taps[y] {
+			for x := 0; x < w; x++ {
+				d, s := out.pix[(y*w+x)*4:], tmp.pix[(t.index*w+x)*4:]
+				for c := 0; c < 4; c++ {
+					d[c] += s[c] * t.weight
+				}
+			}
+		}
+	}
+	return out
+}
+
+func (m mipImage) normalize() {
+	for i := 0; i < len(m.pix); i += 4 {
+		n := m.pix[i : i+3]
+		l := float32(math.Sqrt(float64(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])))
+		if l == 0 {
+			n[0], n[1], n[2] = 0, 0, 1
+			continue
+		}
+		n[0], n[1], n[2] = n[0]/l, n[1]/l, n[2]/l
+	}
+}
+
+// alphaCoverage is the fraction of texels that pass the alpha test after
+// scaling alpha by scale
+func (m mipImage) alphaCoverage(cutoff, scale float32) float32 {
+	pass := 0
+	for i := 3; i < len(m.pix); i += 4 {
+		if clamp01(m.pix[i]*scale) >= cutoff {
+			pass++
+		}
+	}
+	return float32(pass) / float32(m.w*m.h)
+}
+
+// alphaScaleFor searches for the alpha scale that gives the wanted coverage
+func (m mipImage) alphaScaleFor(cutoff, coverage float32) float32 {
+	lo, hi := float32(0), float32(4)
+	best, bestErr := float32(1), float32(math.MaxFloat32)
+	for i := 0; i < 16; i++ {
+		mid := (lo + hi) / 2
+		got := m.alphaCoverage(cutoff, mid)
+		if e := float32(math.Abs(float64(got - coverage))); e < bestErr {
+			best, bestErr = mid, e
+		}
+		if got < coverage {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return best
+}
+
+// rgba unpremultiplies, scales alpha for coverage and encodes, then
+// premultiplies by the new alpha. Scaling alpha alone would leave the
+// colour premultiplied by the old one and darken cutout edges.
+func (m mipImage) rgba(opts MipOptions, alphaScale float32) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, m.w, m.h))
+	for i := 0; i < len(m.pix); i += 4 {
+		s, d := m.pix[i:i+4], img.Pix[i:i+4]
+		a := clamp01(s[3])
+		alpha := quantize(a * alphaScale)
+		for c := 0; c < 3; c++ {
+			v := s[c]
+			switch {
+			case opts.NormalMap:
+				v = v*0.5 + 0.5
+			case a > 0:
+				v = clamp01(v / a)
+				if opts.SRGB {
+					v = linearToSRGB(v)
+				}
+			default:
+				v = 0
+			}
+			d[c] = quantize(v * float32(alpha) / 255)
+		}
+		d[3] = alpha
+	}
+	return img
+}
+
+func clamp01(v float32) float32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+func quantize(v float32) uint8 {
+	return uint8(clamp01(v)*255 + 0.5)
+}
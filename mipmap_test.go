@@ -0,0 +1,146 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func rgbaImage(w, h int, pix ...color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, c := range pix {
+		img.SetRGBA(i%w, i/w, c)
+	}
+	return img
+}
+
+func grey(v uint8) color.RGBA {
+	return color.RGBA{v, v, v, 255}
+}
+
+func TestMipLevelCount(t *testing.T) {
+	tests := []struct{ w, h, want int }{{1, 1, 1}, {2, 2, 2}, {256, 256, 9}, {256, 1, 9}, {5, 3, 3}}
+	for _, tt := range tests {
+		if got := MipLevelCount(tt.w, tt.h); got != tt.want {
+			t.Errorf("%dx%d: %d levels, want %d", tt.w, tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateMipsBox(t *testing.T) {
+	src := rgbaImage(2, 2, grey(0), grey(100), grey(200), grey(255))
+	levels, err := GenerateMips(src, MipOptions{Filter: MipFilterBox})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 2 || levels[0] != src {
+		t.Fatalf("got %d levels, want src and a 1x1", len(levels))
+	}
+	// (0 + 100 + 200 + 255) / 4 = 138.75
+	if got := levels[1].RGBAAt(0, 0); got != grey(139) {
+		t.Errorf("box filtered 2x2 to %v, want grey 139", got)
+	}
+}
+
+func TestGenerateMipsSRGB(t *testing.T) {
+	src := rgbaImage(2, 2, grey(0), grey(255), grey(255), grey(0))
+	linear, _ := GenerateMips(src, MipOptions{Filter: MipFilterBox})
+	srgb, _ := GenerateMips(src, MipOptions{Filter: MipFilterBox, SRGB: true})
+	if got := linear[1].RGBAAt(0, 0); got != grey(128) {
+		t.Errorf("without sRGB black and white average to %v, want grey 128", got)
+	}
+	// half linear intensity is 0.735 in sRGB
+	if got := srgb[1].RGBAAt(0, 0); got != grey(188) {
+		t.Errorf("with sRGB black and white average to %v, want grey 188", got)
+	}
+}
+
+// Transparent texels add no colour: one opaque red texel among three clear
+// ones is red at a quarter alpha, premultiplied
+func TestGenerateMipsPremultiplied(t *testing.T) {
+	clear := color.RGBA{}
+	src := rgbaImage(2, 2, color.RGBA{255, 0, 0, 255}, clear, clear, clear)
+	for _, srgb := range []bool{false, true} {
+		levels, err := GenerateMips(src, MipOptions{Filter: MipFilterBox, SRGB: srgb})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := levels[1].RGBAAt(0, 0); got != (color.RGBA{64, 0, 0, 64}) {
+			t.Errorf("sRGB %v: got %v, want {64 0 0 64}", srgb, got)
+		}
+	}
+
+	// half transparent sRGB grey must survive as the same straight colour
+	half := color.RGBA{100, 100, 100, 128}
+	levels, _ := GenerateMips(rgbaImage(2, 2, half, half, half, half), MipOptions{Filter: MipFilterBox, SRGB: true})
+	if got := levels[1].RGBAAt(0, 0); got != half {
+		t.Errorf("uniform translucent image filtered to %v, want %v", got, half)
+	}
+}
+
+func coverage(img *image.RGBA, cutoff float32) float32 {
+	pass := 0
+	for i := 3; i < len(img.Pix); i += 4 {
+		if float32(img.Pix[i]) >= cutoff*255 {
+			pass++
+		}
+	}
+	return float32(pass) / float32(len(img.Pix)/4)
+}
+
+func TestGenerateMipsAlphaCoverage(t *testing.T) {
+	// white with a soft edged disc of alpha, like a leaf cutout
+	const size = 32
+	src := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			d := math.Hypot(float64(x)+0.5-size/2, float64(y)+0.5-size/2)
+			a := uint8(255 * math.Max(0, math.Min(1, (12-d)/6)))
+			src.SetRGBA(x, y, color.RGBA{a, a, a, a})
+		}
+	}
+	const cutoff = 0.5
+	want := coverage(src, cutoff)
+	plain, _ := GenerateMips(src, MipOptions{Filter: MipFilterBox, SRGB: true})
+	kept, err := GenerateMips(src, MipOptions{Filter: MipFilterBox, SRGB: true, AlphaCutoff: cutoff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(kept)-2; i++ {
+		if got := coverage(kept[i], cutoff); math.Abs(float64(got-want)) > 0.06 {
+			t.Errorf("level %d coverage %.3f, want about %.3f (without scaling %.3f)", i, got, want, coverage(plain[i], cutoff))
+		}
+		// the colour is white everywhere, scaling alpha mustn't darken it
+		for p := 0; p < len(kept[i].Pix); p += 4 {
+			c, a := kept[i].Pix[p], kept[i].Pix[p+3]
+			if a > 0 && int(a)-int(c) > 1 {
+				t.Errorf("level %d texel %d is %d at alpha %d, want white", i, p/4, c, a)
+				break
+			}
+		}
+	}
+}
+
+func TestGenerateMipsNormalMap(t *testing.T) {
+	// +X and +Y normals average to a unit vector between them
+	src := rgbaImage(2, 1, color.RGBA{255, 128, 128, 255}, color.RGBA{128, 255, 128, 255})
+	levels, err := GenerateMips(src, MipOptions{Filter: MipFilterBox, NormalMap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := levels[1].RGBAAt(0, 0)
+	n := [3]float64{float64(got.R)/255*2 - 1, float64(got.G)/255*2 - 1, float64(got.B)/255*2 - 1}
+	if l := math.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2]); math.Abs(l-1) > 0.02 || math.Abs(n[0]-n[1]) > 0.02 {
+		t.Errorf("normal %v has length %.3f, want a unit vector with x = y", n, l)
+	}
+}
+
+func TestGenerateMipsErrors(t *testing.T) {
+	if _, err := GenerateMips(rgbaImage(2, 2), MipOptions{Filter: "cubic"}); err == nil {
+		t.Error("unknown filter accepted")
+	}
+	if _, err := GenerateMips(image.NewRGBA(image.Rect(0, 0, 0, 0)), MipOptions{Filter: MipFilterBox}); err == nil {
+		t.Error("empty image accepted")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import "sort"
+
+// Pick IDs are written into the picking buffer as id+1 so that a cleared
+// pixel (0) means nothing was drawn there.
+const NoPickID = 0
+
+func EncodePickID(instance int) uint32 {
+	return uint32(instance + 1)
+}
+
+// DecodePickID returns the instance index for a pixel value, or -1 for background
+func DecodePickID(id uint32) int {
+	if id == NoPickID {
+		return -1
+	}
+	return int(id - 1)
+}
+
+// PickTarget is anything that can return the IDs rendered into a rectangle,
+// x and y are in framebuffer pixels with the origin at the bottom left.
+type PickTarget interface {
+	Size() (int, int)
+	ReadIDs(x, y, width, height int) []uint32
+}
+
+// PickRect is a selection rectangle in window coordinates (origin top left)
+type PickRect struct {
+	X0, Y0, X1, Y1 int
+}
+
+// Framebuffer converts the rectangle to a bottom left origin and clamps it to
+// the target, an empty rectangle gives a zero width or height.
+func (r PickRect) Framebuffer(width, height int) (x, y, w, h int) {
+	x0, x1 := r.X0, r.X1
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	y0, y1 := r.Y0, r.Y1
+	if y0 > y1 {
+		y0, y1 = y1, y0
+	}
+	x0, x1 = clampInt(x0, 0, width), clampInt(x1+1, 0, width)
+	y0, y1 = clampInt(y0, 0, height), clampInt(y1+1, 0, height)
+	return x0, height - y1, x1 - x0, y1 - y0
+}
+
+// PickPixel returns the instance under a single window coordinate, or -1
+func PickPixel(t PickTarget, x, y int) int {
+	ids := PickRectangle(t, PickRect{x, y, x, y})
+	if len(ids) == 0 {
+		return -1
+	}
+	return ids[0]
+}
+
+// PickRectangle returns every instance visible inside the rectangle, in order
+func PickRectangle(t PickTarget, r PickRect) []int {
+	width, height := t.Size()
+	x, y, w, h := r.Framebuffer(width, height)
+	if w <= 0 || h <= 0 {
+		return nil
+	}
+	return UniqueInstances(t.ReadIDs(x, y, w, h))
+}
+
+// UniqueInstances decodes a block of pixels into a sorted set of instances
+func UniqueInstances(ids []uint32) []int {
+	seen := map[uint32]bool{}
+	instances := []int{}
+	for _, id := range ids {
+		if id == NoPickID || seen[id] {
+			continue
+		}
+		seen[id] = true
+		instances = append(instances, DecodePickID(id))
+	}
+	sort.Ints(instances)
+	return instances
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
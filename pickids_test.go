@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPickIDRoundTrip(t *testing.T) {
+	for _, instance := range []int{0, 1, 41, 1 << 20} {
+		id := EncodePickID(instance)
+		if id == NoPickID {
+			t.Errorf("instance %d encodes to the background ID", instance)
+		}
+		if got := DecodePickID(id); got != instance {
+			t.Errorf("instance %d decodes as %d", instance, got)
+		}
+	}
+	if got := DecodePickID(NoPickID); got != -1 {
+		t.Errorf("background decodes as %d, want -1", got)
+	}
+}
+
+// fakeTarget is a picking buffer in memory, rows bottom up like GL
+type fakeTarget struct {
+	width, height int
+	ids           []uint32
+}
+
+func (f *fakeTarget) Size() (int, int) { return f.width, f.height }
+
+func (f *fakeTarget) ReadIDs(x, y, width, height int) []uint32 {
+	var out []uint32
+	for row := y; row < y+height; row++ {
+		out = append(out, f.ids[row*f.width+x:row*f.width+x+width]...)
+	}
+	return out
+}
+
+// newFakeTarget builds a target from rows given top down as on screen,
+// -1 is background
+func newFakeTarget(rows [][]int) *fakeTarget {
+	f := &fakeTarget{width: len(rows[0]), height: len(rows)}
+	for i := len(rows) - 1; i >= 0; i-- {
+		for _, instance := range rows[i] {
+			id := uint32(NoPickID)
+			if instance >= 0 {
+				id = EncodePickID(instance)
+			}
+			f.ids = append(f.ids, id)
+		}
+	}
+	return f
+}
+
+func TestPickRectangle(t *testing.T) {
+	target := newFakeTarget([][]int{
+		{-1, -1, 2, 2},
+		{0, 0, 2, -1},
+		{0, 5, -1, -1},
+		{-1, -1, -1, 3},
+	})
+	tests := []struct {
+		name string
+		rect PickRect
+		want []int
+	}{
+		{"single pixel", PickRect{2, 0, 2, 0}, []int{2}},
+		{"background pixel", PickRect{0, 0, 0, 0}, []int{}},
+		{"block", PickRect{0, 1, 1, 2}, []int{0, 5}},
+		{"dragged backwards", PickRect{1, 2, 0, 1}, []int{0, 5}},
+		{"whole target", PickRect{0, 0, 3, 3}, []int{0, 2, 3, 5}},
+		{"clamped", PickRect{-10, 2, 10, 10}, []int{0, 3, 5}},
+		{"outside", PickRect{10, 10, 20, 20}, nil},
+	}
+	for _, tt := range tests {
+		if got := PickRectangle(target, tt.rect); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := PickPixel(target, 3, 3); got != 3 {
+		t.Errorf("PickPixel bottom right got %d, want 3", got)
+	}
+	if got := PickPixel(target, 0, 3); got != -1 {
+		t.Errorf("PickPixel on background got %d, want -1", got)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+type Ray struct {
+	Origin    mgl32.Vec3
+	Direction mgl32.Vec3
+}
+
+func (r Ray) At(t float32) mgl32.Vec3 {
+	return r.Origin.Add(r.Direction.Mul(t))
+}
+
+// Transform moves the ray into another space. The direction is not
+// renormalised so hit distances stay comparable with the original ray.
+func (r Ray) Transform(m mgl32.Mat4) Ray {
+	return Ray{
+		Origin:    m.Mul4x1(r.Origin.Vec4(1)).Vec3(),
+		Direction: m.Mul4x1(r.Direction.Vec4(0)).Vec3(),
+	}
+}
+
+// ScreenRay turns a cursor position in window coordinates (origin top left,
+// as reported by glfw) into a world space ray through the view frustum.
+func ScreenRay(xpos, ypos float64, width, height int, view, projection mgl32.Mat4) Ray {
+	ndcX := float32(2*xpos/float64(width) - 1)
+	ndcY := float32(1 - 2*ypos/float64(height))
+
+	inv := projection.Mul4(view).Inv()
+	near := inv.Mul4x1(mgl32.Vec4{ndcX, ndcY, -1, 1})
+	far := inv.Mul4x1(mgl32.Vec4{ndcX, ndcY, 1, 1})
+	nearW := near.Vec3().Mul(1 / near.W())
+	farW := far.Vec3().Mul(1 / far.W())
+
+	return Ray{Origin: nearW, Direction: farW.Sub(nearW).Normalize()}
+}
+
+// RayAABB returns the distance to the nearest intersection with the box, or
+// the exit distance when the ray starts inside it.
+func RayAABB(r Ray, min, max mgl32.Vec3) (float32, bool) {
+	tmin := float32(math.Inf(-1))
+	tmax := float32(math.Inf(1))
+	for i := 0; i < 3; i++ {
+		if r.Direction[i] == 0 {
+			if r.Origin[i] < min[i] || r.Origin[i] > max[i] {
+				return 0, false
+			}
+			continue
+		}
+		inv := 1 / r.Direction[i]
+		t1 := (min[i] - r.Origin[i]) * inv
+		t2 := (max[i] - r.Origin[i]) * inv
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		if t1 > tmin {
+			tmin = t1
+		}
+		if t2 < tmax {
+			tmax = t2
+		}
+		if tmin > tmax {
+			return 0, false
+		}
+	}
+	if tmax < 0 {
+		return 0, false
+	}
+	if tmin < 0 {
+		return tmax, true
+	}
+	return tmin, true
+}
+
+func insideAABB(p, min, max mgl32.Vec3) bool {
+	for i := 0; i < 3; i++ {
+		if p[i] < min[i] || p[i] > max[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func RaySphere(r Ray, center mgl32.Vec3, radius float32) (float32, bool) {
+	oc := r.Origin.Sub(center)
+	a := r.Direction.Dot(r.Direction)
+	b := oc.Dot(r.Direction)
+	c := oc.Dot(oc) - radius*radius
+	disc := b*b - a*c
+	if disc < 0 || a == 0 {
+		return 0, false
+	}
+	sq := float32(math.Sqrt(float64(disc)))
+	t := (-b - sq) / a
+	if t < 0 {
+		t = (-b + sq) / a
+	}
+	if t < 0 {
+		return 0, false
+	}
+	return t, true
+}
+
+// RayTriangle is the Möller–Trumbore test, it returns the distance along the
+// ray and the barycentric coordinates of the hit.
+func RayTriangle(r Ray, v0, v1, v2 mgl32.Vec3) (t, u, v float32, ok bool) {
+	const epsilon = 1e-7
+	e1 := v1.Sub(v0)
+	e2 := v2.Sub(v0)
+	p := r.Direction.Cross(e2)
+	det := e1.Dot(p)
+	if det > -epsilon && det < epsilon {
+		return 0, 0, 0, false
+	}
+	invDet := 1 / det
+	s := r.Origin.Sub(v0)
+	u = s.Dot(p) * invDet
+	if u < 0 || u > 1 {
+		return 0, 0, 0, false
+	}
+	q := s.Cross(e1)
+	v = r.Direction.Dot(q) * invDet
+	if v < 0 || u+v > 1 {
+		return 0, 0, 0, false
+	}
+	t = e2.Dot(q) * invDet
+	if t < 0 {
+		return 0, 0, 0, false
+	}
+	return t, u, v, true
+}
+
+// PickMesh is the CPU side copy of a mesh used for ray tests
+type PickMesh struct {
+	Triangles [][3]mgl32.Vec3
+	Min       mgl32.Vec3
+	Max       mgl32.Vec3
+}
+
+// NewPickMesh reads triangle positions from interleaved vertex data where
+// the position is the first three floats of every vertex.
+func NewPickMesh(vertices []float32, stride int) *PickMesh {
+	m := &PickMesh{}
+	count := len(vertices) / stride
+	for i := 0; i+2 < count; i += 3 {
+		var tri [3]mgl32.Vec3
+		for j := 0; j < 3; j++ {
+			o := (i + j) * stride
+			tri[j] = mgl32.Vec3{vertices[o], vertices[o+1], vertices[o+2]}
+			if i == 0 && j == 0 {
+				m.Min, m.Max = tri[j], tri[j]
+			}
+			for k := 0; k < 3; k++ {
+				m.Min[k] = float32(math.Min(float64(m.Min[k]), float64(tri[j][k])))
+				m.Max[k] = float32(math.Max(float64(m.Max[k]), float64(tri[j][k])))
+			}
+		}
+		m.Triangles = append(m.Triangles, tri)
+	}
+	return m
+}
+
+type PickHit struct {
+	Instance int
+	Triangle int
+	Distance float32
+	Point    mgl32.Vec3
+}
+
+// Pick finds the nearest triangle hit by the ray over all instances of the
+// mesh, each instance being placed by its model matrix.
+func (m *PickMesh) Pick(r Ray, models []mgl32.Mat4) (PickHit, bool) {
+	best := PickHit{Instance: -1, Triangle: -1, Distance: float32(math.Inf(1))}
+	for i, model := range models {
+		local := r.Transform(model.Inv())
+		// the box can only hold a nearer hit if the ray enters it first, a
+		// ray starting inside enters at 0 rather than where it leaves
+		t, ok := RayAABB(local, m.Min, m.Max)
+		if ok && insideAABB(local.Origin, m.Min, m.Max) {
+			t = 0
+		}
+		if !ok || t > best.Distance {
+			continue
+		}
+		for j, tri := range m.Triangles {
+			t, _, _, ok := RayTriangle(local, tri[0], tri[1], tri[2])
+			if ok && t < best.Distance {
+				best = PickHit{Instance: i, Triangle: j, Distance: t, Point: r.At(t)}
+			}
+		}
+	}
+	return best, best.Instance >= 0
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func near(a, b float32) bool {
+	return math.Abs(float64(a-b)) < 1e-4
+}
+
+func TestRayAABB(t *testing.T) {
+	min, max := mgl32.Vec3{-1, -1, -1}, mgl32.Vec3{1, 1, 1}
+	tests := []struct {
+		name string
+		ray  Ray
+		t    float32
+		ok   bool
+	}{
+		{"in front", Ray{mgl32.Vec3{0, 0, 5}, mgl32.Vec3{0, 0, -1}}, 4, true},
+		{"inside gives the exit", Ray{mgl32.Vec3{0, 0, 0.5}, mgl32.Vec3{0, 0, -1}}, 1.5, true},
+		{"behind", Ray{mgl32.Vec3{0, 0, 5}, mgl32.Vec3{0, 0, 1}}, 0, false},
+		{"beside", Ray{mgl32.Vec3{2, 0, 5}, mgl32.Vec3{0, 0, -1}}, 0, false},
+		{"diagonal", Ray{mgl32.Vec3{3, 3, 0}, mgl32.Vec3{-1, -1, 0}.Normalize()}, 2 * float32(math.Sqrt2), true},
+	}
+	for _, tt := range tests {
+		got, ok := RayAABB(tt.ray, min, max)
+		if ok != tt.ok || (ok && !near(got, tt.t)) {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, got, ok, tt.t, tt.ok)
+		}
+	}
+}
+
+func TestRaySphere(t *testing.T) {
+	tests := []struct {
+		name string
+		ray  Ray
+		t    float32
+		ok   bool
+	}{
+		{"in front", Ray{mgl32.Vec3{0, 0, 5}, mgl32.Vec3{0, 0, -1}}, 4, true},
+		{"inside", Ray{mgl32.Vec3{0, 0, 0}, mgl32.Vec3{1, 0, 0}}, 1, true},
+		{"behind", Ray{mgl32.Vec3{0, 0, 5}, mgl32.Vec3{0, 0, 1}}, 0, false},
+		{"miss", Ray{mgl32.Vec3{0, 2, 5}, mgl32.Vec3{0, 0, -1}}, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := RaySphere(tt.ray, mgl32.Vec3{}, 1)
+		if ok != tt.ok || (ok && !near(got, tt.t)) {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, got, ok, tt.t, tt.ok)
+		}
+	}
+}
+
+func TestRayTriangle(t *testing.T) {
+	v0, v1, v2 := mgl32.Vec3{0, 0, 0}, mgl32.Vec3{1, 0, 0}, mgl32.Vec3{0, 1, 0}
+	tt, u, v, ok := RayTriangle(Ray{mgl32.Vec3{0.25, 0.5, 2}, mgl32.Vec3{0, 0, -1}}, v0, v1, v2)
+	if !ok || !near(tt, 2) || !near(u, 0.25) || !near(v, 0.5) {
+		t.Errorf("hit gave t %v u %v v %v ok %v, want 2, 0.25, 0.5", tt, u, v, ok)
+	}
+	misses := []Ray{
+		{mgl32.Vec3{0.75, 0.75, 2}, mgl32.Vec3{0, 0, -1}}, // outside the hypotenuse
+		{mgl32.Vec3{0.25, 0.25, 2}, mgl32.Vec3{0, 0, 1}},  // pointing away
+		{mgl32.Vec3{0.25, 0.25, 2}, mgl32.Vec3{1, 0, 0}},  // parallel
+	}
+	for _, r := range misses {
+		if _, _, _, ok := RayTriangle(r, v0, v1, v2); ok {
+			t.Errorf("ray %v hit", r)
+		}
+	}
+}
+
+// unit cube as 12 triangles, positions only
+func testCubeMesh() *PickMesh {
+	var verts []float32
+	quad := func(a, b, c, d mgl32.Vec3) {
+		for _, p := range []mgl32.Vec3{a, b, c, a, c, d} {
+			verts = append(verts, p[0], p[1], p[2])
+		}
+	}
+	for axis := 0; axis < 3; axis++ {
+		for _, s := range []float32{-0.5, 0.5} {
+			var q [4]mgl32.Vec3
+			for i, uv := range [][2]float32{{-0.5, -0.5}, {0.5, -0.5}, {0.5, 0.5}, {-0.5, 0.5}} {
+				q[i][axis] = s
+				q[i][(axis+1)%3] = uv[0]
+				q[i][(axis+2)%3] = uv[1]
+			}
+			quad(q[0], q[1], q[2], q[3])
+		}
+	}
+	return NewPickMesh(verts, 3)
+}
+
+func TestPick(t *testing.T) {
+	m := testCubeMesh()
+	models := []mgl32.Mat4{mgl32.Translate3D(0, 0, -5), mgl32.Translate3D(0, 0, -2)}
+	hit, ok := m.Pick(Ray{mgl32.Vec3{0, 0, 0}, mgl32.Vec3{0, 0, -1}}, models)
+	if !ok || hit.Instance != 1 || !near(hit.Distance, 1.5) {
+		t.Errorf("got %+v, %v, want instance 1 at 1.5", hit, ok)
+	}
+	if _, ok := m.Pick(Ray{mgl32.Vec3{3, 0, 0}, mgl32.Vec3{0, 0, -1}}, models); ok {
+		t.Error("ray beside the cubes hit")
+	}
+}
+
+// A ray starting inside an instance's box has to test its triangles even
+// when the box's exit is further than the best hit so far
+func TestPickFromInside(t *testing.T) {
+	m := NewPickMesh([]float32{
+		0, 0, -1, 1, 0, -1, 0, 1, -1, // near, the true hit
+		-5, -5, -5, 5, -5, -5, 0, 5, -5, // far
+		10, 0, 1, 11, 0, 1, 10, 1, 1, // behind the ray, stretches the box around it
+	}, 3)
+	models := []mgl32.Mat4{mgl32.Translate3D(0, 0, -1.5), mgl32.Ident4()}
+	hit, ok := m.Pick(Ray{mgl32.Vec3{0.2, 0.2, 0}, mgl32.Vec3{0, 0, -1}}, models)
+	if !ok || hit.Instance != 1 || hit.Triangle != 0 || !near(hit.Distance, 1) {
+		t.Errorf("got %+v, %v, want instance 1 triangle 0 at 1", hit, ok)
+	}
+}
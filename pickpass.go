@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// PickingPass renders instance IDs into an offscreen R32UI framebuffer
+type PickingPass struct {
+	Program *ShaderProgram
+
+	// State, when set, binds the framebuffer so the cache knows about it
+	State *StateCache
+
+	fbo     uint32
+	idTex   uint32
+	depthRB uint32
+	width   int
+	height  int
+}
+
+func NewPickingPass(fsys fs.FS, width, height int) (*PickingPass, error) {
+	prog, err := NewShaderProgram(fsys, "shaders/pick.vert", "shaders/pick.frag")
+	if err != nil {
+		return nil, err
+	}
+	p := &PickingPass{Program: prog, width: width, height: height}
+
+	gl.GenFramebuffers(1, &p.fbo)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, p.fbo)
+
+	gl.GenTextures(1, &p.idTex)
+	gl.BindTexture(gl.TEXTURE_2D, p.idTex)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.R32UI, int32(width), int32(height), 0, gl.RED_INTEGER, gl.UNSIGNED_INT, nil)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, p.idTex, 0)
+
+	gl.GenRenderbuffers(1, &p.depthRB)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, p.depthRB)
+	gl.RenderbufferStorage(gl.RENDERBUFFER, gl.DEPTH_COMPONENT24, int32(width), int32(height))
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, p.depthRB)
+
+	status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	if status != gl.FRAMEBUFFER_COMPLETE {
+		p.Delete()
+		return nil, fmt.Errorf("picking framebuffer incomplete: 0x%x", status)
+	}
+	return p, nil
+}
+
+// Begin binds the picking framebuffer and clears it to NoPickID
+func (p *PickingPass) Begin() {
+	p.State.BindFramebuffer(gl.FRAMEBUFFER, p.fbo)
+	gl.Viewport(0, 0, int32(p.width), int32(p.height))
+	clear := []uint32{NoPickID, 0, 0, 0}
+	gl.ClearBufferuiv(gl.COLOR, 0, &clear[0])
+	gl.Clear(gl.DEPTH_BUFFER_BIT)
+}
+
+// SetID sets the ID for the next draw, program must declare "uniform uint pickID"
+func (p *PickingPass) SetID(program *ShaderProgram, instance int) error {
+	return program.SetUint("pickID", EncodePickID(instance))
+}
+
+func (p *PickingPass) End() {
+	p.State.BindFramebuffer(gl.FRAMEBUFFER, 0)
+}
+
+func (p *PickingPass) Size() (int, int) {
+	return p.width, p.height
+}
+
+func (p *PickingPass) ReadIDs(x, y, width, height int) []uint32 {
+	ids := make([]uint32, width*height)
+	p.State.BindFramebuffer(gl.READ_FRAMEBUFFER, p.fbo)
+	gl.ReadBuffer(gl.COLOR_ATTACHMENT0)
+	gl.ReadPixels(int32(x), int32(y), int32(width), int32(height), gl.RED_INTEGER, gl.UNSIGNED_INT, gl.Ptr(ids))
+	p.State.BindFramebuffer(gl.READ_FRAMEBUFFER, 0)
+	return ids
+}
+
+func (p *PickingPass) Delete() {
+	gl.DeleteFramebuffers(1, &p.fbo)
+	gl.DeleteTextures(1, &p.idTex)
+	gl.DeleteRenderbuffers(1, &p.depthRB)
+	p.Program.Delete()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// programCacheVersion is part of every key and file header, bump it when the
+// key or the file format changes so old entries are ignored.
+const programCacheVersion = 1
+
+var programCacheMagic = [4]byte{'G', 'L', 'P', 'B'}
+
+// DriverInfo identifies the driver a program binary was produced by, a binary
+// is only valid for the exact same vendor, renderer and version.
+type DriverInfo struct {
+	Vendor   string
+	Renderer string
+	Version  string
+}
+
+// ProgramCacheKey hashes everything that affects a linked program: the
+// driver, the stages and their preprocessed source, and the defines.
+func ProgramCacheKey(driver DriverInfo, stages []uint32, sources []string, defines ShaderDefines) string {
+	h := sha256.New()
+	write := func(s string) {
+		var n [8]byte
+		binary.LittleEndian.PutUint64(n[:], uint64(len(s)))
+		h.Write(n[:])
+		h.Write([]byte(s))
+	}
+	write(fmt.Sprint(programCacheVersion))
+	write(driver.Vendor)
+	write(driver.Renderer)
+	write(driver.Version)
+	write(defines.Key())
+	for i, stage := range stages {
+		write(fmt.Sprint(stage))
+		write(sources[i])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ProgramBinaryCache stores program binaries as files named by their key
+type ProgramBinaryCache struct {
+	Dir string
+}
+
+// DefaultProgramCacheDir is inside the user's cache directory
+func DefaultProgramCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "stumct-opengl", "programs")
+	}
+	return filepath.Join(dir, "stumct-opengl", "programs")
+}
+
+func (c *ProgramBinaryCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".bin")
+}
+
+// Load returns the binary format and data stored for key. Missing, truncated
+// or foreign files are reported as a miss and removed.
+func (c *ProgramBinaryCache) Load(key string) (uint32, []byte, bool) {
+	b, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return 0, nil, false
+	}
+	format, data, err := decodeProgramBinary(b)
+	if err != nil {
+		c.Invalidate(key)
+		return 0, nil, false
+	}
+	return format, data, true
+}
+
+// Use hands the binary stored for key to accept, which tries it on the
+// driver. An entry accept turns down is removed so it isn't tried again.
+func (c *ProgramBinaryCache) Use(key string, accept func(format uint32, data []byte) bool) bool {
+	format, data, ok := c.Load(key)
+	if !ok {
+		return false
+	}
+	if !accept(format, data) {
+		c.Invalidate(key)
+		return false
+	}
+	return true
+}
+
+func (c *ProgramBinaryCache) Store(key string, format uint32, data []byte) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	// write then rename so a crash never leaves a half written entry
+	tmp := c.path(key) + ".tmp"
+	if err := ioutil.WriteFile(tmp, encodeProgramBinary(format, data), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path(key))
+}
+
+// Invalidate removes an entry, used when the driver rejects a binary
+func (c *ProgramBinaryCache) Invalidate(key string) {
+	os.Remove(c.path(key))
+}
+
+// header: magic, cache version, binary format, data length
+const programBinaryHeaderSize = 16
+
+func encodeProgramBinary(format uint32, data []byte) []byte {
+	b := make([]byte, programBinaryHeaderSize+len(data))
+	copy(b, programCacheMagic[:])
+	binary.LittleEndian.PutUint32(b[4:], programCacheVersion)
+	binary.LittleEndian.PutUint32(b[8:], format)
+	binary.LittleEndian.PutUint32(b[12:], uint32(len(data)))
+	copy(b[programBinaryHeaderSize:], data)
+	return b
+}
+
+func decodeProgramBinary(b []byte) (uint32, []byte, error) {
+	if len(b) < programBinaryHeaderSize {
+		return 0, nil, fmt.Errorf("program binary too short")
+	}
+	if string(b[:4]) != string(programCacheMagic[:]) {
+		return 0, nil, fmt.Errorf("not a program binary")
+	}
+	if v := binary.LittleEndian.Uint32(b[4:]); v != programCacheVersion {
+		return 0, nil, fmt.Errorf("program binary version %d, want %d", v, programCacheVersion)
+	}
+	format := binary.LittleEndian.Uint32(b[8:])
+	length := binary.LittleEndian.Uint32(b[12:])
+	if int(length) != len(b)-programBinaryHeaderSize {
+		return 0, nil, fmt.Errorf("program binary truncated")
+	}
+	return format, b[programBinaryHeaderSize:], nil
+}
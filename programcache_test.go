@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestProgramCacheKey(t *testing.T) {
+	driver := DriverInfo{Vendor: "Mesa", Renderer: "llvmpipe", Version: "3.3 (Core Profile) Mesa 21.0.3"}
+	stages := []uint32{1, 2}
+	sources := []string{"void main() {}", "out vec4 c; void main() {}"}
+	defines := ShaderDefines{"A": "1"}
+	base := ProgramCacheKey(driver, stages, sources, defines)
+	if again := ProgramCacheKey(driver, stages, []string{sources[0], sources[1]}, ShaderDefines{"A": "1"}); again != base {
+		t.Error("same inputs gave different keys")
+	}
+
+	other := driver
+	other.Vendor = "NVIDIA Corporation"
+	newer := driver
+	newer.Version = "3.3 (Core Profile) Mesa 21.0.4"
+	renderer := driver
+	renderer.Renderer = "AMD Radeon"
+	changes := map[string]string{
+		"vendor":       ProgramCacheKey(other, stages, sources, defines),
+		"renderer":     ProgramCacheKey(renderer, stages, sources, defines),
+		"version":      ProgramCacheKey(newer, stages, sources, defines),
+		"define value": ProgramCacheKey(driver, stages, sources, ShaderDefines{"A": "2"}),
+		"no defines":   ProgramCacheKey(driver, stages, sources, nil),
+		"source":       ProgramCacheKey(driver, stages, []string{sources[0], sources[1] + " "}, defines),
+		"stage":        ProgramCacheKey(driver, []uint32{1, 3}, sources, defines),
+		// lengths are hashed so moving text between sources changes the key
+		"split": ProgramCacheKey(driver, stages, []string{sources[0] + "o", sources[1][1:]}, defines),
+	}
+	for what, key := range changes {
+		if key == base {
+			t.Errorf("changing the %s kept the key", what)
+		}
+	}
+}
+
+func TestProgramBinaryCache(t *testing.T) {
+	c := &ProgramBinaryCache{Dir: t.TempDir()}
+	if _, _, ok := c.Load("missing"); ok {
+		t.Error("missing entry loaded")
+	}
+	if err := c.Store("k", 0x8740, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	format, data, ok := c.Load("k")
+	if !ok || format != 0x8740 || string(data) != "\x01\x02\x03" {
+		t.Errorf("got %x %v %v, want the stored binary", format, data, ok)
+	}
+
+	if !c.Use("k", func(uint32, []byte) bool { return true }) {
+		t.Error("accepted binary reported as unused")
+	}
+	if _, err := os.Stat(c.path("k")); err != nil {
+		t.Errorf("accepted binary was removed: %v", err)
+	}
+	if c.Use("k", func(uint32, []byte) bool { return false }) {
+		t.Error("rejected binary reported as used")
+	}
+	if _, err := os.Stat(c.path("k")); !os.IsNotExist(err) {
+		t.Errorf("rejected binary is still there: %v", err)
+	}
+}
+
+func TestProgramBinaryCacheCorrupt(t *testing.T) {
+	c := &ProgramBinaryCache{Dir: t.TempDir()}
+	good := encodeProgramBinary(1, []byte("binary"))
+	corrupt := map[string][]byte{
+		"short":     good[:10],
+		"truncated": good[:len(good)-1],
+		"magic":     append([]byte("XXXX"), good[4:]...),
+		"version":   append(append([]byte{}, good[:4]...), append([]byte{99, 0, 0, 0}, good[8:]...)...),
+	}
+	for name, b := range corrupt {
+		if err := ioutil.WriteFile(c.path(name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, ok := c.Load(name); ok {
+			t.Errorf("%s: loaded", name)
+		}
+		if _, err := os.Stat(c.path(name)); !os.IsNotExist(err) {
+			t.Errorf("%s: file not removed", name)
+		}
+	}
+}
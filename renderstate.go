@@ -0,0 +1,475 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// Blend modes
+const (
+	BlendOpaque        = "opaque"
+	BlendAlpha         = "alpha"
+	BlendPremultiplied = "premultiplied"
+	BlendAdditive      = "additive"
+)
+
+// Face culling modes
+const (
+	CullNone  = "none"
+	CullBack  = "back"
+	CullFront = "front"
+)
+
+// Polygon modes
+const (
+	PolygonFill  = "fill"
+	PolygonLine  = "line"
+	PolygonPoint = "point"
+)
+
+// blendFuncs are the source and destination factors of each blend mode
+var blendFuncs = map[string][2]uint32{
+	BlendAlpha:         {gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA},
+	BlendPremultiplied: {gl.ONE, gl.ONE_MINUS_SRC_ALPHA},
+	BlendAdditive:      {gl.SRC_ALPHA, gl.ONE},
+}
+
+var cullFaces = map[string]uint32{
+	CullBack:  gl.BACK,
+	CullFront: gl.FRONT,
+}
+
+// compareFuncs are the depth and stencil tests
+var compareFuncs = map[string]uint32{
+	"never":    gl.NEVER,
+	"less":     gl.LESS,
+	"equal":    gl.EQUAL,
+	"lequal":   gl.LEQUAL,
+	"greater":  gl.GREATER,
+	"notequal": gl.NOTEQUAL,
+	"gequal":   gl.GEQUAL,
+	"always":   gl.ALWAYS,
+}
+
+var stencilOps = map[string]uint32{
+	"keep":     gl.KEEP,
+	"zero":     gl.ZERO,
+	"replace":  gl.REPLACE,
+	"incr":     gl.INCR,
+	"incrWrap": gl.INCR_WRAP,
+	"decr":     gl.DECR,
+	"decrWrap": gl.DECR_WRAP,
+	"invert":   gl.INVERT,
+}
+
+var polygonModes = map[string]uint32{
+	PolygonFill:  gl.FILL,
+	PolygonLine:  gl.LINE,
+	PolygonPoint: gl.POINT,
+}
+
+// RenderState is the fixed function state a draw needs. Empty strings
+// mean the default, so a partly filled in state is still valid.
+type RenderState struct {
+	Blend       string `json:"blend"`
+	Cull        string `json:"cull"`
+	DepthTest   bool   `json:"depthTest"`
+	DepthWrite  bool   `json:"depthWrite"`
+	DepthFunc   string `json:"depthFunc"`
+	PolygonMode string `json:"polygonMode"`
+
+	// Scissor is x, y, width and height in pixels, nil draws everywhere
+	Scissor *[4]int32     `json:"scissor,omitempty"`
+	Stencil *StencilState `json:"stencil,omitempty"`
+}
+
+// StencilState turns on the stencil test, ops are keep, zero, replace,
+// incr, incrWrap, decr, decrWrap or invert. Masks left out of a file are
+// 0xFF like GL's own defaults, a zero mask would test and write nothing.
+type StencilState struct {
+	Func      string `json:"func"`
+	Ref       int32  `json:"ref"`
+	ReadMask  uint32 `json:"readMask"`
+	WriteMask uint32 `json:"writeMask"`
+	Fail      string `json:"fail"`
+	DepthFail string `json:"depthFail"`
+	Pass      string `json:"pass"`
+}
+
+func (s *StencilState) UnmarshalJSON(b []byte) error {
+	type plain StencilState
+	p := plain{ReadMask: 0xFF, WriteMask: 0xFF}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*s = StencilState(p)
+	return nil
+}
+
+// DefaultRenderState draws opaque with depth testing and no culling, the
+// state the game has always drawn in
+func DefaultRenderState() RenderState {
+	return RenderState{
+		Blend:       BlendOpaque,
+		Cull:        CullNone,
+		DepthTest:   true,
+		DepthWrite:  true,
+		DepthFunc:   "less",
+		PolygonMode: PolygonFill,
+	}
+}
+
+func (s RenderState) Validate() error {
+	if _, ok := blendFuncs[s.Blend]; !ok && s.Blend != BlendOpaque && s.Blend != "" {
+		return fmt.Errorf("unknown blend mode %q", s.Blend)
+	}
+	if _, ok := cullFaces[s.Cull]; !ok && s.Cull != CullNone && s.Cull != "" {
+		return fmt.Errorf("unknown cull mode %q", s.Cull)
+	}
+	if _, ok := compareFuncs[s.DepthFunc]; !ok && s.DepthFunc != "" {
+		return fmt.Errorf("unknown depth func %q", s.DepthFunc)
+	}
+	if _, ok := polygonModes[s.PolygonMode]; !ok && s.PolygonMode != "" {
+		return fmt.Errorf("unknown polygon mode %q", s.PolygonMode)
+	}
+	if s.Scissor != nil && (s.Scissor[2] < 0 || s.Scissor[3] < 0) {
+		return fmt.Errorf("scissor %v has a negative size", *s.Scissor)
+	}
+	if st := s.Stencil; st != nil {
+		if _, ok := compareFuncs[st.Func]; !ok && st.Func != "" {
+			return fmt.Errorf("unknown stencil func %q", st.Func)
+		}
+		for _, op := range []string{st.Fail, st.DepthFail, st.Pass} {
+			if _, ok := stencilOps[op]; !ok && op != "" {
+				return fmt.Errorf("unknown stencil op %q", op)
+			}
+		}
+	}
+	return nil
+}
+
+// lookupGL returns the GL value of name, or def for an empty or unknown name
+func lookupGL(values map[string]uint32, name string, def uint32) uint32 {
+	if v, ok := values[name]; ok {
+		return v
+	}
+	return def
+}
+
+// stateWords holds any cached setting packed into four words, so storing
+// and comparing one doesn't allocate
+type stateWords [4]uint32
+
+// cachedState is one remembered setting, unknown until valid
+type cachedState struct {
+	valid bool
+	v     stateWords
+}
+
+type textureSlot struct {
+	unit, target uint32
+}
+
+func boolWord(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// StateStats counts state changes asked of a StateCache: Issued reached
+// GL, Filtered were dropped because the value was already set.
+type StateStats struct {
+	Issued   int
+	Filtered int
+}
+
+func (s StateStats) String() string {
+	return fmt.Sprintf("%d state calls issued, %d filtered", s.Issued, s.Filtered)
+}
+
+// StateCache remembers what has been set on the GL context and drops calls
+// that would set the same value again. It only knows what went through it,
+// so after anything else binds or deletes GL objects it must be told with
+// Invalidate. A nil *StateCache issues every call.
+type StateCache struct {
+	program, vao, activeTexture       cachedState
+	drawFramebuffer, readFramebuffer  cachedState
+	blendFunc, cullFace               cachedState
+	depthFunc, depthMask, polygonMode cachedState
+	scissor                           cachedState
+	stencilFunc, stencilOp            cachedState
+	stencilMask                       cachedState
+
+	// the maps keep their entries across Invalidate so a frame doesn't
+	// allocate once every key has been seen
+	buffers  map[uint32]*cachedState // by target
+	textures map[textureSlot]*cachedState
+	enabled  map[uint32]*cachedState // by capability
+
+	frame StateStats
+	last  StateStats
+}
+
+func NewStateCache() *StateCache {
+	return &StateCache{
+		buffers:  map[uint32]*cachedState{},
+		textures: map[textureSlot]*cachedState{},
+		enabled:  map[uint32]*cachedState{},
+	}
+}
+
+// update stores v and reports whether it changed, counting the call. The
+// caller issues the GL call when it did.
+func (c *StateCache) update(s *cachedState, v stateWords) bool {
+	if s.valid && s.v == v {
+		c.frame.Filtered++
+		return false
+	}
+	s.valid, s.v = true, v
+	c.frame.Issued++
+	return true
+}
+
+// entry returns the cached state for key in m, adding it the first time
+func entry(m map[uint32]*cachedState, key uint32) *cachedState {
+	s, ok := m[key]
+	if !ok {
+		s = &cachedState{}
+		m[key] = s
+	}
+	return s
+}
+
+// Invalidate forgets everything, the next call of each kind is issued
+func (c *StateCache) Invalidate() {
+	if c == nil {
+		return
+	}
+	for _, s := range []*cachedState{
+		&c.program, &c.vao, &c.activeTexture, &c.drawFramebuffer, &c.readFramebuffer,
+		&c.blendFunc, &c.cullFace, &c.depthFunc, &c.depthMask, &c.polygonMode,
+		&c.scissor, &c.stencilFunc, &c.stencilOp, &c.stencilMask,
+	} {
+		s.valid = false
+	}
+	for _, s := range c.buffers {
+		s.valid = false
+	}
+	for _, s := range c.textures {
+		s.valid = false
+	}
+	for _, s := range c.enabled {
+		s.valid = false
+	}
+}
+
+// BeginFrame keeps the counts of the frame just drawn and starts new ones.
+// It also invalidates, since uploads, reloads and deletes between frames
+// bind objects behind the cache's back.
+func (c *StateCache) BeginFrame() {
+	if c == nil {
+		return
+	}
+	c.last, c.frame = c.frame, StateStats{}
+	c.Invalidate()
+}
+
+// Stats are the counts so far this frame
+func (c *StateCache) Stats() StateStats {
+	if c == nil {
+		return StateStats{}
+	}
+	return c.frame
+}
+
+// LastFrame are the counts of the previous frame
+func (c *StateCache) LastFrame() StateStats {
+	if c == nil {
+		return StateStats{}
+	}
+	return c.last
+}
+
+func (c *StateCache) UseProgram(program uint32) {
+	if c == nil || c.update(&c.program, stateWords{program}) {
+		gl.UseProgram(program)
+	}
+}
+
+// BindVertexArray also forgets the element buffer, it is part of the VAO
+func (c *StateCache) BindVertexArray(vao uint32) {
+	if c == nil || c.update(&c.vao, stateWords{vao}) {
+		gl.BindVertexArray(vao)
+	}
+	if c != nil {
+		entry(c.buffers, gl.ELEMENT_ARRAY_BUFFER).valid = false
+	}
+}
+
+func (c *StateCache) BindBuffer(target, buffer uint32) {
+	if c == nil || c.update(entry(c.buffers, target), stateWords{buffer}) {
+		gl.BindBuffer(target, buffer)
+	}
+}
+
+// BindFramebuffer tracks the draw and read bindings apart, gl.FRAMEBUFFER
+// sets both
+func (c *StateCache) BindFramebuffer(target, fbo uint32) {
+	if c == nil {
+		gl.BindFramebuffer(target, fbo)
+		return
+	}
+	v := stateWords{fbo}
+	draw, read := target != gl.READ_FRAMEBUFFER, target != gl.DRAW_FRAMEBUFFER
+	drawSet := !draw || (c.drawFramebuffer.valid && c.drawFramebuffer.v == v)
+	readSet := !read || (c.readFramebuffer.valid && c.readFramebuffer.v == v)
+	if drawSet && readSet {
+		c.frame.Filtered++
+		return
+	}
+	if draw {
+		c.drawFramebuffer = cachedState{true, v}
+	}
+	if read {
+		c.readFramebuffer = cachedState{true, v}
+	}
+	c.frame.Issued++
+	gl.BindFramebuffer(target, fbo)
+}
+
+// ActiveTexture selects unit, counted from 0 rather than gl.TEXTURE0
+func (c *StateCache) ActiveTexture(unit uint32) {
+	if c == nil || c.update(&c.activeTexture, stateWords{unit}) {
+		gl.ActiveTexture(gl.TEXTURE0 + unit)
+	}
+}
+
+// BindTexture binds texture to target on unit, switching the active unit
+// only when the binding changes
+func (c *StateCache) BindTexture(unit, target, texture uint32) {
+	if c == nil {
+		gl.ActiveTexture(gl.TEXTURE0 + unit)
+		gl.BindTexture(target, texture)
+		return
+	}
+	slot := textureSlot{unit, target}
+	s, ok := c.textures[slot]
+	if !ok {
+		s = &cachedState{}
+		c.textures[slot] = s
+	}
+	if s.valid && s.v == (stateWords{texture}) {
+		c.frame.Filtered++
+		return
+	}
+	c.ActiveTexture(unit)
+	c.update(s, stateWords{texture})
+	gl.BindTexture(target, texture)
+}
+
+// SetEnabled enables or disables a capability such as gl.BLEND
+func (c *StateCache) SetEnabled(capability uint32, enabled bool) {
+	if c != nil && !c.update(entry(c.enabled, capability), stateWords{boolWord(enabled)}) {
+		return
+	}
+	if enabled {
+		gl.Enable(capability)
+	} else {
+		gl.Disable(capability)
+	}
+}
+
+func (c *StateCache) BlendFunc(src, dst uint32) {
+	if c == nil || c.update(&c.blendFunc, stateWords{src, dst}) {
+		gl.BlendFunc(src, dst)
+	}
+}
+
+func (c *StateCache) CullFace(face uint32) {
+	if c == nil || c.update(&c.cullFace, stateWords{face}) {
+		gl.CullFace(face)
+	}
+}
+
+func (c *StateCache) DepthFunc(f uint32) {
+	if c == nil || c.update(&c.depthFunc, stateWords{f}) {
+		gl.DepthFunc(f)
+	}
+}
+
+func (c *StateCache) DepthMask(write bool) {
+	if c == nil || c.update(&c.depthMask, stateWords{boolWord(write)}) {
+		gl.DepthMask(write)
+	}
+}
+
+func (c *StateCache) PolygonMode(mode uint32) {
+	if c == nil || c.update(&c.polygonMode, stateWords{mode}) {
+		gl.PolygonMode(gl.FRONT_AND_BACK, mode)
+	}
+}
+
+func (c *StateCache) Scissor(x, y, width, height int32) {
+	if c == nil || c.update(&c.scissor, stateWords{uint32(x), uint32(y), uint32(width), uint32(height)}) {
+		gl.Scissor(x, y, width, height)
+	}
+}
+
+func (c *StateCache) StencilFunc(f uint32, ref int32, mask uint32) {
+	if c == nil || c.update(&c.stencilFunc, stateWords{f, uint32(ref), mask}) {
+		gl.StencilFunc(f, ref, mask)
+	}
+}
+
+func (c *StateCache) StencilOp(fail, depthFail, pass uint32) {
+	if c == nil || c.update(&c.stencilOp, stateWords{fail, depthFail, pass}) {
+		gl.StencilOp(fail, depthFail, pass)
+	}
+}
+
+func (c *StateCache) StencilMask(mask uint32) {
+	if c == nil || c.update(&c.stencilMask, stateWords{mask}) {
+		gl.StencilMask(mask)
+	}
+}
+
+// Apply sets everything a RenderState covers. Parameters of disabled tests
+// are left alone, except the stencil write mask which also masks clears
+// and goes back to 0xFF like depth writes go back on.
+func (c *StateCache) Apply(s RenderState) {
+	f, blend := blendFuncs[s.Blend]
+	c.SetEnabled(gl.BLEND, blend)
+	if blend {
+		c.BlendFunc(f[0], f[1])
+	}
+
+	face, cull := cullFaces[s.Cull]
+	c.SetEnabled(gl.CULL_FACE, cull)
+	if cull {
+		c.CullFace(face)
+	}
+
+	c.SetEnabled(gl.DEPTH_TEST, s.DepthTest)
+	if s.DepthTest {
+		c.DepthFunc(lookupGL(compareFuncs, s.DepthFunc, gl.LESS))
+	}
+	c.DepthMask(s.DepthWrite)
+	c.PolygonMode(lookupGL(polygonModes, s.PolygonMode, gl.FILL))
+
+	c.SetEnabled(gl.SCISSOR_TEST, s.Scissor != nil)
+	if s.Scissor != nil {
+		c.Scissor(s.Scissor[0], s.Scissor[1], s.Scissor[2], s.Scissor[3])
+	}
+
+	c.SetEnabled(gl.STENCIL_TEST, s.Stencil != nil)
+	if st := s.Stencil; st != nil {
+		c.StencilFunc(lookupGL(compareFuncs, st.Func, gl.ALWAYS), st.Ref, st.ReadMask)
+		c.StencilOp(lookupGL(stencilOps, st.Fail, gl.KEEP), lookupGL(stencilOps, st.DepthFail, gl.KEEP), lookupGL(stencilOps, st.Pass, gl.KEEP))
+		c.StencilMask(st.WriteMask)
+	} else {
+		c.StencilMask(0xFF)
+	}
+}
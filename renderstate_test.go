@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+func TestStencilMaskDefaults(t *testing.T) {
+	var s RenderState
+	if err := json.Unmarshal([]byte(`{"stencil": {"func": "equal", "ref": 1}}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Stencil.ReadMask != 0xFF || s.Stencil.WriteMask != 0xFF {
+		t.Errorf("omitted masks are 0x%X and 0x%X, want 0xFF", s.Stencil.ReadMask, s.Stencil.WriteMask)
+	}
+
+	if err := json.Unmarshal([]byte(`{"stencil": {"readMask": 15, "writeMask": 0}}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Stencil.ReadMask != 15 || s.Stencil.WriteMask != 0 {
+		t.Errorf("masks are 0x%X and 0x%X, want the 0xF and 0 given", s.Stencil.ReadMask, s.Stencil.WriteMask)
+	}
+
+	def, err := decodeMaterialFile([]byte("shader: [a.vert]\nstate:\n  stencil:\n    func: always\n"), "m.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.State.Stencil.WriteMask != 0xFF {
+		t.Errorf("YAML stencil write mask is 0x%X, want 0xFF", def.State.Stencil.WriteMask)
+	}
+}
+
+func TestStateCacheUpdate(t *testing.T) {
+	c := NewStateCache()
+	if !c.update(&c.program, stateWords{3}) {
+		t.Error("first program was filtered")
+	}
+	if c.update(&c.program, stateWords{3}) {
+		t.Error("same program was issued again")
+	}
+	if !c.update(&c.program, stateWords{4}) {
+		t.Error("new program was filtered")
+	}
+	if got := c.Stats(); got != (StateStats{Issued: 2, Filtered: 1}) {
+		t.Errorf("stats are %v", got)
+	}
+
+	c.BeginFrame()
+	if !c.update(&c.program, stateWords{4}) {
+		t.Error("program was filtered after the frame invalidated the cache")
+	}
+	if got := c.LastFrame(); got != (StateStats{Issued: 2, Filtered: 1}) {
+		t.Errorf("last frame stats are %v", got)
+	}
+
+	// map entries are kept across frames and only marked unknown
+	s := entry(c.enabled, gl.BLEND)
+	c.update(s, stateWords{1})
+	c.Invalidate()
+	if entry(c.enabled, gl.BLEND) != s || s.valid {
+		t.Error("invalidate didn't keep and reset the blend entry")
+	}
+	if allocs := testing.AllocsPerRun(100, func() {
+		c.update(entry(c.enabled, gl.BLEND), stateWords{1})
+		c.update(&c.scissor, stateWords{1, 2, 3, 4})
+	}); allocs != 0 {
+		t.Errorf("cached updates allocate %v times", allocs)
+	}
+}
+
+func TestStateCacheFramebuffers(t *testing.T) {
+	c := NewStateCache()
+	c.drawFramebuffer = cachedState{true, stateWords{5}}
+	c.readFramebuffer = cachedState{true, stateWords{5}}
+
+	// both already bound, so none of these reach GL
+	c.BindFramebuffer(gl.FRAMEBUFFER, 5)
+	c.BindFramebuffer(gl.DRAW_FRAMEBUFFER, 5)
+	c.BindFramebuffer(gl.READ_FRAMEBUFFER, 5)
+	if got := c.Stats(); got != (StateStats{Filtered: 3}) {
+		t.Errorf("stats are %v, want 3 filtered", got)
+	}
+
+	// a draw only bind ignores the read binding, even when it differs
+	c.readFramebuffer = cachedState{true, stateWords{0}}
+	c.BindFramebuffer(gl.DRAW_FRAMEBUFFER, 5)
+	if got := c.Stats(); got.Filtered != 4 || got.Issued != 0 {
+		t.Errorf("stats are %v, the draw binding was already 5", got)
+	}
+
+	c.Invalidate()
+	if c.drawFramebuffer.valid || c.readFramebuffer.valid {
+		t.Error("invalidate kept the framebuffer bindings")
+	}
+}
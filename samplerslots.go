@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// TextureSource is anything that can be bound to a sampler. TextureHandle
+// is one, and gives the placeholder until its texture has loaded.
+type TextureSource interface {
+	ID() uint32
+	Target() uint32
+}
+
+type staticTexture struct {
+	id, target uint32
+}
+
+func (t staticTexture) ID() uint32     { return t.id }
+func (t staticTexture) Target() uint32 { return t.target }
+
+// StaticTexture wraps a texture created outside the asset manager
+func StaticTexture(id, target uint32) TextureSource {
+	return staticTexture{id, target}
+}
+
+// samplerTypes are the sampler uniform types that can read each target
+var samplerTypes = map[uint32][]uint32{
+	gl.TEXTURE_1D:       {gl.SAMPLER_1D},
+	gl.TEXTURE_2D:       {gl.SAMPLER_2D, gl.SAMPLER_2D_SHADOW, gl.INT_SAMPLER_2D, gl.UNSIGNED_INT_SAMPLER_2D},
+	gl.TEXTURE_3D:       {gl.SAMPLER_3D},
+	gl.TEXTURE_CUBE_MAP: {gl.SAMPLER_CUBE},
+	gl.TEXTURE_2D_ARRAY: {gl.SAMPLER_2D_ARRAY},
+	gl.TEXTURE_BUFFER:   {gl.SAMPLER_BUFFER},
+}
+
+type samplerSlot struct {
+	name    string
+	texture TextureSource
+}
+
+// SamplerSlots binds textures to named sampler uniforms. Each slot gets the
+// next texture unit in the order the slots were first set, so nothing has
+// to hand out units by hand.
+type SamplerSlots struct {
+	slots []samplerSlot
+}
+
+// Set fills the slot for the named sampler, replacing the texture but
+// keeping the unit if it was already set
+func (s *SamplerSlots) Set(name string, tex TextureSource) {
+	for i := range s.slots {
+		if s.slots[i].name == name {
+			s.slots[i].texture = tex
+			return
+		}
+	}
+	s.slots = append(s.slots, samplerSlot{name, tex})
+}
+
+// Unit is the texture unit the named sampler reads from
+func (s *SamplerSlots) Unit(name string) (int, bool) {
+	for i, slot := range s.slots {
+		if slot.name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Names lists the slots in unit order
+func (s *SamplerSlots) Names() []string {
+	names := make([]string, len(s.slots))
+	for i, slot := range s.slots {
+		names[i] = slot.name
+	}
+	return names
+}
+
+func (s *SamplerSlots) Len() int {
+	return len(s.slots)
+}
+
+// checkUnits fails when there are more slots than units
+func (s *SamplerSlots) checkUnits(maxUnits int) error {
+	if len(s.slots) > maxUnits {
+		return fmt.Errorf("%d sampler slots but only %d texture units: %v", len(s.slots), maxUnits, s.Names())
+	}
+	return nil
+}
+
+// Validate checks every slot fits in the texture units the fragment shader
+// can use and names a sampler of prog whose type matches the texture.
+func (s *SamplerSlots) Validate(prog *ShaderProgram) error {
+	if err := s.checkUnits(MaxTextureUnits()); err != nil {
+		return err
+	}
+	for _, slot := range s.slots {
+		if _, err := prog.uniform(slot.name, 1, samplerTypes[slot.texture.Target()]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bind binds every slot's texture to its unit through the cache and points
+// the samplers of prog, which must be in use, at them
+func (s *SamplerSlots) Bind(c *StateCache, prog *ShaderProgram) error {
+	if err := s.checkUnits(MaxTextureUnits()); err != nil {
+		return err
+	}
+	for unit, slot := range s.slots {
+		if _, err := prog.uniform(slot.name, 1, samplerTypes[slot.texture.Target()]...); err != nil {
+			return err
+		}
+		c.BindTexture(uint32(unit), slot.texture.Target(), slot.texture.ID())
+		if err := prog.SetInt(slot.name, int32(unit)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var maxTextureUnits int32
+
+// MaxTextureUnits is how many textures a fragment shader can sample at once
+func MaxTextureUnits() int {
+	if maxTextureUnits == 0 {
+		gl.GetIntegerv(gl.MAX_TEXTURE_IMAGE_UNITS, &maxTextureUnits)
+	}
+	return int(maxTextureUnits)
+}
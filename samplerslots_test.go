@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+func TestSamplerSlotsUnits(t *testing.T) {
+	var s SamplerSlots
+	s.Set("diffuse", StaticTexture(1, gl.TEXTURE_2D))
+	s.Set("layers", StaticTexture(2, gl.TEXTURE_2D_ARRAY))
+	s.Set("sky", StaticTexture(3, gl.TEXTURE_CUBE_MAP))
+
+	for want, name := range []string{"diffuse", "layers", "sky"} {
+		if unit, ok := s.Unit(name); !ok || unit != want {
+			t.Errorf("Unit(%q) = %d, %v, want %d", name, unit, ok, want)
+		}
+	}
+	if _, ok := s.Unit("normal"); ok {
+		t.Error("found a unit for a slot that was never set")
+	}
+
+	// replacing a texture keeps the unit the shader was pointed at
+	s.Set("layers", StaticTexture(4, gl.TEXTURE_2D_ARRAY))
+	if unit, _ := s.Unit("layers"); unit != 1 {
+		t.Errorf("replaced slot moved to unit %d, want 1", unit)
+	}
+	if s.slots[1].texture.ID() != 4 {
+		t.Errorf("replaced slot still holds texture %d", s.slots[1].texture.ID())
+	}
+	if s.Len() != 3 || !reflect.DeepEqual(s.Names(), []string{"diffuse", "layers", "sky"}) {
+		t.Errorf("slots are %v, want diffuse, layers and sky", s.Names())
+	}
+}
+
+func TestSamplerSlotsCheckUnits(t *testing.T) {
+	var s SamplerSlots
+	for _, name := range []string{"a", "b", "c"} {
+		s.Set(name, StaticTexture(1, gl.TEXTURE_2D))
+	}
+	if err := s.checkUnits(3); err != nil {
+		t.Errorf("3 slots in 3 units: %v", err)
+	}
+	err := s.checkUnits(2)
+	if err == nil {
+		t.Fatal("3 slots fit in 2 units")
+	}
+	if !strings.Contains(err.Error(), "[a b c]") {
+		t.Errorf("error %q doesn't list the slots", err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ShaderDiagnostic is one error or warning from a driver info log. Source is
+// the source string number reported by the driver, which the preprocessor
+// uses as an index into ShaderSource.Files.
+type ShaderDiagnostic struct {
+	Source   int
+	File     string
+	Line     int
+	Column   int // 0 when the driver does not report one
+	Severity string
+	Message  string
+	Snippet  string
+}
+
+func (d ShaderDiagnostic) String() string {
+	loc := fmt.Sprintf("%s:%d", d.File, d.Line)
+	if d.Column > 0 {
+		loc = fmt.Sprintf("%s:%d", loc, d.Column)
+	}
+	s := fmt.Sprintf("%s: %s: %s", loc, d.Severity, d.Message)
+	if d.Snippet != "" {
+		s += "\n" + d.Snippet
+	}
+	return s
+}
+
+type ShaderCompileError struct {
+	Stage       string
+	File        string
+	Diagnostics []ShaderDiagnostic
+	Log         string
+}
+
+func (e *ShaderCompileError) Error() string {
+	if len(e.Diagnostics) == 0 {
+		return fmt.Sprintf("failed to compile %s shader %s: %s", e.Stage, e.File, strings.TrimSpace(e.Log))
+	}
+	lines := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		lines[i] = d.String()
+	}
+	return fmt.Sprintf("failed to compile %s shader %s:\n%s", e.Stage, e.File, strings.Join(lines, "\n"))
+}
+
+type ShaderLinkError struct {
+	Stages []string // e.g. "vertex shader.vert"
+	Log    string
+}
+
+func (e *ShaderLinkError) Error() string {
+	return fmt.Sprintf("failed to link %s: %s", strings.Join(e.Stages, " and "), strings.TrimSpace(e.Log))
+}
+
+var (
+	// Mesa: 0:12(5): error: syntax error
+	mesaLogLine = regexp.MustCompile(`^\s*(\d+):(\d+)\((\d+)\):\s*(error|warning)\s*:\s*(.*)$`)
+	// NVIDIA: 0(12) : error C0000: syntax error
+	nvidiaLogLine = regexp.MustCompile(`^\s*(\d+)\((\d+)\)\s*:\s*(error|warning)\s*(\w*)\s*:\s*(.*)$`)
+	// AMD, Intel and Apple: ERROR: 0:12: 'x' : undeclared identifier
+	amdLogLine = regexp.MustCompile(`^\s*(ERROR|WARNING)\s*:\s*(\d+):(\d+)\s*:\s*(.*)$`)
+)
+
+// ParseShaderInfoLog extracts the diagnostics from a compile log. Lines that
+// match no known format are ignored, the raw log is kept by the caller.
+func ParseShaderInfoLog(log string) []ShaderDiagnostic {
+	var diags []ShaderDiagnostic
+	for _, line := range strings.Split(log, "\n") {
+		line = strings.TrimRight(line, "\r\x00")
+		if m := mesaLogLine.FindStringSubmatch(line); m != nil {
+			diags = append(diags, ShaderDiagnostic{
+				Source:   atoi(m[1]),
+				Line:     atoi(m[2]),
+				Column:   atoi(m[3]),
+				Severity: m[4],
+				Message:  strings.TrimSpace(m[5]),
+			})
+		} else if m := nvidiaLogLine.FindStringSubmatch(line); m != nil {
+			msg := strings.TrimSpace(m[5])
+			if m[4] != "" {
+				msg = m[4] + ": " + msg
+			}
+			diags = append(diags, ShaderDiagnostic{
+				Source:   atoi(m[1]),
+				Line:     atoi(m[2]),
+				Severity: m[3],
+				Message:  msg,
+			})
+		} else if m := amdLogLine.FindStringSubmatch(line); m != nil {
+			diags = append(diags, ShaderDiagnostic{
+				Source:   atoi(m[2]),
+				Line:     atoi(m[3]),
+				Severity: strings.ToLower(m[1]),
+				Message:  strings.TrimSpace(m[4]),
+			})
+		}
+	}
+	return diags
+}
+
+// resolveDiagnostics fills in the file name and a snippet of the original
+// source for each diagnostic using the preprocessor's file table.
+func resolveDiagnostics(diags []ShaderDiagnostic, src *ShaderSource) {
+	for i := range diags {
+		d := &diags[i]
+		if d.Source < 0 || d.Source >= len(src.Files) {
+			d.File = fmt.Sprintf("source %d", d.Source)
+			continue
+		}
+		d.File = src.Files[d.Source]
+		if d.Source < len(src.Contents) {
+			d.Snippet = sourceSnippet(src.Contents[d.Source], d.Line, d.Column, 2)
+		}
+	}
+}
+
+// sourceSnippet returns the lines around line with the line itself marked,
+// and a caret under the column when it is known.
+func sourceSnippet(content string, line, column, context int) string {
+	lines := strings.Split(content, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	first := line - context
+	if first < 1 {
+		first = 1
+	}
+	last := line + context
+	if last > len(lines) {
+		last = len(lines)
+	}
+
+	width := len(strconv.Itoa(last))
+	var b strings.Builder
+	for n := first; n <= last; n++ {
+		marker := " "
+		if n == line {
+			marker = ">"
+		}
+		text := strings.TrimRight(lines[n-1], "\r")
+		fmt.Fprintf(&b, "%s %*d | %s\n", marker, width, n, text)
+		if n == line && column > 0 {
+			fmt.Fprintf(&b, "  %*s | %s^\n", width, "", strings.Repeat(" ", column-1))
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
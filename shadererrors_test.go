@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseShaderInfoLog(t *testing.T) {
+	src, err := NewShaderPreprocessor(os.DirFS("testdata/shaderlogs")).Process("main.frag", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snippet := "" +
+		"  2 | \n" +
+		"  3 | vec3 shade(vec3 n) {\n" +
+		"> 4 |     return lightColr * max(dot(n, vec3(0.0, 1.0, 0.0)), 0.0);\n" +
+		"    |            ^\n" +
+		"  5 | }\n" +
+		"  6 | "
+	noColumn := "" +
+		"  2 | \n" +
+		"  3 | vec3 shade(vec3 n) {\n" +
+		"> 4 |     return lightColr * max(dot(n, vec3(0.0, 1.0, 0.0)), 0.0);\n" +
+		"  5 | }\n" +
+		"  6 | "
+	tests := []struct {
+		log  string
+		want []ShaderDiagnostic
+	}{
+		{"mesa.log", []ShaderDiagnostic{
+			{Source: 1, File: "lighting.glsl", Line: 4, Column: 12, Severity: "error", Message: "`lightColr' undeclared", Snippet: snippet},
+			{Source: 1, File: "lighting.glsl", Line: 4, Column: 12, Severity: "error", Message: "operands to arithmetic operators must be numeric", Snippet: snippet},
+		}},
+		{"nvidia.log", []ShaderDiagnostic{
+			{Source: 1, File: "lighting.glsl", Line: 4, Severity: "error", Message: `C1008: undefined variable "lightColr"`, Snippet: noColumn},
+		}},
+		{"amd.log", []ShaderDiagnostic{
+			{Source: 1, File: "lighting.glsl", Line: 4, Severity: "error", Message: "'lightColr' : undeclared identifier", Snippet: noColumn},
+			{Source: 1, File: "lighting.glsl", Line: 4, Severity: "error", Message: "'' : compilation terminated", Snippet: noColumn},
+		}},
+	}
+	for _, tt := range tests {
+		b, err := ioutil.ReadFile("testdata/shaderlogs/" + tt.log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		diags := ParseShaderInfoLog(string(b))
+		resolveDiagnostics(diags, src)
+		if !reflect.DeepEqual(diags, tt.want) {
+			t.Errorf("%s:\ngot  %#v\nwant %#v", tt.log, diags, tt.want)
+		}
+	}
+}
+
+func TestResolveDiagnosticsUnknownSource(t *testing.T) {
+	diags := []ShaderDiagnostic{{Source: 7, Line: 1}}
+	resolveDiagnostics(diags, &ShaderSource{Files: []string{"a.frag"}, Contents: []string{"x"}})
+	if diags[0].File != "source 7" || diags[0].Snippet != "" {
+		t.Errorf("got %+v, want file \"source 7\" and no snippet", diags[0])
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ShaderDefines are injected as #define lines after #version, a define with
+// an empty value is a plain flag such as HAS_NORMAL_MAP.
+type ShaderDefines map[string]string
+
+// Key returns a stable name for a set of defines, used to cache variants
+func (d ShaderDefines) Key() string {
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		if d[name] == "" {
+			parts[i] = name
+		} else {
+			parts[i] = name + "=" + d[name]
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// ShaderSource is the result of preprocessing one shader stage. The source
+// string number of each #line directive is an index into Files, Contents
+// holds the original text of each file for error reporting.
+type ShaderSource struct {
+	Code     string
+	Files    []string
+	Contents []string
+}
+
+// ShaderPreprocessor resolves #include "file" relative to the including file
+// and injects defines. Files are read from FS, which can be an in-memory
+// filesystem for preprocessing without the real assets.
+type ShaderPreprocessor struct {
+	FS fs.FS
+}
+
+func NewShaderPreprocessor(fsys fs.FS) *ShaderPreprocessor {
+	return &ShaderPreprocessor{FS: fsys}
+}
+
+type preprocessState struct {
+	out      strings.Builder
+	files    []string
+	contents []string
+	fileIDs  map[string]int
+	stack    []string
+	once     map[string]bool
+	defines  ShaderDefines
+	injected bool
+}
+
+func (pp *ShaderPreprocessor) Process(file string, defines ShaderDefines) (*ShaderSource, error) {
+	st := &preprocessState{
+		fileIDs: map[string]int{},
+		once:    map[string]bool{},
+		defines: defines,
+	}
+	if err := pp.processFile(st, cleanShaderPath(file)); err != nil {
+		return nil, err
+	}
+	return &ShaderSource{Code: st.out.String(), Files: st.files, Contents: st.contents}, nil
+}
+
+func (pp *ShaderPreprocessor) processFile(st *preprocessState, file string) error {
+	// #pragma once also breaks cycles, as with a C include guard
+	if st.once[file] {
+		return nil
+	}
+	for _, f := range st.stack {
+		if f == file {
+			return fmt.Errorf("include cycle: %s -> %s", strings.Join(st.stack, " -> "), file)
+		}
+	}
+
+	b, err := fs.ReadFile(pp.FS, file)
+	if err != nil {
+		if len(st.stack) > 0 {
+			return fmt.Errorf("%s: %v", st.stack[len(st.stack)-1], err)
+		}
+		return err
+	}
+
+	id, ok := st.fileIDs[file]
+	if !ok {
+		id = len(st.files)
+		st.fileIDs[file] = id
+		st.files = append(st.files, file)
+		st.contents = append(st.contents, string(b))
+	}
+	st.stack = append(st.stack, file)
+	defer func() { st.stack = st.stack[:len(st.stack)-1] }()
+
+	lines := strings.Split(strings.Replace(string(b), "\r\n", "\n", -1), "\n")
+	isRoot := len(st.stack) == 1
+	hasVersion := false
+	for _, line := range lines {
+		if directive(line) == "version" {
+			hasVersion = true
+			break
+		}
+	}
+
+	// without a #version the defines go at the very top
+	if isRoot && !hasVersion {
+		st.injectDefines()
+	}
+	if !isRoot || !hasVersion {
+		fmt.Fprintf(&st.out, "#line 1 %d\n", id)
+	}
+
+	for i, line := range lines {
+		lineNo := i + 1
+		switch directive(line) {
+		case "version":
+			if !isRoot {
+				return fmt.Errorf("%s:%d: #version is only allowed in the main shader file", file, lineNo)
+			}
+			st.out.WriteString(line + "\n")
+			st.injectDefines()
+			fmt.Fprintf(&st.out, "#line %d %d\n", lineNo+1, id)
+		case "include":
+			name, err := includeName(line)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", file, lineNo, err)
+			}
+			if err := pp.processFile(st, cleanShaderPath(path.Join(path.Dir(file), name))); err != nil {
+				return err
+			}
+			fmt.Fprintf(&st.out, "#line %d %d\n", lineNo+1, id)
+		case "pragma":
+			if strings.Fields(line)[len(strings.Fields(line))-1] == "once" {
+				st.once[file] = true
+				st.out.WriteString("\n")
+				continue
+			}
+			st.out.WriteString(line + "\n")
+		default:
+			st.out.WriteString(line + "\n")
+		}
+	}
+	return nil
+}
+
+func (st *preprocessState) injectDefines() {
+	if st.injected {
+		return
+	}
+	st.injected = true
+	names := make([]string, 0, len(st.defines))
+	for name := range st.defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if st.defines[name] == "" {
+			fmt.Fprintf(&st.out, "#define %s\n", name)
+		} else {
+			fmt.Fprintf(&st.out, "#define %s %s\n", name, st.defines[name])
+		}
+	}
+}
+
+// directive returns the name of the preprocessor directive on a line, if any
+func directive(line string) string {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "#") {
+		return ""
+	}
+	fields := strings.Fields(strings.TrimSpace(line[1:]))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+func includeName(line string) (string, error) {
+	line = strings.TrimSpace(line)
+	start := strings.Index(line, "\"")
+	end := strings.LastIndex(line, "\"")
+	if start < 0 || end <= start {
+		return "", fmt.Errorf("malformed #include, expected #include \"file\"")
+	}
+	return line[start+1 : end], nil
+}
+
+// cleanShaderPath normalises a path to forward slashes so includes resolve
+// the same way on every platform.
+func cleanShaderPath(p string) string {
+	return path.Clean(filepath.ToSlash(p))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestShaderPreprocessInclude(t *testing.T) {
+	fsys := fstest.MapFS{
+		"shaders/main.frag":        {Data: []byte("#version 330 core\n#include \"lib/common.glsl\"\nvoid main() {}\n")},
+		"shaders/lib/common.glsl":  {Data: []byte("#include \"../util.glsl\"\nfloat common;\n")},
+		"shaders/util.glsl":        {Data: []byte("float util;\n")},
+		"shaders/noversion.frag":   {Data: []byte("float a;\n")},
+		"shaders/badinclude.frag":  {Data: []byte("#version 330 core\n#include common.glsl\n")},
+		"shaders/lateversion.frag": {Data: []byte("#version 330 core\n#include \"v.glsl\"\n")},
+		"shaders/v.glsl":           {Data: []byte("#version 330 core\n")},
+	}
+	src, err := NewShaderPreprocessor(fsys).Process("shaders/main.frag", ShaderDefines{"NUM_LIGHTS": "4", "HAS_NORMAL_MAP": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"#version 330 core",
+		"#define HAS_NORMAL_MAP",
+		"#define NUM_LIGHTS 4",
+		"#line 2 0",
+		"#line 1 1",
+		"#line 1 2",
+		"float util;",
+		"",
+		"#line 2 1",
+		"float common;",
+		"",
+		"#line 3 0",
+		"void main() {}",
+		"",
+	}, "\n") + "\n"
+	if src.Code != want {
+		t.Errorf("code:\n%s\nwant:\n%s", src.Code, want)
+	}
+	wantFiles := []string{"shaders/main.frag", "shaders/lib/common.glsl", "shaders/util.glsl"}
+	if strings.Join(src.Files, ",") != strings.Join(wantFiles, ",") {
+		t.Errorf("files %v, want %v", src.Files, wantFiles)
+	}
+	if src.Contents[2] != "float util;\n" {
+		t.Errorf("contents of util.glsl %q", src.Contents[2])
+	}
+
+	src, err = NewShaderPreprocessor(fsys).Process("shaders/noversion.frag", ShaderDefines{"A": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "#define A 1\n#line 1 0\nfloat a;\n\n"; src.Code != want {
+		t.Errorf("without #version got %q, want %q", src.Code, want)
+	}
+
+	for file, msg := range map[string]string{
+		"shaders/badinclude.frag":  "badinclude.frag:2: malformed #include",
+		"shaders/lateversion.frag": "v.glsl:1: #version is only allowed in the main shader file",
+		"shaders/missing.frag":     "missing.frag",
+	} {
+		_, err := NewShaderPreprocessor(fsys).Process(file, nil)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: error %v, want it to mention %q", file, err, msg)
+		}
+	}
+}
+
+func TestShaderPreprocessCycle(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.glsl":    {Data: []byte("#include \"b.glsl\"\n")},
+		"b.glsl":    {Data: []byte("#include \"a.glsl\"\n")},
+		"self.glsl": {Data: []byte("#include \"self.glsl\"\n")},
+	}
+	_, err := NewShaderPreprocessor(fsys).Process("a.glsl", nil)
+	if err == nil || !strings.Contains(err.Error(), "include cycle: a.glsl -> b.glsl -> a.glsl") {
+		t.Errorf("got %v, want the cycle a -> b -> a", err)
+	}
+	_, err = NewShaderPreprocessor(fsys).Process("self.glsl", nil)
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("self include got %v, want a cycle", err)
+	}
+}
+
+func TestShaderPreprocessPragmaOnce(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.frag":   {Data: []byte("#include \"a.glsl\"\n#include \"b.glsl\"\n#include \"once.glsl\"\n")},
+		"a.glsl":      {Data: []byte("#include \"once.glsl\"\n")},
+		"b.glsl":      {Data: []byte("#include \"once.glsl\"\n")},
+		"once.glsl":   {Data: []byte("#pragma once\nfloat shared;\n")},
+		"guard.glsl":  {Data: []byte("#pragma once\n#include \"guard2.glsl\"\n")},
+		"guard2.glsl": {Data: []byte("#pragma once\n#include \"guard.glsl\"\n")},
+	}
+	src, err := NewShaderPreprocessor(fsys).Process("main.frag", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(src.Code, "float shared;"); n != 1 {
+		t.Errorf("once.glsl included %d times, want 1:\n%s", n, src.Code)
+	}
+	// #pragma once stops a cycle like an include guard would
+	if _, err := NewShaderPreprocessor(fsys).Process("guard.glsl", nil); err != nil {
+		t.Errorf("guarded cycle: %v", err)
+	}
+}
+
+func TestShaderDefinesKey(t *testing.T) {
+	tests := []struct {
+		defines ShaderDefines
+		want    string
+	}{
+		{nil, ""},
+		{ShaderDefines{"B": "", "A": "1"}, "A=1;B"},
+		{ShaderDefines{"A": "1", "B": ""}, "A=1;B"},
+		{ShaderDefines{"NUM_LIGHTS": "4"}, "NUM_LIGHTS=4"},
+	}
+	for _, tt := range tests {
+		if got := tt.defines.Key(); got != tt.want {
+			t.Errorf("%v: key %q, want %q", tt.defines, got, tt.want)
+		}
+	}
+	if (ShaderDefines{"A": ""}).Key() == (ShaderDefines{"A": "1"}).Key() {
+		t.Error("a flag and a valued define share a key")
+	}
+}
@@ -2,36 +2,271 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
+	"io/fs"
+	"path"
+	"sort"
 	"strings"
 
 	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
 )
 
-func NewShaderProgram(vertexSrcFile, fragmentSrcFile string) (uint32, error) {
+// ShaderProgram is a linked program with the locations of its active
+// uniforms and attributes looked up once after linking. Like the gl.Uniform
+// calls they wrap, the setters apply to the program currently in use.
+//
+// Values passed to the setters are remembered so they can be applied again
+// when the program is reloaded from its source files.
+type ShaderProgram struct {
+	ID         uint32
+	fsys       fs.FS
+	files      []string
+	build      func() (uint32, []string, error)
+	uniforms   map[string]shaderVariable
+	attributes map[string]shaderVariable
+	values     map[string]uniformValue
+}
 
-	vb, err := ioutil.ReadFile(vertexSrcFile)
-	if err != nil {
-		return 0, err
+type uniformKind int
+
+const (
+	uniformInt uniformKind = iota + 1
+	uniformUint
+	uniformFloat
+	uniformVec2
+	uniformVec3
+	uniformVec4
+	uniformMat3
+	uniformMat4
+	uniformIntArray
+	uniformFloatArray
+	uniformVec3Array
+	uniformVec4Array
+	uniformMat4Array
+)
+
+// uniformValue is what a setter last set. It is typed rather than boxed in
+// an interface so setting a uniform every frame doesn't allocate, and
+// array setters reuse the slice they stored last time.
+type uniformValue struct {
+	kind uniformKind
+	i    int32
+	u    uint32
+	vec  mgl32.Vec4 // float, vec2, vec3 and vec4
+	mat3 mgl32.Mat3
+	mat4 mgl32.Mat4
+
+	ints   []int32
+	floats []float32
+	vec3s  []mgl32.Vec3
+	vec4s  []mgl32.Vec4
+	mat4s  []mgl32.Mat4
+}
+
+// UniformRestoreError is returned by Reload when the program was rebuilt
+// but some remembered values no longer fit their uniforms, usually because
+// their type changed in the source
+type UniformRestoreError struct {
+	Program uint32
+	Errs    []error
+}
+
+func (e *UniformRestoreError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
 	}
-	vertexShader, err := compileShader(string(vb)+"\x00", gl.VERTEX_SHADER)
+	return fmt.Sprintf("program %d: uniforms not restored: %s", e.Program, strings.Join(msgs, "; "))
+}
+
+type shaderVariable struct {
+	Location int32
+	Type     uint32
+	Size     int32
+}
+
+// NewShaderProgram builds a program from one file per stage, the stage is
+// taken from the file extension: .vert, .tesc, .tese, .geom or .frag.
+// Compute shaders are built with NewComputeProgram.
+func NewShaderProgram(fsys fs.FS, files ...string) (*ShaderProgram, error) {
+	return NewShaderProgramVariant(fsys, nil, files...)
+}
+
+// NewShaderProgramVariant preprocesses every stage with the given defines
+// before compiling, see ShaderPreprocessor.
+func NewShaderProgramVariant(fsys fs.FS, defines ShaderDefines, files ...string) (*ShaderProgram, error) {
+	stages, err := graphicsStages(files)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	return buildShaderProgram(fsys, stages, files, defines)
+}
 
-	fb, err := ioutil.ReadFile(fragmentSrcFile)
+func buildShaderProgram(fsys fs.FS, stages []uint32, files []string, defines ShaderDefines) (*ShaderProgram, error) {
+	build := func() (uint32, []string, error) {
+		pre := NewShaderPreprocessor(fsys)
+		sources := make([]*ShaderSource, len(files))
+		var allFiles []string
+		for i, f := range files {
+			src, err := pre.Process(f, defines)
+			if err != nil {
+				return 0, nil, err
+			}
+			sources[i] = src
+			allFiles = append(allFiles, src.Files...)
+		}
+		program, err := linkProgramCached(stages, sources, defines)
+		if err != nil {
+			return 0, nil, err
+		}
+		return program, allFiles, nil
+	}
+
+	program, allFiles, err := build()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	fragmentShader, err := compileShader(string(fb)+"\x00", gl.FRAGMENT_SHADER)
+	p := newShaderProgram(program)
+	p.fsys = fsys
+	p.files = allFiles
+	p.build = build
+	return p, nil
+}
+
+var shaderStageExtensions = map[string]uint32{
+	".vert": gl.VERTEX_SHADER,
+	".tesc": gl.TESS_CONTROL_SHADER,
+	".tese": gl.TESS_EVALUATION_SHADER,
+	".geom": gl.GEOMETRY_SHADER,
+	".frag": gl.FRAGMENT_SHADER,
+	".comp": gl.COMPUTE_SHADER,
+}
+
+// shaderStage infers the stage from the extension, a trailing .glsl is
+// ignored so "water.frag.glsl" is a fragment shader.
+func shaderStage(file string) (uint32, error) {
+	name := strings.TrimSuffix(strings.ToLower(file), ".glsl")
+	if stage, ok := shaderStageExtensions[path.Ext(name)]; ok {
+		return stage, nil
+	}
+	return 0, fmt.Errorf("cannot tell the shader stage of %q from its extension", file)
+}
+
+// graphicsStages checks the files make up a valid set of graphics stages
+func graphicsStages(files []string) ([]uint32, error) {
+	stages := make([]uint32, len(files))
+	seen := map[uint32]string{}
+	for i, f := range files {
+		stage, err := shaderStage(f)
+		if err != nil {
+			return nil, err
+		}
+		if stage == gl.COMPUTE_SHADER {
+			return nil, fmt.Errorf("%q is a compute shader, use NewComputeProgram", f)
+		}
+		if other, ok := seen[stage]; ok {
+			return nil, fmt.Errorf("%q and %q are both %s shaders", other, f, stageName(stage))
+		}
+		seen[stage] = f
+		stages[i] = stage
+	}
+	if _, ok := seen[gl.VERTEX_SHADER]; !ok {
+		return nil, fmt.Errorf("program %v has no vertex shader", files)
+	}
+	_, tesc := seen[gl.TESS_CONTROL_SHADER]
+	_, tese := seen[gl.TESS_EVALUATION_SHADER]
+	if tesc && !tese {
+		return nil, fmt.Errorf("program %v has a tessellation control shader but no evaluation shader", files)
+	}
+	return stages, nil
+}
+
+// ShaderBinaryCache, when set, keeps linked programs between runs so they
+// don't have to be compiled from source again.
+var ShaderBinaryCache *ProgramBinaryCache
+
+// linkProgramCached loads the program from ShaderBinaryCache when the driver
+// accepts the stored binary, and links from source otherwise.
+func linkProgramCached(stages []uint32, sources []*ShaderSource, defines ShaderDefines) (uint32, error) {
+	var formats int32
+	gl.GetIntegerv(gl.NUM_PROGRAM_BINARY_FORMATS, &formats)
+	if ShaderBinaryCache == nil || formats == 0 {
+		return linkProgram(stages, sources, false)
+	}
+
+	codes := make([]string, len(sources))
+	for i, src := range sources {
+		codes[i] = src.Code
+	}
+	key := ProgramCacheKey(currentDriverInfo(), stages, codes, defines)
+
+	var cached uint32
+	accepted := ShaderBinaryCache.Use(key, func(format uint32, data []byte) bool {
+		cached = gl.CreateProgram()
+		gl.ProgramBinary(cached, format, gl.Ptr(data), int32(len(data)))
+		var status int32
+		gl.GetProgramiv(cached, gl.LINK_STATUS, &status)
+		if status != gl.TRUE {
+			// rejected, e.g. after a driver update that kept the version string
+			gl.DeleteProgram(cached)
+			return false
+		}
+		return true
+	})
+	if accepted {
+		return cached, nil
+	}
+
+	program, err := linkProgram(stages, sources, true)
 	if err != nil {
 		return 0, err
 	}
 
+	var length int32
+	gl.GetProgramiv(program, gl.PROGRAM_BINARY_LENGTH, &length)
+	if length > 0 {
+		var format uint32
+		data := make([]byte, length)
+		gl.GetProgramBinary(program, length, &length, &format, gl.Ptr(data))
+		if err := ShaderBinaryCache.Store(key, format, data[:length]); err != nil {
+			fmt.Println("failed to cache program binary:", err)
+		}
+	}
+	return program, nil
+}
+
+func currentDriverInfo() DriverInfo {
+	return DriverInfo{
+		Vendor:   gl.GoStr(gl.GetString(gl.VENDOR)),
+		Renderer: gl.GoStr(gl.GetString(gl.RENDERER)),
+		Version:  gl.GoStr(gl.GetString(gl.VERSION)),
+	}
+}
+
+func linkProgram(stages []uint32, sources []*ShaderSource, retrievable bool) (uint32, error) {
+
+	shaders := make([]uint32, 0, len(stages))
+	defer func() {
+		for _, shader := range shaders {
+			gl.DeleteShader(shader)
+		}
+	}()
+	for i, stage := range stages {
+		shader, err := compileShader(sources[i], stage)
+		if err != nil {
+			return 0, err
+		}
+		shaders = append(shaders, shader)
+	}
+
 	program := gl.CreateProgram()
 
-	gl.AttachShader(program, vertexShader)
-	gl.AttachShader(program, fragmentShader)
+	for _, shader := range shaders {
+		gl.AttachShader(program, shader)
+	}
+	if retrievable {
+		gl.ProgramParameteri(program, gl.PROGRAM_BINARY_RETRIEVABLE_HINT, gl.TRUE)
+	}
 	//gl.BindFragDataLocation(program, 0, gl.Str("outColor\x00"))
 	gl.LinkProgram(program)
 
@@ -43,20 +278,432 @@ func NewShaderProgram(vertexSrcFile, fragmentSrcFile string) (uint32, error) {
 
 		log := strings.Repeat("\x00", int(logLength+1))
 		gl.GetProgramInfoLog(program, logLength, nil, gl.Str(log))
+		gl.DeleteProgram(program)
 
-		return 0, fmt.Errorf("failed to link program: %v", log)
+		names := make([]string, len(stages))
+		for i, stage := range stages {
+			names[i] = stageName(stage) + " shader " + mainFile(sources[i])
+		}
+		return 0, &ShaderLinkError{Stages: names, Log: strings.TrimRight(log, "\x00")}
 	}
 
-	gl.DeleteShader(vertexShader)
-	gl.DeleteShader(fragmentShader)
-
 	return program, nil
 }
 
-func compileShader(source string, shaderType uint32) (uint32, error) {
+// Reload builds the program again from its source files. On failure the
+// current program is left untouched. On success the new program replaces
+// the old one in place, so everyone holding this *ShaderProgram uses it from
+// the next draw, and the remembered uniform values are set again.
+func (p *ShaderProgram) Reload() error {
+	if p.build == nil {
+		return fmt.Errorf("program %d has no source files to reload from", p.ID)
+	}
+	program, files, err := p.build()
+	if err != nil {
+		return err
+	}
+
+	var current int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &current)
+	previous := p.ID
+
+	fresh := newShaderProgram(program)
+	gl.DeleteProgram(p.ID)
+	p.ID = fresh.ID
+	p.files = files
+	p.uniforms = fresh.uniforms
+	p.attributes = fresh.attributes
+
+	p.Use()
+	values := p.values
+	p.values = map[string]uniformValue{}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		// uniforms removed from the source are dropped silently, ones
+		// whose type changed are reported for the caller to set again
+		if !p.HasUniform(name) {
+			continue
+		}
+		if err := p.restore(name, values[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if uint32(current) != previous {
+		gl.UseProgram(uint32(current))
+	}
+	if len(errs) > 0 {
+		return &UniformRestoreError{Program: p.ID, Errs: errs}
+	}
+	return nil
+}
+
+// SourceFiles lists every file the program was built from, includes too
+func (p *ShaderProgram) SourceFiles() []string {
+	return p.files
+}
+
+// FS is the filesystem the source files are read from
+func (p *ShaderProgram) FS() fs.FS {
+	return p.fsys
+}
+
+// newShaderProgram wraps a linked program and caches its active variables
+func newShaderProgram(program uint32) *ShaderProgram {
+	p := &ShaderProgram{
+		ID:         program,
+		uniforms:   map[string]shaderVariable{},
+		attributes: map[string]shaderVariable{},
+		values:     map[string]uniformValue{},
+	}
+	bindUniformBlocks(program)
+
+	var count, maxLength int32
+	gl.GetProgramiv(program, gl.ACTIVE_UNIFORMS, &count)
+	gl.GetProgramiv(program, gl.ACTIVE_UNIFORM_MAX_LENGTH, &maxLength)
+	for i := uint32(0); i < uint32(count); i++ {
+		name, size, xtype := activeVariable(program, i, maxLength, gl.GetActiveUniform)
+		loc := gl.GetUniformLocation(program, gl.Str(name+"\x00"))
+		if loc < 0 {
+			// uniforms inside blocks have no location
+			continue
+		}
+		p.addVariable(p.uniforms, name, shaderVariable{Location: loc, Type: xtype, Size: size})
+	}
+
+	gl.GetProgramiv(program, gl.ACTIVE_ATTRIBUTES, &count)
+	gl.GetProgramiv(program, gl.ACTIVE_ATTRIBUTE_MAX_LENGTH, &maxLength)
+	for i := uint32(0); i < uint32(count); i++ {
+		name, size, xtype := activeVariable(program, i, maxLength, gl.GetActiveAttrib)
+		loc := gl.GetAttribLocation(program, gl.Str(name+"\x00"))
+		p.addVariable(p.attributes, name, shaderVariable{Location: loc, Type: xtype, Size: size})
+	}
+	return p
+}
+
+func activeVariable(program, index uint32, maxLength int32, get func(uint32, uint32, int32, *int32, *int32, *uint32, *uint8)) (string, int32, uint32) {
+	var length, size int32
+	var xtype uint32
+	buf := make([]uint8, maxLength+1)
+	get(program, index, maxLength, &length, &size, &xtype, &buf[0])
+	return string(buf[:length]), size, xtype
+}
+
+// addVariable stores arrays under both "name[0]" and "name"
+func (p *ShaderProgram) addVariable(vars map[string]shaderVariable, name string, v shaderVariable) {
+	vars[name] = v
+	if strings.HasSuffix(name, "[0]") {
+		vars[strings.TrimSuffix(name, "[0]")] = v
+	}
+}
+
+func (p *ShaderProgram) Use() {
+	gl.UseProgram(p.ID)
+}
+
+func (p *ShaderProgram) Delete() {
+	gl.DeleteProgram(p.ID)
+}
+
+func (p *ShaderProgram) HasUniform(name string) bool {
+	_, ok := p.uniforms[name]
+	return ok
+}
+
+func (p *ShaderProgram) UniformLocation(name string) (int32, error) {
+	u, ok := p.uniforms[name]
+	if !ok {
+		return -1, fmt.Errorf("unknown uniform %q in program %d", name, p.ID)
+	}
+	return u.Location, nil
+}
+
+func (p *ShaderProgram) AttribLocation(name string) (uint32, error) {
+	a, ok := p.attributes[name]
+	if !ok || a.Location < 0 {
+		return 0, fmt.Errorf("unknown attribute %q in program %d", name, p.ID)
+	}
+	return uint32(a.Location), nil
+}
+
+// uniform looks up a uniform and checks it has one of the wanted types and
+// room for count elements.
+func (p *ShaderProgram) uniform(name string, count int, types ...uint32) (int32, error) {
+	u, ok := p.uniforms[name]
+	if !ok {
+		return -1, fmt.Errorf("unknown uniform %q in program %d", name, p.ID)
+	}
+	match := false
+	for _, t := range types {
+		if u.Type == t {
+			match = true
+			break
+		}
+	}
+	if !match {
+		return -1, fmt.Errorf("uniform %q is %s, not %s", name, glTypeName(u.Type), glTypeName(types[0]))
+	}
+	if int32(count) > u.Size {
+		return -1, fmt.Errorf("uniform %q holds %d elements, got %d", name, u.Size, count)
+	}
+	return u.Location, nil
+}
+
+// SetInt also sets sampler and bool uniforms
+func (p *ShaderProgram) SetInt(name string, v int32) error {
+	loc, err := p.uniform(name, 1, intUniformTypes...)
+	if err != nil {
+		return err
+	}
+	gl.Uniform1i(loc, v)
+	p.values[name] = uniformValue{kind: uniformInt, i: v}
+	return nil
+}
+
+func (p *ShaderProgram) SetUint(name string, v uint32) error {
+	loc, err := p.uniform(name, 1, gl.UNSIGNED_INT)
+	if err != nil {
+		return err
+	}
+	gl.Uniform1ui(loc, v)
+	p.values[name] = uniformValue{kind: uniformUint, u: v}
+	return nil
+}
+
+func (p *ShaderProgram) SetFloat(name string, v float32) error {
+	loc, err := p.uniform(name, 1, gl.FLOAT)
+	if err != nil {
+		return err
+	}
+	gl.Uniform1f(loc, v)
+	p.values[name] = uniformValue{kind: uniformFloat, vec: mgl32.Vec4{v}}
+	return nil
+}
+
+func (p *ShaderProgram) SetVec2(name string, v mgl32.Vec2) error {
+	loc, err := p.uniform(name, 1, gl.FLOAT_VEC2)
+	if err != nil {
+		return err
+	}
+	gl.Uniform2fv(loc, 1, &v[0])
+	p.values[name] = uniformValue{kind: uniformVec2, vec: mgl32.Vec4{v[0], v[1]}}
+	return nil
+}
+
+func (p *ShaderProgram) SetVec3(name string, v mgl32.Vec3) error {
+	loc, err := p.uniform(name, 1, gl.FLOAT_VEC3)
+	if err != nil {
+		return err
+	}
+	gl.Uniform3fv(loc, 1, &v[0])
+	p.values[name] = uniformValue{kind: uniformVec3, vec: v.Vec4(0)}
+	return nil
+}
+
+func (p *ShaderProgram) SetVec4(name string, v mgl32.Vec4) error {
+	loc, err := p.uniform(name, 1, gl.FLOAT_VEC4)
+	if err != nil {
+		return err
+	}
+	gl.Uniform4fv(loc, 1, &v[0])
+	p.values[name] = uniformValue{kind: uniformVec4, vec: v}
+	return nil
+}
+
+func (p *ShaderProgram) SetMat3(name string, m mgl32.Mat3) error {
+	loc, err := p.uniform(name, 1, gl.FLOAT_MAT3)
+	if err != nil {
+		return err
+	}
+	gl.UniformMatrix3fv(loc, 1, false, &m[0])
+	p.values[name] = uniformValue{kind: uniformMat3, mat3: m}
+	return nil
+}
+
+func (p *ShaderProgram) SetMat4(name string, m mgl32.Mat4) error {
+	loc, err := p.uniform(name, 1, gl.FLOAT_MAT4)
+	if err != nil {
+		return err
+	}
+	gl.UniformMatrix4fv(loc, 1, false, &m[0])
+	p.values[name] = uniformValue{kind: uniformMat4, mat4: m}
+	return nil
+}
+
+func (p *ShaderProgram) SetIntArray(name string, v []int32) error {
+	if len(v) == 0 {
+		return nil
+	}
+	loc, err := p.uniform(name, len(v), intUniformTypes...)
+	if err != nil {
+		return err
+	}
+	gl.Uniform1iv(loc, int32(len(v)), &v[0])
+	p.values[name] = uniformValue{kind: uniformIntArray, ints: append(p.values[name].ints[:0], v...)}
+	return nil
+}
+
+func (p *ShaderProgram) SetFloatArray(name string, v []float32) error {
+	if len(v) == 0 {
+		return nil
+	}
+	loc, err := p.uniform(name, len(v), gl.FLOAT)
+	if err != nil {
+		return err
+	}
+	gl.Uniform1fv(loc, int32(len(v)), &v[0])
+	p.values[name] = uniformValue{kind: uniformFloatArray, floats: append(p.values[name].floats[:0], v...)}
+	return nil
+}
+
+func (p *ShaderProgram) SetVec3Array(name string, v []mgl32.Vec3) error {
+	if len(v) == 0 {
+		return nil
+	}
+	loc, err := p.uniform(name, len(v), gl.FLOAT_VEC3)
+	if err != nil {
+		return err
+	}
+	gl.Uniform3fv(loc, int32(len(v)), &v[0][0])
+	p.values[name] = uniformValue{kind: uniformVec3Array, vec3s: append(p.values[name].vec3s[:0], v...)}
+	return nil
+}
+
+func (p *ShaderProgram) SetVec4Array(name string, v []mgl32.Vec4) error {
+	if len(v) == 0 {
+		return nil
+	}
+	loc, err := p.uniform(name, len(v), gl.FLOAT_VEC4)
+	if err != nil {
+		return err
+	}
+	gl.Uniform4fv(loc, int32(len(v)), &v[0][0])
+	p.values[name] = uniformValue{kind: uniformVec4Array, vec4s: append(p.values[name].vec4s[:0], v...)}
+	return nil
+}
+
+func (p *ShaderProgram) SetMat4Array(name string, v []mgl32.Mat4) error {
+	if len(v) == 0 {
+		return nil
+	}
+	loc, err := p.uniform(name, len(v), gl.FLOAT_MAT4)
+	if err != nil {
+		return err
+	}
+	gl.UniformMatrix4fv(loc, int32(len(v)), false, &v[0][0])
+	p.values[name] = uniformValue{kind: uniformMat4Array, mat4s: append(p.values[name].mat4s[:0], v...)}
+	return nil
+}
+
+// restore sets a remembered value again with the setter that stored it
+func (p *ShaderProgram) restore(name string, v uniformValue) error {
+	switch v.kind {
+	case uniformInt:
+		return p.SetInt(name, v.i)
+	case uniformUint:
+		return p.SetUint(name, v.u)
+	case uniformFloat:
+		return p.SetFloat(name, v.vec[0])
+	case uniformVec2:
+		return p.SetVec2(name, v.vec.Vec2())
+	case uniformVec3:
+		return p.SetVec3(name, v.vec.Vec3())
+	case uniformVec4:
+		return p.SetVec4(name, v.vec)
+	case uniformMat3:
+		return p.SetMat3(name, v.mat3)
+	case uniformMat4:
+		return p.SetMat4(name, v.mat4)
+	case uniformIntArray:
+		return p.SetIntArray(name, v.ints)
+	case uniformFloatArray:
+		return p.SetFloatArray(name, v.floats)
+	case uniformVec3Array:
+		return p.SetVec3Array(name, v.vec3s)
+	case uniformVec4Array:
+		return p.SetVec4Array(name, v.vec4s)
+	case uniformMat4Array:
+		return p.SetMat4Array(name, v.mat4s)
+	}
+	return fmt.Errorf("uniform %q: nothing to restore", name)
+}
+
+// setValue applies a value of any type a setter takes, as materials hold
+func (p *ShaderProgram) setValue(name string, v interface{}) error {
+	switch v := v.(type) {
+	case int32:
+		return p.SetInt(name, v)
+	case uint32:
+		return p.SetUint(name, v)
+	case float32:
+		return p.SetFloat(name, v)
+	case mgl32.Vec2:
+		return p.SetVec2(name, v)
+	case mgl32.Vec3:
+		return p.SetVec3(name, v)
+	case mgl32.Vec4:
+		return p.SetVec4(name, v)
+	case mgl32.Mat3:
+		return p.SetMat3(name, v)
+	case mgl32.Mat4:
+		return p.SetMat4(name, v)
+	case []int32:
+		return p.SetIntArray(name, v)
+	case []float32:
+		return p.SetFloatArray(name, v)
+	case []mgl32.Vec3:
+		return p.SetVec3Array(name, v)
+	case []mgl32.Vec4:
+		return p.SetVec4Array(name, v)
+	case []mgl32.Mat4:
+		return p.SetMat4Array(name, v)
+	}
+	return fmt.Errorf("uniform %q: unsupported value %T", name, v)
+}
+
+var intUniformTypes = []uint32{
+	gl.INT, gl.BOOL,
+	gl.SAMPLER_1D, gl.SAMPLER_2D, gl.SAMPLER_3D, gl.SAMPLER_CUBE,
+	gl.SAMPLER_2D_ARRAY, gl.SAMPLER_2D_SHADOW, gl.SAMPLER_BUFFER,
+	gl.INT_SAMPLER_2D, gl.UNSIGNED_INT_SAMPLER_2D,
+}
+
+var glTypeNames = map[uint32]string{
+	gl.FLOAT:                   "float",
+	gl.FLOAT_VEC2:              "vec2",
+	gl.FLOAT_VEC3:              "vec3",
+	gl.FLOAT_VEC4:              "vec4",
+	gl.INT:                     "int",
+	gl.UNSIGNED_INT:            "uint",
+	gl.BOOL:                    "bool",
+	gl.FLOAT_MAT3:              "mat3",
+	gl.FLOAT_MAT4:              "mat4",
+	gl.SAMPLER_1D:              "sampler1D",
+	gl.SAMPLER_2D:              "sampler2D",
+	gl.SAMPLER_3D:              "sampler3D",
+	gl.SAMPLER_CUBE:            "samplerCube",
+	gl.SAMPLER_2D_ARRAY:        "sampler2DArray",
+	gl.SAMPLER_2D_SHADOW:       "sampler2DShadow",
+	gl.SAMPLER_BUFFER:          "samplerBuffer",
+	gl.INT_SAMPLER_2D:          "isampler2D",
+	gl.UNSIGNED_INT_SAMPLER_2D: "usampler2D",
+}
+
+func glTypeName(t uint32) string {
+	if n, ok := glTypeNames[t]; ok {
+		return n
+	}
+	return fmt.Sprintf("type 0x%x", t)
+}
+
+func compileShader(src *ShaderSource, shaderType uint32) (uint32, error) {
 	shader := gl.CreateShader(shaderType)
 
-	csources, free := gl.Strs(source)
+	csources, free := gl.Strs(src.Code + "\x00")
 	gl.ShaderSource(shader, 1, csources, nil)
 	free()
 	gl.CompileShader(shader)
@@ -69,9 +716,44 @@ func compileShader(source string, shaderType uint32) (uint32, error) {
 
 		log := strings.Repeat("\x00", int(logLength+1))
 		gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
+		gl.DeleteShader(shader)
 
-		return 0, fmt.Errorf("failed to compile %v: %v", source, log)
+		log = strings.TrimRight(log, "\x00")
+		diags := ParseShaderInfoLog(log)
+		resolveDiagnostics(diags, src)
+		return 0, &ShaderCompileError{
+			Stage:       stageName(shaderType),
+			File:        mainFile(src),
+			Diagnostics: diags,
+			Log:         log,
+		}
 	}
 
 	return shader, nil
 }
+
+func stageName(shaderType uint32) string {
+	switch shaderType {
+	case gl.VERTEX_SHADER:
+		return "vertex"
+	case gl.FRAGMENT_SHADER:
+		return "fragment"
+	case gl.GEOMETRY_SHADER:
+		return "geometry"
+	case gl.TESS_CONTROL_SHADER:
+		return "tessellation control"
+	case gl.TESS_EVALUATION_SHADER:
+		return "tessellation evaluation"
+	case gl.COMPUTE_SHADER:
+		return "compute"
+	}
+	return fmt.Sprintf("shader 0x%x", shaderType)
+}
+
+// mainFile is the file a stage was loaded from, includes come after it
+func mainFile(src *ShaderSource) string {
+	if len(src.Files) == 0 {
+		return "<source>"
+	}
+	return src.Files[0]
+}
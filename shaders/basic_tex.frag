@@ -7,8 +7,9 @@ out vec4 color;
 
 uniform sampler2D texture1;
 uniform sampler2D texture2;
+uniform float mixValue;
 
 void main()
 {
-    color = mix(texture(texture1, TexCoord), texture(texture2, TexCoord), 0.2);
+    color = mix(texture(texture1, TexCoord), texture(texture2, TexCoord), mixValue);
 }
\ No newline at end of file
@@ -0,0 +1,9 @@
+#pragma once
+
+layout (std140) uniform PerFrame
+{
+    mat4 view;
+    mat4 projection;
+    vec3 cameraPosition;
+    float time;
+};
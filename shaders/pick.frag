@@ -0,0 +1,10 @@
+#version 330 core
+
+uniform uint pickID;
+
+out uint fragID;
+
+void main()
+{
+    fragID = pickID;
+}
@@ -0,0 +1,12 @@
+#version 330 core
+
+in vec3 Direction;
+
+out vec4 color;
+
+uniform samplerCube skybox;
+
+void main()
+{
+    color = texture(skybox, Direction);
+}
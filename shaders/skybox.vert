@@ -0,0 +1,17 @@
+#version 330 core
+layout (location = 0) in vec3 position;
+
+out vec3 Direction;
+
+#include "perframe.glsl"
+
+// The camera view without its translation, so the sky never gets closer
+uniform mat4 rotationView;
+
+void main()
+{
+    Direction = position;
+    vec4 pos = projection * rotationView * vec4(position, 1.0f);
+    // z = w puts the sky on the far plane, behind everything drawn before it
+    gl_Position = pos.xyww;
+}
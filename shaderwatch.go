@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// ShaderWatcher polls the source files of a set of programs and reloads a
+// program when one of its files changes. Polling happens on the render
+// thread so the swap never races with a draw.
+type ShaderWatcher struct {
+	Interval time.Duration
+
+	programs map[string]*ShaderProgram
+	modTimes map[string]time.Time // keyed by program name and file
+	lastPoll time.Time
+}
+
+// NewShaderWatcher watches every program in the registry, including ones added later
+func NewShaderWatcher(programs map[string]*ShaderProgram, interval time.Duration) *ShaderWatcher {
+	return &ShaderWatcher{
+		Interval: interval,
+		programs: programs,
+		modTimes: map[string]time.Time{},
+	}
+}
+
+// Poll checks for changed files at most once per interval and reloads the
+// affected programs. A failed reload keeps the previous program and is
+// reported in the returned errors.
+func (w *ShaderWatcher) Poll() []error {
+	now := time.Now()
+	if now.Sub(w.lastPoll) < w.Interval {
+		return nil
+	}
+	w.lastPoll = now
+
+	names := make([]string, 0, len(w.programs))
+	for name := range w.programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		prog := w.programs[name]
+		if !w.changed(name, prog.FS(), prog.SourceFiles()) {
+			continue
+		}
+		if err := prog.Reload(); err != nil {
+			if _, ok := err.(*UniformRestoreError); ok {
+				errs = append(errs, fmt.Errorf("shader %q reloaded: %v", name, err))
+				continue
+			}
+			errs = append(errs, fmt.Errorf("shader %q not reloaded: %v", name, err))
+			continue
+		}
+		fmt.Printf("shader %q reloaded\n", name)
+	}
+	return errs
+}
+
+// changed records the modification times of a program's files and reports
+// whether any differ from the last poll. Times are kept per program so a file
+// shared by several programs reloads all of them. Files seen for the first
+// time are not changes.
+func (w *ShaderWatcher) changed(name string, fsys fs.FS, files []string) bool {
+	changed := false
+	for _, f := range files {
+		info, err := fs.Stat(fsys, f)
+		if err != nil {
+			// the file may be mid-save, try again on the next poll
+			continue
+		}
+		key := name + "\x00" + f
+		last, seen := w.modTimes[key]
+		if seen && !info.ModTime().Equal(last) {
+			changed = true
+		}
+		w.modTimes[key] = info.ModTime()
+	}
+	return changed
+}
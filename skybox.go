@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"image/color"
+	"io/fs"
+	"path"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// DefaultSkybox is where the game looks for a sky: a directory of faces, a
+// cross or panorama image, or a KTX/DDS cubemap
+const DefaultSkybox = "textures/skybox"
+
+// Skybox draws a cubemap behind everything else. It is drawn after the
+// opaque geometry so the depth test skips every pixel already covered.
+type Skybox struct {
+	Program *ShaderProgram
+	Cubemap uint32
+
+	// VAO is a cube around the origin with positions at attribute 0
+	VAO uint32
+}
+
+func NewSkybox(fsys fs.FS, cubemap, vao uint32) (*Skybox, error) {
+	prog, err := NewShaderProgram(fsys, "shaders/skybox.vert", "shaders/skybox.frag")
+	if err != nil {
+		return nil, err
+	}
+	gl.Enable(gl.TEXTURE_CUBE_MAP_SEAMLESS)
+	return &Skybox{Program: prog, Cubemap: cubemap, VAO: vao}, nil
+}
+
+// LoadSkyboxCubemap loads the cubemap at file, or builds a plain gradient
+// sky if there is nothing there
+func LoadSkyboxCubemap(fsys fs.FS, file string) (uint32, error) {
+	if _, err := fs.Stat(fsys, path.Clean(file)); errors.Is(err, fs.ErrNotExist) {
+		faces := GradientCubeFaces(64,
+			color.RGBA{40, 90, 170, 255},
+			color.RGBA{170, 200, 225, 255},
+			color.RGBA{50, 55, 60, 255})
+		opts := DefaultTextureOptions()
+		opts.Mipmaps, opts.MinFilter = false, FilterLinear
+		return UploadCubemap(faces, opts)
+	}
+	return LoadCubemap(fsys, file)
+}
+
+// skyboxState passes the depth test at the far plane, where the sky is
+// drawn, and leaves the depth buffer alone
+var skyboxState = RenderState{
+	Blend:      BlendOpaque,
+	Cull:       CullNone,
+	DepthTest:  true,
+	DepthWrite: false,
+	DepthFunc:  "lequal",
+}
+
+// Draw renders the sky with a view matrix that has no translation, see
+// Camera.RotationView. The projection comes from the PerFrame block.
+func (s *Skybox) Draw(c *StateCache, rotationView mgl32.Mat4) error {
+	c.Apply(skyboxState)
+	c.UseProgram(s.Program.ID)
+	c.BindTexture(0, gl.TEXTURE_CUBE_MAP, s.Cubemap)
+	if err := s.Program.SetInt("skybox", 0); err != nil {
+		return err
+	}
+	if err := s.Program.SetMat4("rotationView", rotationView); err != nil {
+		return err
+	}
+	c.BindVertexArray(s.VAO)
+	gl.DrawArrays(gl.TRIANGLES, 0, 36)
+	return nil
+}
+
+// Delete frees the program and the cubemap, the VAO belongs to the caller
+func (s *Skybox) Delete() {
+	s.Program.Delete()
+	gl.DeleteTextures(1, &s.Cubemap)
+}
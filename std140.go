@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// std140 layout rules for Go values written into uniform blocks:
+//
+//   float32, int32, uint32, bool  4 byte aligned
+//   mgl32.Vec2                    8 byte aligned
+//   mgl32.Vec3, mgl32.Vec4        16 byte aligned, a vec3 leaves room for a
+//                                 following scalar in its last 4 bytes
+//   mgl32.Mat3, mgl32.Mat4        arrays of vec3/vec4 columns
+//   arrays and structs            16 byte aligned, element stride and struct
+//                                 size rounded up to 16
+//
+// Only exported struct fields are encoded, in declaration order.
+
+var (
+	vec2Type = reflect.TypeOf(mgl32.Vec2{})
+	vec3Type = reflect.TypeOf(mgl32.Vec3{})
+	vec4Type = reflect.TypeOf(mgl32.Vec4{})
+	mat3Type = reflect.TypeOf(mgl32.Mat3{})
+	mat4Type = reflect.TypeOf(mgl32.Mat4{})
+)
+
+// Std140Size returns the size in bytes of a value laid out as std140
+func Std140Size(v interface{}) (int, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return 0, fmt.Errorf("std140: nil value")
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	_, size, err := std140Layout(t)
+	return size, err
+}
+
+// EncodeStd140 lays a struct (or pointer to one) out as a std140 block
+func EncodeStd140(v interface{}) ([]byte, error) {
+	rv, size, err := std140Struct(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if err := writeStd140(buf, 0, rv); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// EncodeStd140Into is EncodeStd140 writing into buf, which must be the
+// size of the block, so a block updated every frame reuses one buffer
+func EncodeStd140Into(buf []byte, v interface{}) error {
+	rv, size, err := std140Struct(v)
+	if err != nil {
+		return err
+	}
+	if len(buf) != size {
+		return fmt.Errorf("std140: %T is %d bytes, the buffer holds %d", v, size, len(buf))
+	}
+	return writeStd140(buf, 0, rv)
+}
+
+func std140Struct(v interface{}) (reflect.Value, int, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if !rv.IsValid() || rv.Kind() != reflect.Struct {
+		return rv, 0, fmt.Errorf("std140: expected a struct, got %T", v)
+	}
+	_, size, err := std140Layout(rv.Type())
+	return rv, size, err
+}
+
+// Std140Offsets returns the byte offset of each field of a struct, which can
+// be compared with the offsets the driver reports for the block.
+func Std140Offsets(v interface{}) (map[string]int, error) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("std140: expected a struct, got %T", v)
+	}
+	offsets := map[string]int{}
+	offset := 0
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		align, size, err := std140Layout(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("std140: field %s: %v", f.Name, err)
+		}
+		offset = alignUp(offset, align)
+		offsets[f.Name] = offset
+		offset += size
+	}
+	return offsets, nil
+}
+
+// std140Layout returns the base alignment and size of a type
+func std140Layout(t reflect.Type) (int, int, error) {
+	switch t {
+	case vec2Type:
+		return 8, 8, nil
+	case vec3Type:
+		return 16, 12, nil
+	case vec4Type:
+		return 16, 16, nil
+	case mat3Type:
+		return 16, 3 * 16, nil
+	case mat4Type:
+		return 16, 4 * 16, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Float32, reflect.Int32, reflect.Uint32, reflect.Bool:
+		return 4, 4, nil
+	case reflect.Array:
+		_, elemSize, err := std140Layout(t.Elem())
+		if err != nil {
+			return 0, 0, err
+		}
+		stride := alignUp(elemSize, 16)
+		return 16, stride * t.Len(), nil
+	case reflect.Struct:
+		offset := 0
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			align, size, err := std140Layout(f.Type)
+			if err != nil {
+				return 0, 0, fmt.Errorf("field %s: %v", f.Name, err)
+			}
+			offset = alignUp(offset, align) + size
+		}
+		return 16, alignUp(offset, 16), nil
+	}
+	return 0, 0, fmt.Errorf("type %v has no std140 layout", t)
+}
+
+func writeStd140(buf []byte, offset int, v reflect.Value) error {
+	switch v.Type() {
+	case vec2Type, vec3Type, vec4Type:
+		for i := 0; i < v.Len(); i++ {
+			putFloat32(buf, offset+4*i, float32(v.Index(i).Float()))
+		}
+		return nil
+	case mat3Type:
+		for col := 0; col < 3; col++ {
+			for row := 0; row < 3; row++ {
+				putFloat32(buf, offset+16*col+4*row, float32(v.Index(col*3+row).Float()))
+			}
+		}
+		return nil
+	case mat4Type:
+		for i := 0; i < 16; i++ {
+			putFloat32(buf, offset+4*i, float32(v.Index(i).Float()))
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Float32:
+		putFloat32(buf, offset, float32(v.Float()))
+	case reflect.Int32:
+		binary.LittleEndian.PutUint32(buf[offset:], uint32(int32(v.Int())))
+	case reflect.Uint32:
+		binary.LittleEndian.PutUint32(buf[offset:], uint32(v.Uint()))
+	case reflect.Bool:
+		b := uint32(0)
+		if v.Bool() {
+			b = 1
+		}
+		binary.LittleEndian.PutUint32(buf[offset:], b)
+	case reflect.Array:
+		_, elemSize, err := std140Layout(v.Type().Elem())
+		if err != nil {
+			return err
+		}
+		stride := alignUp(elemSize, 16)
+		for i := 0; i < v.Len(); i++ {
+			if err := writeStd140(buf, offset+i*stride, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		fieldOffset := 0
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			align, size, err := std140Layout(f.Type)
+			if err != nil {
+				return err
+			}
+			fieldOffset = alignUp(fieldOffset, align)
+			if err := writeStd140(buf, offset+fieldOffset, v.Field(i)); err != nil {
+				return err
+			}
+			fieldOffset += size
+		}
+	default:
+		return fmt.Errorf("type %v has no std140 layout", v.Type())
+	}
+	return nil
+}
+
+func putFloat32(buf []byte, offset int, f float32) {
+	binary.LittleEndian.PutUint32(buf[offset:], math.Float32bits(f))
+}
+
+func alignUp(n, align int) int {
+	return (n + align - 1) / align * align
+}
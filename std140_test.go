@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestStd140Layout(t *testing.T) {
+	type scalars struct {
+		A float32
+		B int32
+		C uint32
+		D bool
+	}
+	type vec3Packing struct {
+		Position mgl32.Vec3
+		Radius   float32 // fills the vec3's last 4 bytes
+		Color    mgl32.Vec3
+		Normal   mgl32.Vec3 // a vec3 after a vec3 starts a new 16 bytes
+	}
+	type vec2s struct {
+		A float32
+		B mgl32.Vec2 // 8 byte aligned
+		C float32
+		D mgl32.Vec4
+	}
+	type matrices struct {
+		A float32
+		B mgl32.Mat4
+		C mgl32.Mat3
+		D float32
+	}
+	type arrays struct {
+		Weights [3]float32    // each element padded to 16
+		Lights  [2]mgl32.Vec3 // stride 16
+		Bones   [2]mgl32.Mat4
+		Count   int32
+	}
+	type inner struct {
+		A float32
+	}
+	type nested struct {
+		A      float32
+		Inner  inner // structs are 16 aligned and 16 sized
+		B      float32
+		hidden float32
+	}
+	tests := []struct {
+		name    string
+		v       interface{}
+		offsets map[string]int
+		size    int
+	}{
+		{"scalars", scalars{}, map[string]int{"A": 0, "B": 4, "C": 8, "D": 12}, 16},
+		{"vec3", vec3Packing{}, map[string]int{"Position": 0, "Radius": 12, "Color": 16, "Normal": 32}, 48},
+		{"vec2", vec2s{}, map[string]int{"A": 0, "B": 8, "C": 16, "D": 32}, 48},
+		{"matrices", matrices{}, map[string]int{"A": 0, "B": 16, "C": 80, "D": 128}, 144},
+		{"arrays", arrays{}, map[string]int{"Weights": 0, "Lights": 48, "Bones": 80, "Count": 208}, 224},
+		{"nested", nested{}, map[string]int{"A": 0, "Inner": 16, "B": 32}, 48},
+		{"PerFrame", PerFrame{}, map[string]int{"View": 0, "Projection": 64, "CameraPosition": 128, "Time": 140}, 144},
+	}
+	for _, tt := range tests {
+		offsets, err := Std140Offsets(tt.v)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(offsets, tt.offsets) {
+			t.Errorf("%s: offsets %v, want %v", tt.name, offsets, tt.offsets)
+		}
+		size, err := Std140Size(tt.v)
+		if err != nil || size != tt.size {
+			t.Errorf("%s: size %d, %v, want %d", tt.name, size, err, tt.size)
+		}
+		b, err := EncodeStd140(tt.v)
+		if err != nil || len(b) != tt.size {
+			t.Errorf("%s: encoded %d bytes, %v, want %d", tt.name, len(b), err, tt.size)
+		}
+	}
+}
+
+func TestEncodeStd140(t *testing.T) {
+	v := struct {
+		Weights [2]float32
+		Rot     mgl32.Mat3
+		Pos     mgl32.Vec3
+		On      bool
+	}{
+		Weights: [2]float32{1, 2},
+		Rot:     mgl32.Mat3{1, 2, 3, 4, 5, 6, 7, 8, 9},
+		Pos:     mgl32.Vec3{10, 11, 12},
+		On:      true,
+	}
+	b, err := EncodeStd140(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	float := func(offset int) float32 {
+		return math.Float32frombits(binary.LittleEndian.Uint32(b[offset:]))
+	}
+	want := map[int]float32{
+		0: 1, 16: 2, // array stride 16
+		32: 1, 36: 2, 40: 3, 48: 4, 52: 5, 56: 6, 64: 7, 68: 8, 72: 9, // mat3 columns padded to vec4
+		80: 10, 84: 11, 88: 12,
+	}
+	for offset, f := range want {
+		if got := float(offset); got != f {
+			t.Errorf("offset %d holds %v, want %v", offset, got, f)
+		}
+	}
+	if got := binary.LittleEndian.Uint32(b[92:]); got != 1 {
+		t.Errorf("bool encoded as %d, want 1", got)
+	}
+	for _, pad := range []int{4, 20, 44, 60, 76} {
+		if got := float(pad); got != 0 {
+			t.Errorf("padding at %d holds %v", pad, got)
+		}
+	}
+}
+
+func TestStd140Errors(t *testing.T) {
+	for _, v := range []interface{}{nil, 3, struct{ F float64 }{}, struct{ S []float32 }{}} {
+		if _, err := EncodeStd140(v); err == nil {
+			t.Errorf("%T encoded without error", v)
+		}
+	}
+}
+
+func TestEncodeStd140Into(t *testing.T) {
+	block := &PerFrame{Projection: mgl32.Ident4(), CameraPosition: mgl32.Vec3{1, 2, 3}, Time: 4}
+	want, err := EncodeStd140(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(want))
+	if err := EncodeStd140Into(buf, block); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, want) {
+		t.Error("encoding into a buffer differs from EncodeStd140")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { EncodeStd140Into(buf, block) }); allocs != 0 {
+		t.Errorf("encoding into a buffer allocates %v times", allocs)
+	}
+	if err := EncodeStd140Into(buf[:len(buf)-4], block); err == nil {
+		t.Error("encoded into a buffer that is too small")
+	}
+}
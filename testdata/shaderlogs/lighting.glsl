@@ -0,0 +1,5 @@
+uniform vec3 lightColor;
+
+vec3 shade(vec3 n) {
+    return lightColr * max(dot(n, vec3(0.0, 1.0, 0.0)), 0.0);
+}
@@ -0,0 +1,8 @@
+#version 330 core
+#include "lighting.glsl"
+
+out vec4 FragColor;
+
+void main() {
+    FragColor = vec4(shade(vec3(0.0, 1.0, 0.0)), 1.0);
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// TextureArray is a TEXTURE_2D_ARRAY built from images of one size, the
+// shader picks a layer with the third texture coordinate.
+type TextureArray struct {
+	ID            uint32
+	Width, Height int
+
+	// Layers are the names of the layers in order
+	Layers []string
+}
+
+// Layer is the index of the named layer
+func (a *TextureArray) Layer(name string) (int, bool) {
+	for i, l := range a.Layers {
+		if l == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Texture lets the array fill a sampler slot
+func (a *TextureArray) Texture() TextureSource {
+	return StaticTexture(a.ID, gl.TEXTURE_2D_ARRAY)
+}
+
+func (a *TextureArray) Delete() {
+	gl.DeleteTextures(1, &a.ID)
+}
+
+// textureArrayLevels builds the mip levels of every layer and checks the
+// layers agree on size and pixel format. The result is indexed
+// [layer][level].
+func textureArrayLevels(layers []image.Image, opts TextureOptions) ([][]*TexturePixels, error) {
+	if len(layers) == 0 {
+		return nil, errors.New("a texture array needs at least one layer")
+	}
+	out := make([][]*TexturePixels, len(layers))
+	for i, img := range layers {
+		levels, err := TextureLevels(img, opts)
+		if err != nil {
+			return nil, err
+		}
+		first, p := out[0], levels[0]
+		if i > 0 && (p.Width != first[0].Width || p.Height != first[0].Height) {
+			return nil, fmt.Errorf("layer %d is %dx%d, the first layer is %dx%d", i, p.Width, p.Height, first[0].Width, first[0].Height)
+		}
+		if i > 0 && (p.InternalFormat != first[0].InternalFormat || len(levels) != len(first)) {
+			return nil, fmt.Errorf("layer %d has a different pixel format from the first layer", i)
+		}
+		out[i] = levels
+	}
+	return out, nil
+}
+
+// UploadTextureArray creates a 2D array texture with one layer per image
+func UploadTextureArray(layers []image.Image, opts TextureOptions) (uint32, error) {
+	levels, err := textureArrayLevels(layers, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	var texture uint32
+	gl.GenTextures(1, &texture)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, texture)
+	applyTextureOptions(gl.TEXTURE_2D_ARRAY, opts)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	for level, top := range levels[0] {
+		gl.TexImage3D(gl.TEXTURE_2D_ARRAY, int32(level), top.InternalFormat, int32(top.Width), int32(top.Height), int32(len(layers)), 0, top.Format, top.Type, nil)
+		for layer := range levels {
+			p := levels[layer][level]
+			gl.TexSubImage3D(gl.TEXTURE_2D_ARRAY, int32(level), 0, 0, int32(layer), int32(p.Width), int32(p.Height), 1, p.Format, p.Type, gl.Ptr(p.Pix))
+		}
+	}
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 4)
+	if levels[0][0].Format == gl.RED {
+		swizzle := []int32{gl.RED, gl.RED, gl.RED, gl.ONE}
+		gl.TexParameteriv(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_SWIZZLE_RGBA, &swizzle[0])
+	}
+	if opts.Mipmaps && len(levels[0]) == 1 {
+		gl.GenerateMipmap(gl.TEXTURE_2D_ARRAY)
+	}
+	return texture, nil
+}
+
+// LoadTextureArray makes one layer of every image in dir, in file name
+// order, named by file name without the extension. Options come from the
+// directory's name and sidecar, e.g. textures/terrain.json.
+func LoadTextureArray(fsys fs.FS, dir string) (*TextureArray, error) {
+	opts, err := LoadTextureOptions(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := fs.ReadDir(fsys, path.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	array := &TextureArray{}
+	var layers []image.Image
+	for _, f := range files {
+		if f.IsDir() || IsTextureSidecar(f.Name()) {
+			continue
+		}
+		img, err := DecodeImage(fsys, path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, img)
+		array.Layers = append(array.Layers, strings.TrimSuffix(f.Name(), path.Ext(f.Name())))
+	}
+	if array.ID, err = UploadTextureArray(layers, opts); err != nil {
+		return nil, fmt.Errorf("texture array %q: %v", dir, err)
+	}
+	size := layers[0].Bounds().Size()
+	array.Width, array.Height = size.X, size.Y
+	return array, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io/fs"
+	"time"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// Texture is a texture that may still be loading. ID is 0 until the pixels
+// have been uploaded, Err is set if the file could not be decoded.
+type Texture struct {
+	ID      uint32
+	Target  uint32 // TEXTURE_2D, or the cubemap or array target of a container
+	File    string
+	Options TextureOptions
+	Err     error
+
+	placeholder uint32
+	done        bool
+	released    bool
+}
+
+// Ready reports whether loading has finished, successfully or not
+func (t *Texture) Ready() bool {
+	return t.done
+}
+
+func (t *Texture) free() {
+	t.released = true
+	if t.ID != 0 {
+		gl.DeleteTextures(1, &t.ID)
+		t.ID = 0
+	}
+}
+
+// TextureLoader decodes images and containers, and builds CPU mip chains, on a pool of goroutines and uploads them on
+// the render thread, a few per frame, so startup doesn't wait for every
+// texture and a large batch doesn't stall a single frame.
+type TextureLoader struct {
+	FS fs.FS
+
+	// UploadBudget is how long Upload may spend per call, at least one
+	// texture is uploaded each call so loading always makes progress.
+	UploadBudget time.Duration
+
+	// Placeholder is a 1x1 grey texture handed out until a texture is ready
+	Placeholder uint32
+
+	workers chan struct{}
+	results chan textureResult
+	closed  chan struct{}
+	pending int
+}
+
+type textureResult struct {
+	tex  *Texture
+	data *textureData
+	opts TextureOptions
+	err  error
+}
+
+var placeholderOptions = TextureOptions{
+	MinFilter: FilterNearest,
+	MagFilter: FilterNearest,
+	WrapS:     WrapRepeat,
+	WrapT:     WrapRepeat,
+}
+
+// NewTextureLoader starts a loader decoding up to workers files at once. It
+// creates the placeholder texture so it must be called with a GL context.
+func NewTextureLoader(fsys fs.FS, workers int) *TextureLoader {
+	if workers < 1 {
+		workers = 1
+	}
+	grey := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	grey.SetRGBA(0, 0, color.RGBA{128, 128, 128, 255})
+	return &TextureLoader{
+		FS:           fsys,
+		UploadBudget: 2 * time.Millisecond,
+		Placeholder:  UploadTexture(grey, placeholderOptions),
+		workers:      make(chan struct{}, workers),
+		results:      make(chan textureResult),
+		closed:       make(chan struct{}),
+	}
+}
+
+// Load queues tex.File for decoding and returns straight away
+func (l *TextureLoader) Load(tex *Texture) {
+	tex.placeholder = l.Placeholder
+	l.pending++
+	go func() {
+		select {
+		case l.workers <- struct{}{}:
+		case <-l.closed:
+			return
+		}
+		opts, data, err := decodeTextureFile(l.FS, tex.File)
+		<-l.workers
+
+		select {
+		case l.results <- textureResult{tex: tex, data: data, opts: opts, err: err}:
+		case <-l.closed:
+		}
+	}()
+}
+
+// Pending is the number of textures queued or decoded but not yet uploaded
+func (l *TextureLoader) Pending() int {
+	return l.pending
+}
+
+// Upload finishes decoded textures until the budget is spent and returns
+// the errors of the ones that failed. It must be called on the render thread.
+func (l *TextureLoader) Upload() []error {
+	var errs []error
+	start := time.Now()
+	for uploaded := 0; l.pending > 0; uploaded++ {
+		if uploaded > 0 && time.Since(start) >= l.UploadBudget {
+			break
+		}
+		select {
+		case r := <-l.results:
+			if err := l.finish(r); err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			return errs
+		}
+	}
+	return errs
+}
+
+// Finish waits for and uploads everything still loading, for loading
+// screens and tools that need all textures before continuing.
+func (l *TextureLoader) Finish() []error {
+	var errs []error
+	for l.pending > 0 {
+		if err := l.finish(<-l.results); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (l *TextureLoader) finish(r textureResult) error {
+	l.pending--
+	tex := r.tex
+	tex.done = true
+	if r.err != nil {
+		tex.Err = r.err
+		return r.err
+	}
+	// Released while it was decoding, nobody is left to use it
+	if tex.released {
+		return nil
+	}
+	tex.Options = r.opts
+	tex.ID, tex.Target, tex.Err = r.data.upload(r.opts)
+	return tex.Err
+}
+
+// Close abandons anything still loading and deletes the placeholder
+func (l *TextureLoader) Close() {
+	close(l.closed)
+	l.pending = 0
+	gl.DeleteTextures(1, &l.Placeholder)
+}
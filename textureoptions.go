@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Texture filters, the mipmap ones are only valid for the min filter
+const (
+	FilterNearest              = "nearest"
+	FilterLinear               = "linear"
+	FilterNearestMipmapNearest = "nearestMipmapNearest"
+	FilterLinearMipmapNearest  = "linearMipmapNearest"
+	FilterNearestMipmapLinear  = "nearestMipmapLinear"
+	FilterLinearMipmapLinear   = "linearMipmapLinear"
+)
+
+// Texture wrap modes
+const (
+	WrapRepeat = "repeat"
+	WrapClamp  = "clamp"
+	WrapMirror = "mirror"
+	WrapBorder = "border"
+)
+
+// TextureSidecarExt is appended to a texture's file name to give the file
+// holding its options, e.g. container.jpg.json
+const TextureSidecarExt = ".json"
+
+var textureFilters = map[string]int32{
+	FilterNearest:              gl.NEAREST,
+	FilterLinear:               gl.LINEAR,
+	FilterNearestMipmapNearest: gl.NEAREST_MIPMAP_NEAREST,
+	FilterLinearMipmapNearest:  gl.LINEAR_MIPMAP_NEAREST,
+	FilterNearestMipmapLinear:  gl.NEAREST_MIPMAP_LINEAR,
+	FilterLinearMipmapLinear:   gl.LINEAR_MIPMAP_LINEAR,
+}
+
+var textureWraps = map[string]int32{
+	WrapRepeat: gl.REPEAT,
+	WrapClamp:  gl.CLAMP_TO_EDGE,
+	WrapMirror: gl.MIRRORED_REPEAT,
+	WrapBorder: gl.CLAMP_TO_BORDER,
+}
+
+// TextureOptions controls how a texture is sampled and stored
+type TextureOptions struct {
+	MinFilter string `json:"minFilter"`
+	MagFilter string `json:"magFilter"`
+	WrapS     string `json:"wrapS"`
+	WrapT     string `json:"wrapT"`
+	Mipmaps   bool   `json:"mipmaps"`
+
+	// MipFilter builds the mip chain on the CPU with GenerateMips, empty
+	// leaves it to the driver. AlphaCutoff and NormalMap only apply then.
+	MipFilter   string  `json:"mipFilter"`
+	AlphaCutoff float32 `json:"alphaCutoff"`
+	NormalMap   bool    `json:"normalMap"`
+
+	// Anisotropy above 1 enables anisotropic filtering, it is clamped to
+	// what the driver supports
+	Anisotropy  float32    `json:"anisotropy"`
+	BorderColor mgl32.Vec4 `json:"borderColor"`
+
+	// SRGB stores colour textures in an sRGB format so sampling returns
+	// linear values, leave it off for data such as normal maps.
+	SRGB bool `json:"srgb"`
+}
+
+// DefaultTextureOptions are trilinear filtering with mipmaps, which keeps
+// distant surfaces from shimmering.
+func DefaultTextureOptions() TextureOptions {
+	return TextureOptions{
+		MinFilter:  FilterLinearMipmapLinear,
+		MagFilter:  FilterLinear,
+		WrapS:      WrapClamp,
+		WrapT:      WrapClamp,
+		Mipmaps:    true,
+		Anisotropy: 4,
+	}
+}
+
+func (o TextureOptions) Validate() error {
+	if _, ok := textureFilters[o.MinFilter]; !ok {
+		return fmt.Errorf("unknown min filter %q", o.MinFilter)
+	}
+	if o.MagFilter != FilterNearest && o.MagFilter != FilterLinear {
+		return fmt.Errorf("mag filter must be %q or %q, not %q", FilterNearest, FilterLinear, o.MagFilter)
+	}
+	if o.MinFilter != FilterNearest && o.MinFilter != FilterLinear && !o.Mipmaps {
+		return fmt.Errorf("min filter %q needs mipmaps", o.MinFilter)
+	}
+	for _, w := range []string{o.WrapS, o.WrapT} {
+		if _, ok := textureWraps[w]; !ok {
+			return fmt.Errorf("unknown wrap mode %q", w)
+		}
+	}
+	if _, ok := mipKernels[o.MipFilter]; o.MipFilter != "" && !ok {
+		return fmt.Errorf("unknown mip filter %q", o.MipFilter)
+	}
+	if o.NormalMap && o.SRGB {
+		return errors.New("a normal map cannot be sRGB")
+	}
+	if o.Anisotropy < 0 {
+		return errors.New("anisotropy must not be negative")
+	}
+	return nil
+}
+
+// applyTextureTags changes options by the tags at the end of a file name,
+// so bricks_nearest_repeat.png gets nearest filtering and repeat wrapping.
+// Tags: nearest, repeat, clamp, mirror, nomip, srgb, raw (not sRGB),
+// normal (a normal map, mipped on the CPU) and cutout (alpha tested at 0.5).
+func applyTextureTags(o *TextureOptions, file string) {
+	base := path.Base(file)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	parts := strings.Split(strings.ToLower(base), "_")
+	for i := len(parts) - 1; i > 0; i-- {
+		switch parts[i] {
+		case "nearest":
+			o.MinFilter, o.MagFilter = FilterNearest, FilterNearest
+			if o.Mipmaps {
+				o.MinFilter = FilterNearestMipmapNearest
+			}
+		case "repeat":
+			o.WrapS, o.WrapT = WrapRepeat, WrapRepeat
+		case "clamp":
+			o.WrapS, o.WrapT = WrapClamp, WrapClamp
+		case "mirror":
+			o.WrapS, o.WrapT = WrapMirror, WrapMirror
+		case "nomip":
+			*o = o.withoutMipmaps()
+		case "srgb":
+			o.SRGB = true
+		case "raw":
+			o.SRGB = false
+		case "normal":
+			o.NormalMap, o.SRGB = true, false
+			if o.MipFilter == "" {
+				o.MipFilter = MipFilterKaiser
+			}
+		case "cutout":
+			o.AlphaCutoff = 0.5
+			if o.MipFilter == "" {
+				o.MipFilter = MipFilterKaiser
+			}
+		default:
+			return
+		}
+	}
+}
+
+// withoutMipmaps turns mipmapping off and drops the mip part of the filter
+func (o TextureOptions) withoutMipmaps() TextureOptions {
+	o.Mipmaps = false
+	switch o.MinFilter {
+	case FilterNearestMipmapNearest, FilterNearestMipmapLinear:
+		o.MinFilter = FilterNearest
+	case FilterLinearMipmapNearest, FilterLinearMipmapLinear:
+		o.MinFilter = FilterLinear
+	}
+	return o
+}
+
+// LoadTextureOptions returns the options for a texture file: the defaults,
+// changed by tags in the file name, then by the sidecar file if there is one.
+// The sidecar only needs the fields it changes.
+func LoadTextureOptions(fsys fs.FS, file string) (TextureOptions, error) {
+	opts := DefaultTextureOptions()
+	applyTextureTags(&opts, file)
+
+	b, err := fs.ReadFile(fsys, path.Clean(file)+TextureSidecarExt)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return opts, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return opts, fmt.Errorf("texture options %q: %v", file+TextureSidecarExt, err)
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return opts, fmt.Errorf("texture options for %q: %v", file, err)
+	}
+	return opts, nil
+}
+
+// IsTextureSidecar reports whether a file in a texture directory holds
+// options rather than an image. Sidecars are named after their image, like
+// "wall.png.json", so other JSON files such as a manifest.json are not.
+func IsTextureSidecar(name string) bool {
+	ext := path.Ext(name)
+	return strings.EqualFold(ext, TextureSidecarExt) && path.Ext(strings.TrimSuffix(name, ext)) != ""
+}
+
+var maxAnisotropy float32 = -1
+
+// applyTextureOptions sets the sampling parameters of the bound texture
+func applyTextureOptions(target uint32, o TextureOptions) {
+	gl.TexParameteri(target, gl.TEXTURE_MIN_FILTER, textureFilters[o.MinFilter])
+	gl.TexParameteri(target, gl.TEXTURE_MAG_FILTER, textureFilters[o.MagFilter])
+	gl.TexParameteri(target, gl.TEXTURE_WRAP_S, textureWraps[o.WrapS])
+	gl.TexParameteri(target, gl.TEXTURE_WRAP_T, textureWraps[o.WrapT])
+	if o.WrapS == WrapBorder || o.WrapT == WrapBorder {
+		gl.TexParameterfv(target, gl.TEXTURE_BORDER_COLOR, &o.BorderColor[0])
+	}
+
+	if o.Anisotropy > 1 {
+		// Core since 4.6 and a near universal extension before, a driver
+		// without it reports 0 and the setting is skipped
+		if maxAnisotropy < 0 {
+			maxAnisotropy = 0
+			gl.GetFloatv(gl.MAX_TEXTURE_MAX_ANISOTROPY, &maxAnisotropy)
+			clearGLErrors()
+		}
+		if maxAnisotropy > 1 {
+			gl.TexParameterf(target, gl.TEXTURE_MAX_ANISOTROPY, mgl32.Clamp(o.Anisotropy, 1, maxAnisotropy))
+		}
+	}
+}
+
+// clearGLErrors drops pending errors such as the INVALID_ENUM from probing
+// an optional feature, bounded as a lost context can report errors forever
+func clearGLErrors() {
+	for i := 0; i < 16 && gl.GetError() != gl.NO_ERROR; i++ {
+	}
+}
+
+func (o TextureOptions) mipOptions() MipOptions {
+	return MipOptions{
+		Filter:      o.MipFilter,
+		SRGB:        o.SRGB,
+		AlphaCutoff: o.AlphaCutoff,
+		NormalMap:   o.NormalMap,
+		Wrap:        o.WrapS == WrapRepeat && o.WrapT == WrapRepeat,
+	}
+}
+
+// internalFormat is the sized format for 8 bit RGBA data with the options
+func (o TextureOptions) internalFormat() int32 {
+	if o.SRGB {
+		return gl.SRGB8_ALPHA8
+	}
+	return gl.RGBA8
+}
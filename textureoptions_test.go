@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestIsTextureSidecar(t *testing.T) {
+	tests := map[string]bool{
+		"wall.png.json":      true,
+		"Wall.PNG.JSON":      true,
+		"container.jpg.json": true,
+		"manifest.json":      false,
+		"atlas.json":         false,
+		".json":              false,
+		"wall.png":           false,
+		"wall.json.png":      false,
+	}
+	for name, want := range tests {
+		if got := IsTextureSidecar(name); got != want {
+			t.Errorf("IsTextureSidecar(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLoadTextureOptionsSidecar(t *testing.T) {
+	fsys := fstest.MapFS{
+		"textures/wall.png.json": {Data: []byte(`{"wrapS": "clamp", "anisotropy": 8}`)},
+		"textures/bad.png.json":  {Data: []byte(`{"wrapS": "sideways"}`)},
+		"textures/junk.png.json": {Data: []byte(`{`)},
+	}
+	opts, err := LoadTextureOptions(fsys, "textures/wall.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := DefaultTextureOptions()
+	if opts.WrapS != WrapClamp || opts.Anisotropy != 8 || opts.WrapT != def.WrapT || opts.MinFilter != def.MinFilter {
+		t.Errorf("got %+v, want the defaults with wrapS and anisotropy changed", opts)
+	}
+	if opts, err := LoadTextureOptions(fsys, "textures/none.png"); err != nil || opts != def {
+		t.Errorf("without a sidecar got %+v, %v, want the defaults", opts, err)
+	}
+	for _, file := range []string{"textures/bad.png", "textures/junk.png"} {
+		if _, err := LoadTextureOptions(fsys, file); err == nil {
+			t.Errorf("%s: no error", file)
+		}
+	}
+}
@@ -1,70 +1,282 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/draw"
 	_ "image/jpeg"
 	_ "image/png"
-	"io/ioutil"
-	"os"
+	"io/fs"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
 
 	"github.com/go-gl/gl/v3.3-core/gl"
 )
 
-func LoadTextures(path string) (map[string]uint32, error) {
-	files, err := ioutil.ReadDir(path)
+// maxImageSize is the largest width or height the decoders accept, a
+// corrupt header can't make them allocate more than this
+const maxImageSize = 16384
+
+// checkImageSize rejects dimensions past maxImageSize before anything is
+// allocated for them, format prefixes the error
+func checkImageSize(format string, w, h int) error {
+	if w > maxImageSize || h > maxImageSize {
+		return fmt.Errorf("%s: %dx%d image is larger than the %d pixel limit", format, w, h, maxImageSize)
+	}
+	return nil
+}
+
+// LoadTextures loads every file in dir, keyed by file name. Files are
+// decoded in parallel and a file that fails to load is left out of the map
+// instead of stopping the rest, the returned error lists every failure.
+// Sampling options come from each file's name and sidecar, see
+// LoadTextureOptions.
+func LoadTextures(fsys fs.FS, dir string) (map[string]uint32, error) {
+	files, err := fs.ReadDir(fsys, path.Clean(dir))
 	if err != nil {
 		return nil, err
 	}
 
-	textures := map[string]uint32{}
+	loader := NewTextureLoader(fsys, runtime.NumCPU())
+	defer loader.Close()
 
+	loading := map[string]*Texture{}
 	for _, f := range files {
-		fmt.Println(f.Name())
-		t, err := NewTexture(fmt.Sprintf("%s/%s", path, f.Name()))
-		if err != nil {
-			return nil, err
+		if f.IsDir() || IsTextureSidecar(f.Name()) {
+			continue
 		}
-		textures[f.Name()] = t
+		tex := &Texture{File: path.Join(dir, f.Name())}
+		loader.Load(tex)
+		loading[f.Name()] = tex
+	}
+
+	textures := map[string]uint32{}
+	var failed []string
+	for _, err := range loader.Finish() {
+		failed = append(failed, err.Error())
+	}
+	for name, tex := range loading {
+		if tex.Err == nil {
+			textures[name] = tex.ID
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return textures, errors.New(strings.Join(failed, "\n"))
 	}
 	return textures, nil
 }
 
-func NewTexture(file string) (uint32, error) {
-	imgFile, err := os.Open(file)
+func NewTexture(fsys fs.FS, file string) (uint32, error) {
+	opts, data, err := decodeTextureFile(fsys, file)
+	if err != nil {
+		return 0, err
+	}
+	id, _, err := data.upload(opts)
+	return id, err
+}
+
+// textureData is a decoded texture waiting for upload on the GL thread,
+// either pixel levels or block compressed data
+type textureData struct {
+	levels     []*TexturePixels
+	compressed *CompressedTexture
+}
+
+// decodeTextureFile does all the work of loading file that needs no GL
+// context: options, decoding and CPU mips.
+func decodeTextureFile(fsys fs.FS, file string) (TextureOptions, *textureData, error) {
+	opts, err := LoadTextureOptions(fsys, file)
 	if err != nil {
-		return 0, fmt.Errorf("texture %q not found on disk: %v", file, err)
+		return opts, nil, err
+	}
+	if IsCompressedTextureFile(file) {
+		t, err := LoadCompressedTexture(fsys, file)
+		if err != nil {
+			return opts, nil, err
+		}
+		return opts, &textureData{compressed: t}, nil
 	}
-	img, _, err := image.Decode(imgFile)
+	img, err := DecodeImage(fsys, file)
 	if err != nil {
-		return 0, err
+		return opts, nil, err
+	}
+	levels, err := TextureLevels(img, opts)
+	if err != nil {
+		return opts, nil, fmt.Errorf("texture %q: %v", file, err)
+	}
+	return opts, &textureData{levels: levels}, nil
+}
+
+// upload returns the texture and the target it is bound to
+func (d *textureData) upload(opts TextureOptions) (uint32, uint32, error) {
+	if d.compressed != nil {
+		id, err := UploadCompressedTexture(d.compressed, opts)
+		return id, d.compressed.Target(), err
+	}
+	return UploadTextureLevels(d.levels, opts), gl.TEXTURE_2D, nil
+}
+
+// DecodeImage reads any supported image: PNG (8 and 16 bit), JPEG, BMP,
+// TGA and Radiance HDR. KTX and DDS files are read by
+// LoadCompressedTexture instead. It makes no GL calls so it can run on any goroutine.
+func DecodeImage(fsys fs.FS, file string) (image.Image, error) {
+	imgFile, err := fsys.Open(path.Clean(file))
+	if err != nil {
+		return nil, fmt.Errorf("texture %q not found: %v", file, err)
+	}
+	defer imgFile.Close()
+
+	var img image.Image
+	if strings.EqualFold(path.Ext(file), ".tga") {
+		img, err = DecodeTGA(imgFile)
+	} else {
+		img, _, err = image.Decode(imgFile)
 	}
+	if err != nil {
+		return nil, fmt.Errorf("texture %q: %v", file, err)
+	}
+	return img, nil
+}
 
+// DecodeTexture reads an image converted to 8 bit RGBA
+func DecodeTexture(fsys fs.FS, file string) (*image.RGBA, error) {
+	img, err := DecodeImage(fsys, file)
+	if err != nil {
+		return nil, err
+	}
+	return ToRGBA(img), nil
+}
+
+// ToRGBA returns img as tightly packed 8 bit RGBA, converting if needed
+func ToRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Stride == rgba.Rect.Dx()*4 {
+		return rgba
+	}
 	rgba := image.NewRGBA(img.Bounds())
-	if rgba.Stride != rgba.Rect.Size().X*4 {
-		return 0, fmt.Errorf("unsupported stride")
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// TexturePixels is one tightly packed texture level with the formats to
+// pass to TexImage2D
+type TexturePixels struct {
+	Width, Height  int
+	InternalFormat int32
+	Format, Type   uint32
+	Pix            interface{} // []uint8, []uint16 or []float32
+}
+
+// NewTexturePixels keeps the precision of the image: HDR images become
+// RGB16F, 16 bit grey R16 and 16 bit colour RGBA16. Everything else is
+// 8 bit RGBA, in an sRGB format if the options ask for it.
+func NewTexturePixels(img image.Image, opts TextureOptions) *TexturePixels {
+	size := img.Bounds().Size()
+	p := &TexturePixels{Width: size.X, Height: size.Y}
+	switch img := img.(type) {
+	case *HDRImage:
+		pix := make([]float32, 0, size.X*size.Y*3)
+		for y := 0; y < size.Y; y++ {
+			pix = append(pix, img.Pix[y*img.Stride:y*img.Stride+size.X*3]...)
+		}
+		p.InternalFormat, p.Format, p.Type, p.Pix = gl.RGB16F, gl.RGB, gl.FLOAT, pix
+	case *image.Gray16:
+		p.InternalFormat, p.Format, p.Type = gl.R16, gl.RED, gl.UNSIGNED_SHORT
+		p.Pix = unpack16(img.Pix, img.Stride, size.X, size.Y)
+	case *image.RGBA64:
+		p.InternalFormat, p.Format, p.Type = gl.RGBA16, gl.RGBA, gl.UNSIGNED_SHORT
+		p.Pix = unpack16(img.Pix, img.Stride, size.X*4, size.Y)
+	case *image.NRGBA64:
+		// premultiplied like the 8 bit path, 16 bit PNGs with alpha decode
+		// to this and must blend the same as their 8 bit versions
+		rgba := image.NewRGBA64(img.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+		p.InternalFormat, p.Format, p.Type = gl.RGBA16, gl.RGBA, gl.UNSIGNED_SHORT
+		p.Pix = unpack16(rgba.Pix, rgba.Stride, size.X*4, size.Y)
+	default:
+		p.InternalFormat, p.Format, p.Type = opts.internalFormat(), gl.RGBA, gl.UNSIGNED_BYTE
+		p.Pix = ToRGBA(img).Pix
+	}
+	return p
+}
+
+// unpack16 turns the big endian samples of the 16 bit image types into
+// native uint16s, dropping any row padding
+func unpack16(pix []uint8, stride, samples, rows int) []uint16 {
+	out := make([]uint16, 0, samples*rows)
+	for y := 0; y < rows; y++ {
+		row := pix[y*stride:]
+		for i := 0; i < samples; i++ {
+			out = append(out, uint16(row[i*2])<<8|uint16(row[i*2+1]))
+		}
 	}
-	draw.Draw(rgba, rgba.Bounds(), img, image.Point{0, 0}, draw.Src)
+	return out
+}
+
+// TextureLevels returns the mip levels to upload for an image: the full
+// chain when the options ask for CPU mips, otherwise just the image. CPU
+// mips are only built for 8 bit images, the driver mips the others.
+func TextureLevels(img image.Image, opts TextureOptions) ([]*TexturePixels, error) {
+	top := NewTexturePixels(img, opts)
+	if !opts.Mipmaps || opts.MipFilter == "" || top.Type != gl.UNSIGNED_BYTE {
+		return []*TexturePixels{top}, nil
+	}
+	mips, err := GenerateMips(ToRGBA(img), opts.mipOptions())
+	if err != nil {
+		return nil, err
+	}
+	levels := []*TexturePixels{top}
+	for _, m := range mips[1:] {
+		levels = append(levels, NewTexturePixels(m, opts))
+	}
+	return levels, nil
+}
 
+// UploadTexture creates a GL texture from a decoded image, it must be
+// called on the thread that owns the GL context.
+func UploadTexture(img image.Image, opts TextureOptions) uint32 {
+	levels, err := TextureLevels(img, opts)
+	if err != nil {
+		// Only an invalid filter fails, which Validate rejects earlier
+		opts.MipFilter = ""
+		levels, _ = TextureLevels(img, opts)
+	}
+	return UploadTextureLevels(levels, opts)
+}
+
+// UploadTextureLevels creates a GL texture from a mip chain. With a single
+// level and Mipmaps set the driver generates the rest.
+func UploadTextureLevels(levels []*TexturePixels, opts TextureOptions) uint32 {
 	var texture uint32
 	gl.GenTextures(1, &texture)
 	//gl.ActiveTexture(gl.TEXTURE0 + uint32(num))
 	gl.BindTexture(gl.TEXTURE_2D, texture)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
-	gl.TexImage2D(
-		gl.TEXTURE_2D,
-		0,
-		gl.RGBA,
-		int32(rgba.Rect.Size().X),
-		int32(rgba.Rect.Size().Y),
-		0,
-		gl.RGBA,
-		gl.UNSIGNED_BYTE,
-		gl.Ptr(rgba.Pix))
-
-	return texture, nil
+	applyTextureOptions(gl.TEXTURE_2D, opts)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	for level, p := range levels {
+		gl.TexImage2D(
+			gl.TEXTURE_2D,
+			int32(level),
+			p.InternalFormat,
+			int32(p.Width),
+			int32(p.Height),
+			0,
+			p.Format,
+			p.Type,
+			gl.Ptr(p.Pix))
+	}
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 4)
+	// Single channel data such as heightmaps samples as grey, not red
+	if levels[0].Format == gl.RED {
+		swizzle := []int32{gl.RED, gl.RED, gl.RED, gl.ONE}
+		gl.TexParameteriv(gl.TEXTURE_2D, gl.TEXTURE_SWIZZLE_RGBA, &swizzle[0])
+	}
+	if opts.Mipmaps && len(levels) == 1 {
+		gl.GenerateMipmap(gl.TEXTURE_2D)
+	}
+
+	return texture
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// TGA image types
+const (
+	tgaColorMapped    = 1
+	tgaTrueColor      = 2
+	tgaGrey           = 3
+	tgaRLEColorMapped = 9
+	tgaRLETrueColor   = 10
+	tgaRLEGrey        = 11
+)
+
+type tgaHeader struct {
+	IDLength      uint8
+	ColorMapType  uint8
+	ImageType     uint8
+	ColorMapFirst uint16
+	ColorMapLen   uint16
+	ColorMapBits  uint8
+	XOrigin       uint16
+	YOrigin       uint16
+	Width         uint16
+	Height        uint16
+	Bits          uint8
+	Descriptor    uint8
+}
+
+// DecodeTGA reads an uncompressed or RLE Truevision TGA image. TGA files
+// have no magic number, so unlike BMP and HDR it is not registered with the
+// image package and DecodeImage picks it by extension.
+func DecodeTGA(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	var h tgaHeader
+	if err := binary.Read(br, binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("tga: %v", err)
+	}
+	if _, err := br.Discard(int(h.IDLength)); err != nil {
+		return nil, fmt.Errorf("tga: %v", err)
+	}
+
+	rle := h.ImageType >= tgaRLEColorMapped
+	kind := h.ImageType
+	if rle {
+		kind -= tgaRLEColorMapped - tgaColorMapped
+	}
+	if kind != tgaColorMapped && kind != tgaTrueColor && kind != tgaGrey {
+		return nil, fmt.Errorf("tga: unsupported image type %d", h.ImageType)
+	}
+	if h.Width == 0 || h.Height == 0 {
+		return nil, errors.New("tga: empty image")
+	}
+	if err := checkImageSize("tga", int(h.Width), int(h.Height)); err != nil {
+		return nil, err
+	}
+
+	var palette []color.NRGBA
+	if h.ColorMapType == 1 {
+		entry := (int(h.ColorMapBits) + 7) / 8
+		raw := make([]byte, int(h.ColorMapLen)*entry)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return nil, fmt.Errorf("tga: color map: %v", err)
+		}
+		for i := 0; i < int(h.ColorMapLen); i++ {
+			c, err := tgaColor(raw[i*entry:(i+1)*entry], h.ColorMapBits, true)
+			if err != nil {
+				return nil, err
+			}
+			palette = append(palette, c)
+		}
+	}
+	if kind == tgaColorMapped && palette == nil {
+		return nil, errors.New("tga: color mapped image without a color map")
+	}
+
+	size := (int(h.Bits) + 7) / 8
+	if size < 1 || size > 4 {
+		return nil, fmt.Errorf("tga: unsupported bit depth %d", h.Bits)
+	}
+	w, ht := int(h.Width), int(h.Height)
+	raw := make([]byte, w*ht*size)
+	if rle {
+		if err := tgaReadRLE(br, raw, size); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.ReadFull(br, raw); err != nil {
+		return nil, fmt.Errorf("tga: %v", err)
+	}
+
+	alpha := h.Descriptor&0x0f != 0
+	rightToLeft := h.Descriptor&0x10 != 0
+	topToBottom := h.Descriptor&0x20 != 0
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, ht))
+	for y := 0; y < ht; y++ {
+		for x := 0; x < w; x++ {
+			px := raw[(y*w+x)*size : (y*w+x+1)*size]
+			var c color.NRGBA
+			switch kind {
+			case tgaColorMapped:
+				index := int(px[0])
+				if size > 1 {
+					index |= int(px[1]) << 8
+				}
+				index -= int(h.ColorMapFirst)
+				if index < 0 || index >= len(palette) {
+					return nil, fmt.Errorf("tga: color index %d out of range", index)
+				}
+				c = palette[index]
+			case tgaGrey:
+				c = color.NRGBA{px[0], px[0], px[0], 255}
+				if size == 2 && alpha {
+					c.A = px[1]
+				}
+			default:
+				var err error
+				if c, err = tgaColor(px, h.Bits, alpha); err != nil {
+					return nil, err
+				}
+			}
+			dx, dy := x, ht-1-y
+			if rightToLeft {
+				dx = w - 1 - x
+			}
+			if topToBottom {
+				dy = y
+			}
+			img.SetNRGBA(dx, dy, c)
+		}
+	}
+	return img, nil
+}
+
+// tgaColor converts a BGR(A) or 16 bit ARGB1555 pixel
+func tgaColor(px []byte, bits uint8, alpha bool) (color.NRGBA, error) {
+	switch bits {
+	case 15, 16:
+		v := binary.LittleEndian.Uint16(px)
+		c := color.NRGBA{
+			R: uint8((v >> 10 & 0x1f) * 255 / 31),
+			G: uint8((v >> 5 & 0x1f) * 255 / 31),
+			B: uint8((v & 0x1f) * 255 / 31),
+			A: 255,
+		}
+		if bits == 16 && alpha && v&0x8000 == 0 {
+			c.A = 0
+		}
+		return c, nil
+	case 24:
+		return color.NRGBA{px[2], px[1], px[0], 255}, nil
+	case 32:
+		c := color.NRGBA{px[2], px[1], px[0], 255}
+		if alpha {
+			c.A = px[3]
+		}
+		return c, nil
+	}
+	return color.NRGBA{}, fmt.Errorf("tga: unsupported pixel size %d", bits)
+}
+
+func tgaReadRLE(r *bufio.Reader, dst []byte, size int) error {
+	px := make([]byte, size)
+	for i := 0; i < len(dst); {
+		head, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("tga: %v", err)
+		}
+		count := int(head&0x7f) + 1
+		if i+count*size > len(dst) {
+			return errors.New("tga: run past the end of the image")
+		}
+		if head&0x80 != 0 {
+			if _, err := io.ReadFull(r, px); err != nil {
+				return fmt.Errorf("tga: %v", err)
+			}
+			for j := 0; j < count; j++ {
+				i += copy(dst[i:], px)
+			}
+			continue
+		}
+		if _, err := io.ReadFull(r, dst[i:i+count*size]); err != nil {
+			return fmt.Errorf("tga: %v", err)
+		}
+		i += count * size
+	}
+	return nil
+}
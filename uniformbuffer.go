@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// PerFrame is shared by every program that declares
+//
+//	layout(std140) uniform PerFrame { ... };
+//
+// see shaders/perframe.glsl.
+type PerFrame struct {
+	View           mgl32.Mat4
+	Projection     mgl32.Mat4
+	CameraPosition mgl32.Vec3
+	Time           float32
+}
+
+const PerFrameBinding = 0
+
+// uniformBlockBindings maps block names to the binding point programs are
+// connected to when they are linked.
+var uniformBlockBindings = map[string]uint32{
+	"PerFrame": PerFrameBinding,
+}
+
+// RegisterUniformBlock makes every program linked afterwards that declares
+// the named block read it from binding.
+func RegisterUniformBlock(name string, binding uint32) {
+	uniformBlockBindings[name] = binding
+}
+
+// bindUniformBlocks connects the program's blocks to their registered binding points
+func bindUniformBlocks(program uint32) {
+	var count, maxLength int32
+	gl.GetProgramiv(program, gl.ACTIVE_UNIFORM_BLOCKS, &count)
+	gl.GetProgramiv(program, gl.ACTIVE_UNIFORM_BLOCK_MAX_NAME_LENGTH, &maxLength)
+	for i := uint32(0); i < uint32(count); i++ {
+		var length int32
+		buf := make([]uint8, maxLength+1)
+		gl.GetActiveUniformBlockName(program, i, maxLength, &length, &buf[0])
+		if binding, ok := uniformBlockBindings[string(buf[:length])]; ok {
+			gl.UniformBlockBinding(program, i, binding)
+		}
+	}
+}
+
+// UniformBuffer holds one std140 block bound to a fixed binding point
+type UniformBuffer struct {
+	ID      uint32
+	Binding uint32
+	size    int
+
+	// scratch is encoded into on every Update
+	scratch []byte
+}
+
+// NewUniformBuffer allocates a buffer sized for the given struct
+func NewUniformBuffer(binding uint32, block interface{}) (*UniformBuffer, error) {
+	size, err := Std140Size(block)
+	if err != nil {
+		return nil, err
+	}
+	ub := &UniformBuffer{Binding: binding, size: size, scratch: make([]byte, size)}
+	gl.GenBuffers(1, &ub.ID)
+	gl.BindBuffer(gl.UNIFORM_BUFFER, ub.ID)
+	gl.BufferData(gl.UNIFORM_BUFFER, size, nil, gl.DYNAMIC_DRAW)
+	gl.BindBuffer(gl.UNIFORM_BUFFER, 0)
+	gl.BindBufferBase(gl.UNIFORM_BUFFER, binding, ub.ID)
+	return ub, nil
+}
+
+// Update encodes the block and uploads it
+func (ub *UniformBuffer) Update(block interface{}) error {
+	if err := EncodeStd140Into(ub.scratch, block); err != nil {
+		return fmt.Errorf("uniform buffer: %v", err)
+	}
+	gl.BindBuffer(gl.UNIFORM_BUFFER, ub.ID)
+	gl.BufferSubData(gl.UNIFORM_BUFFER, 0, ub.size, gl.Ptr(ub.scratch))
+	gl.BindBuffer(gl.UNIFORM_BUFFER, 0)
+	return nil
+}
+
+func (ub *UniformBuffer) Delete() {
+	gl.DeleteBuffers(1, &ub.ID)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// VFS combines directories, zip packs and other filesystems mounted at
+// virtual paths. A lookup goes through the mounts from the highest priority
+// down, mounts with equal priority are searched newest first, so a patch
+// mounted after the base content overrides it. Directory listings are merged.
+// Names must be valid fs.FS paths, use NormalizeVFSPath on anything else.
+type VFS struct {
+	// CaseInsensitive matches names regardless of case, for content made on
+	// case-insensitive filesystems
+	CaseInsensitive bool
+
+	mounts []*vfsMount
+	count  int
+}
+
+type vfsMount struct {
+	point    string
+	fsys     fs.FS
+	priority int
+	order    int
+	closer   io.Closer
+	folded   map[string]string // lower case path -> real path
+	foldMu   sync.Mutex        // textures are opened from loader goroutines
+}
+
+func NewVFS() *VFS {
+	return &VFS{}
+}
+
+// Mount adds a filesystem at a virtual directory, "" or "/" is the root
+func (v *VFS) Mount(point string, fsys fs.FS, priority int) {
+	v.mount(point, fsys, priority, nil)
+}
+
+func (v *VFS) mount(point string, fsys fs.FS, priority int, closer io.Closer) {
+	v.count++
+	v.mounts = append(v.mounts, &vfsMount{
+		point:    NormalizeVFSPath(point),
+		fsys:     fsys,
+		priority: priority,
+		order:    v.count,
+		closer:   closer,
+	})
+	sort.SliceStable(v.mounts, func(i, j int) bool {
+		if v.mounts[i].priority != v.mounts[j].priority {
+			return v.mounts[i].priority > v.mounts[j].priority
+		}
+		return v.mounts[i].order > v.mounts[j].order
+	})
+}
+
+func (v *VFS) MountDir(point, dir string, priority int) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cannot mount %q: not a directory", dir)
+	}
+	v.Mount(point, os.DirFS(dir), priority)
+	return nil
+}
+
+func (v *VFS) MountZip(point, file string, priority int) error {
+	r, err := zip.OpenReader(file)
+	if err != nil {
+		return err
+	}
+	v.mount(point, r, priority, r)
+	return nil
+}
+
+// MountPack mounts a .zip file or a directory
+func (v *VFS) MountPack(point, pack string, priority int) error {
+	if strings.EqualFold(path.Ext(pack), ".zip") {
+		return v.MountZip(point, pack, priority)
+	}
+	return v.MountDir(point, pack, priority)
+}
+
+// Close releases any open zip packs
+func (v *VFS) Close() error {
+	var first error
+	for _, m := range v.mounts {
+		if m.closer != nil {
+			if err := m.closer.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// NormalizeVFSPath turns the many ways a path gets written ("./a/b",
+// "a\b", "/a//b/") into the form fs.FS expects ("a/b"). The root is ".".
+func NormalizeVFSPath(name string) string {
+	name = strings.Replace(name, "\\", "/", -1)
+	name = path.Clean("/" + name)
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// resolve finds the path of name inside a mount, if the mount covers it
+func (v *VFS) resolve(m *vfsMount, name string) (string, bool) {
+	rel := name
+	if m.point != "." {
+		if name == m.point {
+			rel = "."
+		} else if strings.HasPrefix(name, m.point+"/") {
+			rel = strings.TrimPrefix(name, m.point+"/")
+		} else if v.CaseInsensitive && strings.HasPrefix(strings.ToLower(name), strings.ToLower(m.point)+"/") {
+			rel = name[len(m.point)+1:]
+		} else if v.CaseInsensitive && strings.EqualFold(name, m.point) {
+			rel = "."
+		} else {
+			return "", false
+		}
+	}
+	if v.CaseInsensitive && rel != "." {
+		return m.fold(rel)
+	}
+	return rel, true
+}
+
+// fold finds the real spelling of a path by matching each element without case
+func (m *vfsMount) fold(rel string) (string, bool) {
+	key := strings.ToLower(rel)
+	m.foldMu.Lock()
+	real, ok := m.folded[key]
+	m.foldMu.Unlock()
+	if ok {
+		return real, true
+	}
+	if _, err := fs.Stat(m.fsys, rel); err == nil {
+		return rel, true
+	}
+
+	dir := "."
+	for _, elem := range strings.Split(rel, "/") {
+		entries, err := fs.ReadDir(m.fsys, dir)
+		if err != nil {
+			return "", false
+		}
+		match := ""
+		for _, e := range entries {
+			if strings.EqualFold(e.Name(), elem) {
+				match = e.Name()
+				break
+			}
+		}
+		if match == "" {
+			return "", false
+		}
+		dir = path.Join(dir, match)
+	}
+	m.foldMu.Lock()
+	if m.folded == nil {
+		m.folded = map[string]string{}
+	}
+	m.folded[key] = dir
+	m.foldMu.Unlock()
+	return dir, true
+}
+
+// Open opens a file from the mount that wins for name. Directories list
+// the merged entries of every mount, as ReadDir does.
+func (v *VFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	for _, m := range v.mounts {
+		rel, ok := v.resolve(m, name)
+		if !ok {
+			continue
+		}
+		f, err := m.fsys.Open(rel)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if !info.IsDir() {
+			return f, nil
+		}
+		entries, err := v.ReadDir(name)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &vfsDir{File: f, info: mountedInfo(info, name, rel), entries: entries}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// vfsDir is a directory opened through the VFS, listing every mount
+type vfsDir struct {
+	fs.File
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *vfsDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *vfsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+// renamedInfo is the root of a mount seen under its mount point's name
+type renamedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i renamedInfo) Name() string {
+	return i.name
+}
+
+func mountedInfo(info fs.FileInfo, name, rel string) fs.FileInfo {
+	if rel == "." && name != "." {
+		return renamedInfo{info, path.Base(name)}
+	}
+	return info
+}
+
+func (v *VFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	for _, m := range v.mounts {
+		rel, ok := v.resolve(m, name)
+		if !ok {
+			continue
+		}
+		info, err := fs.Stat(m.fsys, rel)
+		if err == nil {
+			return mountedInfo(info, name, rel), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (v *VFS) ReadFile(name string) ([]byte, error) {
+	f, err := v.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (v *VFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	seen := map[string]bool{}
+	var entries []fs.DirEntry
+	found := false
+	for _, m := range v.mounts {
+		rel, ok := v.resolve(m, name)
+		if !ok {
+			continue
+		}
+		mountEntries, err := fs.ReadDir(m.fsys, rel)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, e := range mountEntries {
+			key := e.Name()
+			if v.CaseInsensitive {
+				key = strings.ToLower(key)
+			}
+			if !seen[key] {
+				seen[key] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testVFS() *VFS {
+	v := NewVFS()
+	v.Mount("", fstest.MapFS{
+		"shaders/a.vert":    {Data: []byte("base a")},
+		"shaders/b.frag":    {Data: []byte("base b")},
+		"textures/wall.png": {Data: []byte("base wall")},
+	}, 0)
+	v.Mount("", fstest.MapFS{
+		"shaders/a.vert": {Data: []byte("patch a")},
+		"readme.txt":     {Data: []byte("patch readme")},
+	}, 0)
+	v.Mount("shaders", fstest.MapFS{
+		"b.frag":     {Data: []byte("mod b")},
+		"lib/c.glsl": {Data: []byte("mod c")},
+	}, 10)
+	v.Mount("", fstest.MapFS{
+		"shaders/b.frag": {Data: []byte("low b")},
+		"low.txt":        {Data: []byte("low")},
+	}, -10)
+	return v
+}
+
+func TestVFS(t *testing.T) {
+	v := testVFS()
+	if err := fstest.TestFS(v, "shaders/a.vert", "shaders/b.frag", "shaders/lib/c.glsl", "textures/wall.png", "readme.txt", "low.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVFSPriority(t *testing.T) {
+	v := testVFS()
+	tests := map[string]string{
+		"shaders/a.vert":     "patch a", // same priority, mounted later wins
+		"shaders/b.frag":     "mod b",   // higher priority mount point wins
+		"shaders/lib/c.glsl": "mod c",
+		"textures/wall.png":  "base wall",
+		"low.txt":            "low", // a low priority mount still fills gaps
+	}
+	for name, want := range tests {
+		b, err := fs.ReadFile(v, name)
+		if err != nil || string(b) != want {
+			t.Errorf("%s: got %q, %v, want %q", name, b, err, want)
+		}
+	}
+	entries, err := fs.ReadDir(v, "shaders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if got := strings.Join(names, " "); got != "a.vert b.frag lib" {
+		t.Errorf("merged listing %q", got)
+	}
+}
+
+func TestVFSInvalidPaths(t *testing.T) {
+	v := testVFS()
+	for _, name := range []string{"/shaders/a.vert", "./shaders/a.vert", "shaders//a.vert", "shaders/../shaders/a.vert", "shaders/", ""} {
+		if _, err := v.Open(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Open(%q): %v, want fs.ErrInvalid", name, err)
+		}
+		if _, err := v.Stat(name); err == nil {
+			t.Errorf("Stat(%q) succeeded", name)
+		}
+	}
+	// a backslash is a valid name character, it just isn't a separator
+	if _, err := v.Open(`shaders\a.vert`); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf(`Open("shaders\\a.vert"): %v, want fs.ErrNotExist`, err)
+	}
+	if got := NormalizeVFSPath(`./shaders\\a.vert`); got != "shaders/a.vert" {
+		t.Errorf("NormalizeVFSPath gave %q", got)
+	}
+}
+
+func TestVFSCaseFolding(t *testing.T) {
+	v := testVFS()
+	if _, err := v.Open("Shaders/A.VERT"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("case sensitive lookup: %v, want fs.ErrNotExist", err)
+	}
+	v.CaseInsensitive = true
+	for name, want := range map[string]string{
+		"Shaders/A.VERT":     "patch a",
+		"SHADERS/B.frag":     "mod b",
+		"shaders/LIB/c.GLSL": "mod c",
+		"Textures/Wall.png":  "base wall",
+	} {
+		b, err := fs.ReadFile(v, name)
+		if err != nil || string(b) != want {
+			t.Errorf("%s: got %q, %v, want %q", name, b, err, want)
+		}
+	}
+	entries, err := fs.ReadDir(v, "SHADERS")
+	if err != nil || len(entries) != 3 {
+		t.Errorf("ReadDir(SHADERS) gave %d entries, %v, want 3", len(entries), err)
+	}
+}
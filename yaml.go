@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML reads the block style subset of YAML that hand written asset
+// files use: nested mappings, "- " sequences, [a, b] flow sequences, plain
+// and quoted scalars and # comments. Anchors, multi-line strings, flow
+// mappings and multiple documents are not supported. The result holds the
+// same types encoding/json decodes into, so it can be re-encoded as JSON
+// and unmarshalled into a struct.
+func parseYAML(data []byte) (interface{}, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, "\r")
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs can't be used for indentation", i+1)
+		}
+		text = strings.TrimSpace(stripYAMLComment(text))
+		if text == "" || text == "---" {
+			continue
+		}
+		lines = append(lines, yamlLine{indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text, n: i + 1})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	v, rest, err := parseYAMLBlock(lines, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("yaml: line %d: bad indentation", rest[0].n)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	indent int
+	text   string
+	n      int
+}
+
+// stripYAMLComment cuts a # that starts the line or follows a space,
+// outside quotes
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseYAMLBlock parses the mapping or sequence whose lines start at indent
+// and returns the lines after it
+func parseYAMLBlock(lines []yamlLine, indent int) (interface{}, []yamlLine, error) {
+	if isYAMLSequenceItem(lines[0].text) {
+		return parseYAMLSequence(lines, indent)
+	}
+	return parseYAMLMapping(lines, indent)
+}
+
+func parseYAMLSequence(lines []yamlLine, indent int) (interface{}, []yamlLine, error) {
+	items := []interface{}{}
+	for len(lines) > 0 && lines[0].indent == indent && isYAMLSequenceItem(lines[0].text) {
+		line := lines[0]
+		item := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		switch {
+		case item == "":
+			// the item is the indented block below
+			if len(lines) < 2 || lines[1].indent <= indent {
+				items = append(items, nil)
+				lines = lines[1:]
+				continue
+			}
+			v, rest, err := parseYAMLBlock(lines[1:], lines[1].indent)
+			if err != nil {
+				return nil, nil, err
+			}
+			items, lines = append(items, v), rest
+		case yamlKey(item) >= 0 || isYAMLSequenceItem(item):
+			// "- key: value" starts a mapping indented to the key
+			nested := append([]yamlLine{{indent: line.indent + len(line.text) - len(item), text: item, n: line.n}}, lines[1:]...)
+			v, rest, err := parseYAMLBlock(nested, nested[0].indent)
+			if err != nil {
+				return nil, nil, err
+			}
+			items, lines = append(items, v), rest
+		default:
+			v, err := parseYAMLScalar(item, line.n)
+			if err != nil {
+				return nil, nil, err
+			}
+			items, lines = append(items, v), lines[1:]
+		}
+	}
+	if len(lines) > 0 && lines[0].indent > indent {
+		return nil, nil, fmt.Errorf("yaml: line %d: bad indentation", lines[0].n)
+	}
+	return items, lines, nil
+}
+
+func parseYAMLMapping(lines []yamlLine, indent int) (interface{}, []yamlLine, error) {
+	m := map[string]interface{}{}
+	for len(lines) > 0 && lines[0].indent == indent {
+		line := lines[0]
+		colon := yamlKey(line.text)
+		if colon < 0 {
+			return nil, nil, fmt.Errorf("yaml: line %d: expected \"key: value\"", line.n)
+		}
+		key, err := parseYAMLScalar(strings.TrimSpace(line.text[:colon]), line.n)
+		if err != nil {
+			return nil, nil, err
+		}
+		name := fmt.Sprint(key)
+		if _, ok := m[name]; ok {
+			return nil, nil, fmt.Errorf("yaml: line %d: duplicate key %q", line.n, name)
+		}
+		value := strings.TrimSpace(line.text[colon+1:])
+		lines = lines[1:]
+
+		if value != "" {
+			if m[name], err = parseYAMLScalar(value, line.n); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		// A sequence may sit at the same indent as its key
+		if len(lines) > 0 && (lines[0].indent > indent || (lines[0].indent == indent && isYAMLSequenceItem(lines[0].text))) {
+			if m[name], lines, err = parseYAMLBlock(lines, lines[0].indent); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		m[name] = nil
+	}
+	if len(lines) > 0 && lines[0].indent > indent {
+		return nil, nil, fmt.Errorf("yaml: line %d: bad indentation", lines[0].n)
+	}
+	return m, lines, nil
+}
+
+// yamlKey is the index of the colon ending the key of a "key: value" line,
+// or -1
+func yamlKey(text string) int {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case i == 0 && (c == '"' || c == '\''):
+			quote = c
+		case i == 0 && c == '[':
+			return -1
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+// parseYAMLScalar converts a scalar or flow sequence to a JSON value
+func parseYAMLScalar(s string, n int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("yaml: line %d: unterminated [", n)
+		}
+		items := []interface{}{}
+		inner := strings.TrimSpace(s[1 : len(s)-1])
+		if inner == "" {
+			return items, nil
+		}
+		for _, part := range splitYAMLFlow(inner) {
+			v, err := parseYAMLScalar(strings.TrimSpace(part), n)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case strings.HasPrefix(s, "\""):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("yaml: line %d: bad string %s", n, s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("yaml: line %d: bad string %s", n, s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case strings.HasPrefix(s, "{") || strings.HasPrefix(s, "&") || strings.HasPrefix(s, "*") || strings.HasPrefix(s, "|") || strings.HasPrefix(s, ">"):
+		return nil, fmt.Errorf("yaml: line %d: %q is not supported", n, s[:1])
+	}
+	switch s {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return s, nil
+}
+
+// splitYAMLFlow splits the inside of a flow sequence at top level commas
+func splitYAMLFlow(s string) []string {
+	var parts []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}